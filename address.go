@@ -1,9 +1,12 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/julienschmidt/httprouter"
@@ -16,9 +19,88 @@ type Addresses struct {
 	Province   string  `json:"province"`
 	Country    string  `json:"country" validate:"required"`
 	PostalCode string  `json:"postal_code"`
-	ContactId  string  `json:"contact_id" validate:"required"`
+	ContactId  string  `json:"contact_id,omitempty" validate:"required"`
+	CompanyId  *string `json:"company_id,omitempty"`
+	Version    int64   `json:"version"`
 	CreatedAt  *string `json:"created_at"`
 	UpdatedAt  *string `json:"updated_at"`
+	DeletedAt  *string `json:"deleted_at,omitempty"`
+}
+
+const addressColumns = "address_id, street, city, province, country, postal_code, contact_id, company_id, version, created_at, updated_at, deleted_at"
+
+// scanAddress - An address belongs to either a contact or a company, so
+// contact_id may be NULL
+func scanAddress(row rowScanner, address *Addresses) error {
+	var contactId sql.NullString
+	err := row.Scan(&address.AddressId, &address.Street, &address.City, &address.Province, &address.Country, &address.PostalCode, &contactId, &address.CompanyId, &address.Version, &address.CreatedAt, &address.UpdatedAt, &address.DeletedAt)
+	address.ContactId = contactId.String
+	return err
+}
+
+func insertAddressRow(exec sqlExecutor, address Addresses, actor historyActor) error {
+	result, err := exec.Exec("INSERT INTO addresses (street, city, province, country, postal_code, contact_id) VALUES (?, ?, ?, ?, ?, ?)", address.Street, address.City, address.Province, address.Country, address.PostalCode, address.ContactId)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	return recordAddressHistory(exec, actor, "created", "address_id = ?", id)
+}
+
+// execAddressUpdate - Run an UPDATE of one address and snapshot the result
+// in the same transaction. Returns the number of rows updated.
+func execAddressUpdate(db *sql.DB, actor historyActor, action string, addressId string, query string, args ...any) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.RowsAffected()
+	if n == 0 {
+		return 0, nil
+	}
+	if err := recordAddressHistory(tx, actor, action, "address_id = ?", addressId); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// loadAddressesByContact - Fetch the addresses of many contacts in one query,
+// keyed by contact_id. Callers must have already scoped contactIds to the user.
+func loadAddressesByContact(contactIds []string) (map[string][]Addresses, error) {
+	result := map[string][]Addresses{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(contactIds)-1) + "?"
+	args := make([]any, len(contactIds))
+	for i, id := range contactIds {
+		args[i] = id
+	}
+
+	rows, err := GetDB().Query("SELECT "+addressColumns+" FROM addresses WHERE contact_id IN ("+placeholders+") AND deleted_at IS NULL ORDER BY address_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var address Addresses
+		if err := scanAddress(rows, &address); err != nil {
+			return nil, err
+		}
+		result[address.ContactId] = append(result[address.ContactId], address)
+	}
+	return result, rows.Err()
 }
 
 func CreateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -59,8 +141,10 @@ func CreateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	db := GetDB()
 
+	ctxUser := r.Context().Value("user").(Users)
+
 	var count int
-	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ?", address.ContactId).Scan(&count)
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND deleted_at IS NULL", address.ContactId).Scan(&count)
 	if count == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -70,7 +154,14 @@ func CreateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO addresses (street, city, province, country, postal_code, contact_id) VALUES (?, ?, ?, ?, ?, ?)", address.Street, address.City, address.Province, address.Country, address.PostalCode, address.ContactId)
+	tx, err := db.Begin()
+	if err == nil {
+		defer tx.Rollback()
+		err = insertAddressRow(tx, address, historyActor{UserId: ctxUser.UserId, Source: "api"})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		fmt.Println("Error disini", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -102,7 +193,7 @@ func GetAddresses(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	db := GetDB()
 
 	var count int
-	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ?", ps.ByName("contactId")).Scan(&count)
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND deleted_at IS NULL", ps.ByName("contactId")).Scan(&count)
 	if count == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -112,7 +203,7 @@ func GetAddresses(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	rows, err := db.Query("SELECT * FROM addresses WHERE contact_id = ?", ps.ByName("contactId"))
+	rows, err := db.Query("SELECT "+addressColumns+" FROM addresses WHERE contact_id = ? AND deleted_at IS NULL", ps.ByName("contactId"))
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
@@ -127,7 +218,7 @@ func GetAddresses(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	var addresses []Addresses
 	for rows.Next() {
 		var address Addresses
-		err = rows.Scan(&address.AddressId, &address.Street, &address.City, &address.Province, &address.Country, &address.PostalCode, &address.ContactId, &address.CreatedAt, &address.UpdatedAt)
+		err = scanAddress(rows, &address)
 		if err != nil {
 			fmt.Println("Error disini", err)
 			w.Header().Set("Content-Type", "application/json")
@@ -140,6 +231,14 @@ func GetAddresses(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		addresses = append(addresses, address)
 	}
 
+	parts := []string{ps.ByName("contactId")}
+	for _, address := range addresses {
+		parts = append(parts, addressETag(address))
+	}
+	if notModified(w, r, combinedETag(parts...)) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]any{
@@ -152,7 +251,7 @@ func GetAddressId(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	db := GetDB()
 
 	var count int
-	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ?", ps.ByName("contactId")).Scan(&count)
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND deleted_at IS NULL", ps.ByName("contactId")).Scan(&count)
 	if count == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -163,7 +262,7 @@ func GetAddressId(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	}
 
 	var address Addresses
-	err := db.QueryRow("SELECT * FROM addresses WHERE address_id = ?", ps.ByName("addressId")).Scan(&address.AddressId, &address.Street, &address.City, &address.Province, &address.Country, &address.PostalCode, &address.ContactId, &address.CreatedAt, &address.UpdatedAt)
+	err := scanAddress(db.QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ? AND contact_id = ? AND deleted_at IS NULL", ps.ByName("addressId"), ps.ByName("contactId")), &address)
 	if err != nil {
 		fmt.Println("Error disini", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -174,6 +273,10 @@ func GetAddressId(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
+	if notModified(w, r, addressETag(address)) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]any{
@@ -223,8 +326,10 @@ func UpdateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	db := GetDB()
 
+	ctxUser := r.Context().Value("user").(Users)
+
 	var count int
-	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ?", ps.ByName("contactId")).Scan(&count)
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND deleted_at IS NULL", ps.ByName("contactId")).Scan(&count)
 	if count == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -234,7 +339,14 @@ func UpdateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	row, err := db.Exec("UPDATE addresses SET street = ?, city = ?, province = ?, country = ?, postal_code = ? WHERE address_id = ? AND contact_id = ?", address.Street, address.City, address.Province, address.Country, address.PostalCode, ps.ByName("addressId"), ps.ByName("contactId"))
+	current, ok := loadAddressForWrite(w, r, ps.ByName("contactId"), ps.ByName("addressId"))
+	if !ok {
+		return
+	}
+
+	query, args := versionedUpdate("UPDATE addresses SET street = ?, city = ?, province = ?, country = ?, postal_code = ?, version = version + 1 WHERE address_id = ? AND contact_id = ? AND deleted_at IS NULL", r, current.Version,
+		address.Street, address.City, address.Province, address.Country, address.PostalCode, current.AddressId, current.ContactId)
+	countRow, err := execAddressUpdate(db, historyActor{UserId: ctxUser.UserId, Source: "api"}, "updated", current.AddressId, query, args...)
 	if err != nil {
 		fmt.Println("Error disini", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -245,17 +357,16 @@ func UpdateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	countRow, err := row.RowsAffected()
 	if countRow == 0 {
 		fmt.Println("Error disini", err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Address not found",
-		})
+		writeStaleOrMissing(w, r, "Address not found")
 		return
 	}
 
+	if err := scanAddress(db.QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ?", current.AddressId), &current); err == nil {
+		w.Header().Set("ETag", addressETag(current))
+	}
+
 	data := map[string]any{
 		"street":      address.Street,
 		"city":        address.City,
@@ -275,8 +386,10 @@ func UpdateAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 func DeleteAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	db := GetDB()
 
+	ctxUser := r.Context().Value("user").(Users)
+
 	var count int
-	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ?", ps.ByName("contactId")).Scan(&count)
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND deleted_at IS NULL", ps.ByName("contactId")).Scan(&count)
 	if count == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
@@ -286,7 +399,13 @@ func DeleteAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	row, err := db.Exec("DELETE FROM addresses WHERE address_id = ? AND contact_id = ?", ps.ByName("addressId"), ps.ByName("contactId"))
+	current, ok := loadAddressForWrite(w, r, ps.ByName("contactId"), ps.ByName("addressId"))
+	if !ok {
+		return
+	}
+
+	query, args := versionedUpdate("UPDATE addresses SET deleted_at = ?, version = version + 1 WHERE address_id = ? AND contact_id = ? AND deleted_at IS NULL", r, current.Version, softDeleteTime(), current.AddressId, current.ContactId)
+	countRow, err := execAddressUpdate(db, historyActor{UserId: ctxUser.UserId, Source: "api"}, "deleted", current.AddressId, query, args...)
 	if err != nil {
 		fmt.Println("Error disini", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -297,20 +416,129 @@ func DeleteAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
-	countRow, err := row.RowsAffected()
 	if countRow == 0 {
 		fmt.Println("Error disini", err)
+		writeStaleOrMissing(w, r, "Address not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Address deleted successfully",
+	})
+}
+
+func PatchAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("contactId"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]string{
-			"message": "Address not found",
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	current, ok := loadAddressForWrite(w, r, ps.ByName("contactId"), ps.ByName("addressId"))
+	if !ok {
+		return
+	}
+
+	doc, err := toJSONDocument(current)
+	if err == nil {
+		doc, err = applyRequestPatch(r, doc)
+	}
+	var patched Addresses
+	if err == nil {
+		err = fromJSONDocument(doc, &patched)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(patchErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// identifiers and timestamps are not patchable
+	patched.AddressId = current.AddressId
+	patched.ContactId = current.ContactId
+	patched.CompanyId = current.CompanyId
+	patched.Version = current.Version
+	patched.CreatedAt = current.CreatedAt
+	patched.UpdatedAt = current.UpdatedAt
+
+	validate := validator.New()
+	if err = validate.Struct(patched); err != nil {
+		errors := err.(validator.ValidationErrors)
+		errMsgs := []string{}
+		for _, e := range errors {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s is %s", e.Field(), e.ActualTag()))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
 		})
 		return
 	}
 
+	query, args := versionedUpdate("UPDATE addresses SET street = ?, city = ?, province = ?, country = ?, postal_code = ?, version = version + 1 WHERE address_id = ? AND contact_id = ? AND deleted_at IS NULL", r, current.Version,
+		patched.Street, patched.City, patched.Province, patched.Country, patched.PostalCode, current.AddressId, current.ContactId)
+	countRow, err := execAddressUpdate(db, historyActor{UserId: ctxUser.UserId, Source: "api"}, "updated", current.AddressId, query, args...)
+	if err != nil {
+		log.Printf("Address %s patch failed: %v", current.AddressId, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if countRow == 0 {
+		writeStaleOrMissing(w, r, "Address not found")
+		return
+	}
+
+	if err := scanAddress(db.QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ?", current.AddressId), &patched); err == nil {
+		w.Header().Set("ETag", addressETag(patched))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]any{
-		"message": "Address deleted successfully",
+		"message": "Address updated successfully",
+		"data":    patched,
 	})
 }
+
+// loadAddressForWrite - Fetch the address about to be modified and enforce
+// If-Match. Writes the 404 / 412 response itself and returns false on failure.
+func loadAddressForWrite(w http.ResponseWriter, r *http.Request, contactId string, addressId string) (Addresses, bool) {
+	var address Addresses
+	err := scanAddress(GetDB().QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ? AND contact_id = ? AND deleted_at IS NULL", addressId, contactId), &address)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Address not found",
+		})
+		return address, false
+	}
+
+	if ifMatchFails(r, addressETag(address)) {
+		writePreconditionFailed(w)
+		return address, false
+	}
+	return address, true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// deleted_at has to come from the same clock as the purge cutoff, also
+// when MySQL doesn't run in UTC
+func TestDeleteAddressSoftDeleteTime(t *testing.T) {
+	openTestDB(t)
+	setTestTimeZone(t, "+07:00")
+	user := createTestUser(t, "address@example.com")
+
+	result, err := GetDB().Exec("INSERT INTO contacts (first_name, last_name, email, phone, user_id) VALUES ('Budi', 'Santoso', 'budi@example.com', '+6281234567890', ?)", user.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contactId, _ := result.LastInsertId()
+	result, err = GetDB().Exec("INSERT INTO addresses (street, city, province, country, postal_code, contact_id) VALUES ('Jl. Merdeka 1', 'Bandung', 'Jawa Barat', 'Indonesia', '40111', ?)", contactId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addressId, _ := result.LastInsertId()
+
+	router := httprouter.New()
+	router.DELETE("/address/:contactId/:addressId", AuthMiddleware(DeleteAddress))
+
+	before := time.Now().UTC().Truncate(time.Second)
+	if code, response := serveJSON(t, router, "DELETE", fmt.Sprintf("/address/%d/%d", contactId, addressId), user, ""); code != 200 {
+		t.Fatalf("delete: got %d %v", code, response)
+	}
+	after := time.Now().UTC()
+
+	var deletedAt time.Time
+	if err := GetDB().QueryRow("SELECT deleted_at FROM addresses WHERE address_id = ?", addressId).Scan(&deletedAt); err != nil {
+		t.Fatal(err)
+	}
+	if deletedAt.Before(before) || deletedAt.After(after) {
+		t.Fatalf("got deleted_at %s, want between %s and %s", deletedAt, before, after)
+	}
+
+	// kept for the retention period, purged after it
+	if err := purgeTrash(after.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	GetDB().QueryRow("SELECT COUNT(*) FROM addresses WHERE address_id = ?", addressId).Scan(&count)
+	if count != 1 {
+		t.Fatal("the address was purged before its retention ended")
+	}
+	if err := purgeTrash(after.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	GetDB().QueryRow("SELECT COUNT(*) FROM addresses WHERE address_id = ?", addressId).Scan(&count)
+	if count != 0 {
+		t.Error("the address was not purged after its retention ended")
+	}
+}
+
+func TestPatchAddress(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "patch@example.com")
+	contactId := createTestContact(t, user, "Budi")
+	addressId := createTestAddress(t, contactId)
+	path := "/address/" + contactId + "/" + addressId
+
+	router := httprouter.New()
+	router.PATCH("/address/:contactId/:addressId", AuthMiddleware(PatchAddress))
+	mergePatch := http.Header{"Content-Type": {"application/merge-patch+json"}}
+
+	// null clears a field, omitted fields keep their value
+	w := serve(t, router, "PATCH", path, user, `{"street": null, "city": "Bogor"}`, mergePatch)
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge patch: got %d %s", w.Code, w.Body.String())
+	}
+	var address Addresses
+	if err := scanAddress(GetDB().QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ?", addressId), &address); err != nil {
+		t.Fatal(err)
+	}
+	if address.Street != "" || address.City != "Bogor" {
+		t.Errorf("got street %q and city %q, want the street cleared and Bogor", address.Street, address.City)
+	}
+	if address.Province != "Jawa Barat" || address.Country != "Indonesia" || address.PostalCode != "40111" {
+		t.Errorf("fields missing from the patch changed: %+v", address)
+	}
+	if address.Version != 2 || w.Header().Get("ETag") != addressETag(address) {
+		t.Errorf("got version %d and ETag %q, want version 2 and %q", address.Version, w.Header().Get("ETag"), addressETag(address))
+	}
+
+	// the version moved on, the old ETag is stale
+	stale := http.Header{"Content-Type": {"application/merge-patch+json"}, "If-Match": {`"a` + addressId + `-1"`}}
+	if w := serve(t, router, "PATCH", path, user, `{"city": "Depok"}`, stale); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match: got %d %s, want 412", w.Code, w.Body.String())
+	}
+	current := http.Header{"Content-Type": {"application/merge-patch+json"}, "If-Match": {addressETag(address)}}
+	if w := serve(t, router, "PATCH", path, user, `{"city": "Depok"}`, current); w.Code != http.StatusOK {
+		t.Errorf("current If-Match: got %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	for _, body := range []string{
+		`{"country": null}`,
+		`{"postal_code": 40111}`,
+		`{"city": `,
+	} {
+		if w := serve(t, router, "PATCH", path, user, body, mergePatch); w.Code != http.StatusBadRequest {
+			t.Errorf("PATCH %s: got %d %s, want 400", body, w.Code, w.Body.String())
+		}
+	}
+	if w := serve(t, router, "PATCH", path, user, `{"city": "Depok"}`, http.Header{"Content-Type": {"text/plain"}}); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain patch: got %d, want 415", w.Code)
+	}
+
+	var city string
+	GetDB().QueryRow("SELECT city FROM addresses WHERE address_id = ?", addressId).Scan(&city)
+	if city != "Depok" {
+		t.Errorf("got city %q after the rejected patches, want Depok", city)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+type AppPasswords struct {
+	AppPasswordId int64   `json:"app_password_id"`
+	Name          string  `json:"name" validate:"required,max=100"`
+	Password      string  `json:"password,omitempty"`
+	CreatedAt     *string `json:"created_at,omitempty"`
+	LastUsedAt    *string `json:"last_used_at"`
+}
+
+func hashAppPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAppPassword - Generate a password for HTTP Basic clients. The
+// plaintext is only returned in this response.
+func CreateAppPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var appPassword AppPasswords
+	if err := json.NewDecoder(r.Body).Decode(&appPassword); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(appPassword); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	appPassword.Password = hex.EncodeToString(secret)
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	result, err := db.Exec("INSERT INTO app_passwords (user_id, name, password_hash) VALUES (?, ?, ?)", ctxUser.UserId, appPassword.Name, hashAppPassword(appPassword.Password))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	appPassword.AppPasswordId, _ = result.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "App password created successfully, it will not be shown again",
+		"data":    appPassword,
+	})
+}
+
+func GetAppPasswords(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rows, err := db.Query("SELECT app_password_id, name, created_at, last_used_at FROM app_passwords WHERE user_id = ? ORDER BY app_password_id", ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	appPasswords := []AppPasswords{}
+	for rows.Next() {
+		var appPassword AppPasswords
+		if err := rows.Scan(&appPassword.AppPasswordId, &appPassword.Name, &appPassword.CreatedAt, &appPassword.LastUsedAt); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		appPasswords = append(appPasswords, appPassword)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    appPasswords,
+	})
+}
+
+func DeleteAppPassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	result, err := db.Exec("DELETE FROM app_passwords WHERE app_password_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "App password not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "App password deleted successfully",
+	})
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ContactAttachments - A file attached to a contact. The blob key doubles
+// as the key signing download URLs, so it never leaves the server.
+type ContactAttachments struct {
+	AttachmentId      string  `json:"attachment_id"`
+	ContactId         string  `json:"contact_id"`
+	Filename          string  `json:"filename"`
+	ContentType       string  `json:"content_type"`
+	Size              int64   `json:"size"`
+	CreatedAt         *string `json:"created_at,omitempty"`
+	DownloadURL       string  `json:"download_url"`
+	DownloadExpiresAt string  `json:"download_expires_at"`
+	blobKey           string
+}
+
+// AttachmentQuota - Bytes used by all of a user's attachments, including
+// those of trashed contacts until they are purged
+type AttachmentQuota struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	LimitBytes int64 `json:"limit_bytes"`
+}
+
+const contactAttachmentColumns = "a.attachment_id, a.contact_id, a.blob_key, a.filename, a.content_type, a.size, a.created_at"
+
+func scanContactAttachment(row rowScanner, attachment *ContactAttachments) error {
+	return row.Scan(&attachment.AttachmentId, &attachment.ContactId, &attachment.blobKey, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.CreatedAt)
+}
+
+func attachmentBlobKey(blobKey string) string {
+	return "attachments/" + blobKey
+}
+
+// deleteAttachmentBlob - Failures are only logged: the database no longer
+// points at the blob.
+func deleteAttachmentBlob(blobKey string) {
+	if err := GetBlobStore().Delete(context.Background(), attachmentBlobKey(blobKey)); err != nil {
+		log.Printf("Failed to delete attachment blob: %v", err)
+	}
+}
+
+func attachmentQuotaLimit() int64 {
+	return int64(importEnvInt("ATTACHMENT_QUOTA_BYTES", 100<<20))
+}
+
+func attachmentQuota(exec sqlExecutor, userId int64) (AttachmentQuota, error) {
+	quota := AttachmentQuota{LimitBytes: attachmentQuotaLimit()}
+	err := exec.QueryRow("SELECT COALESCE(SUM(size), 0) FROM contact_attachments WHERE user_id = ?", userId).Scan(&quota.UsedBytes)
+	return quota, err
+}
+
+// attachmentSignature - HMAC of the attachment id and expiry, keyed by the
+// attachment's secret blob key
+func attachmentSignature(attachment ContactAttachments, expires int64) string {
+	return hex.EncodeToString(hmacSHA256([]byte(attachment.blobKey), attachment.AttachmentId+":"+strconv.FormatInt(expires, 10)))
+}
+
+// sign - Set a download URL valid for ATTACHMENT_URL_TTL
+func (a *ContactAttachments) sign(r *http.Request) {
+	ttl, err := time.ParseDuration(getEnv("ATTACHMENT_URL_TTL", "15m"))
+	if err != nil || ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	expires := time.Now().Add(ttl).Unix()
+	a.DownloadURL = appBaseURL(r) + "/attachment/" + a.AttachmentId + "?expires=" + strconv.FormatInt(expires, 10) + "&signature=" + attachmentSignature(*a, expires)
+	a.DownloadExpiresAt = time.Unix(expires, 0).UTC().Format(time.RFC3339)
+}
+
+// attachmentFilename - The last path element of an uploaded file name,
+// without control characters
+func attachmentFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[:255])
+	}
+	if name == "" || name == "." || name == ".." {
+		name = "attachment"
+	}
+	return name
+}
+
+func findContactAttachment(exec sqlExecutor, attachmentId string, contactId string, userId int64) (ContactAttachments, error) {
+	var attachment ContactAttachments
+	err := scanContactAttachment(exec.QueryRow("SELECT "+contactAttachmentColumns+" FROM contact_attachments a JOIN contacts c ON c.contact_id = a.contact_id WHERE a.attachment_id = ? AND a.contact_id = ? AND c.user_id = ? AND c.deleted_at IS NULL", attachmentId, contactId, userId), &attachment)
+	return attachment, err
+}
+
+// insertContactAttachment - Record an uploaded blob, unless it would take
+// the user over quota. The user row is locked so concurrent uploads can't
+// both pass the check.
+func insertContactAttachment(db *sql.DB, userId int64, contactId string, attachment ContactAttachments) (ContactAttachments, AttachmentQuota, bool, error) {
+	var quota AttachmentQuota
+	tx, err := db.Begin()
+	if err != nil {
+		return attachment, quota, false, err
+	}
+	defer tx.Rollback()
+
+	var locked int64
+	if err := tx.QueryRow("SELECT user_id FROM users WHERE user_id = ? FOR UPDATE", userId).Scan(&locked); err != nil {
+		return attachment, quota, false, err
+	}
+	if quota, err = attachmentQuota(tx, userId); err != nil {
+		return attachment, quota, false, err
+	}
+	if quota.UsedBytes+attachment.Size > quota.LimitBytes {
+		return attachment, quota, false, nil
+	}
+
+	result, err := tx.Exec("INSERT INTO contact_attachments (user_id, contact_id, blob_key, filename, content_type, size) SELECT ?, contact_id, ?, ?, ?, ? FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", userId, attachment.blobKey, attachment.Filename, attachment.ContentType, attachment.Size, contactId, userId)
+	if err != nil {
+		return attachment, quota, false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// the contact was deleted while the file was uploading
+		return attachment, quota, false, sql.ErrNoRows
+	}
+	id, _ := result.LastInsertId()
+	stored, err := findContactAttachment(tx, strconv.FormatInt(id, 10), contactId, userId)
+	if err != nil {
+		return attachment, quota, false, err
+	}
+	quota.UsedBytes += stored.Size
+	return stored, quota, true, tx.Commit()
+}
+
+// GetContactAttachments - A contact's attachments, newest first, with the
+// user's quota
+func GetContactAttachments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	rows, err := db.Query("SELECT "+contactAttachmentColumns+" FROM contact_attachments a WHERE a.contact_id = ? ORDER BY a.attachment_id DESC", ps.ByName("id"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	attachments := []ContactAttachments{}
+	for rows.Next() {
+		var attachment ContactAttachments
+		if err := scanContactAttachment(rows, &attachment); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		attachment.sign(r)
+		attachments = append(attachments, attachment)
+	}
+
+	quota, err := attachmentQuota(db, ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    attachments,
+		"quota":   quota,
+	})
+}
+
+// UploadContactAttachment - POST /contact/:id/attachments, multipart field
+// "file". The content type is sniffed from the content.
+func UploadContactAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	maxBytes := int64(importEnvInt("ATTACHMENT_MAX_BYTES", 10<<20))
+	// room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	// larger files are spooled to disk by ParseMultipartForm
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("Attachment must be a multipart upload of at most %d bytes", maxBytes),
+		})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"file is required"},
+		})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxBytes {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("Attachment must be at most %d bytes", maxBytes),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	// cheap check before storing anything; insertContactAttachment repeats
+	// it under a lock
+	quota, err := attachmentQuota(db, ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if quota.UsedBytes+header.Size > quota.LimitBytes {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Attachment quota exceeded",
+			"quota":   quota,
+		})
+		return
+	}
+
+	mt, err := mimetype.DetectReader(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	secret := make([]byte, 16)
+	if err == nil {
+		_, err = rand.Read(secret)
+	}
+	attachment := ContactAttachments{
+		Filename: attachmentFilename(header.Filename),
+		Size:     header.Size,
+		blobKey:  hex.EncodeToString(secret),
+	}
+	if err == nil {
+		attachment.ContentType = mt.String()
+		err = GetBlobStore().Put(r.Context(), attachmentBlobKey(attachment.blobKey), file, header.Size, attachment.ContentType)
+	}
+	if err != nil {
+		log.Printf("Failed to store attachment: %v", err)
+		deleteAttachmentBlob(attachment.blobKey)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	attachment, quota, inserted, err := insertContactAttachment(db, ctxUser.UserId, ps.ByName("id"), attachment)
+	if err != nil || !inserted {
+		deleteAttachmentBlob(attachment.blobKey)
+	}
+	if err == sql.ErrNoRows {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !inserted {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Attachment quota exceeded",
+			"quota":   quota,
+		})
+		return
+	}
+	attachment.sign(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Attachment uploaded successfully",
+		"data":    attachment,
+		"quota":   quota,
+	})
+}
+
+// GetContactAttachmentId - An attachment with a fresh download URL
+func GetContactAttachmentId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	attachment, err := findContactAttachment(GetDB(), ps.ByName("attachmentId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Attachment not found",
+		})
+		return
+	}
+	attachment.sign(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    attachment,
+	})
+}
+
+func DeleteContactAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	attachment, err := findContactAttachment(db, ps.ByName("attachmentId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Attachment not found",
+		})
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM contact_attachments WHERE attachment_id = ?", attachment.AttachmentId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	deleteAttachmentBlob(attachment.blobKey)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Attachment deleted successfully",
+	})
+}
+
+// DownloadAttachment - GET /attachment/:id?expires=..&signature=.., the
+// signed URL handed out with an attachment. Needs no token, so it works in
+// a browser or a mail client.
+func DownloadAttachment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	forbidden := func(message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(403)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": message,
+		})
+	}
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		forbidden("Invalid download link")
+		return
+	}
+	if time.Now().Unix() > expires {
+		forbidden("Download link expired")
+		return
+	}
+
+	var attachment ContactAttachments
+	err = scanContactAttachment(GetDB().QueryRow("SELECT "+contactAttachmentColumns+" FROM contact_attachments a JOIN contacts c ON c.contact_id = a.contact_id WHERE a.attachment_id = ? AND c.deleted_at IS NULL", ps.ByName("id")), &attachment)
+	// an unknown attachment looks like a bad signature, so ids can't be probed
+	if err != nil || !hmac.Equal([]byte(r.URL.Query().Get("signature")), []byte(attachmentSignature(attachment, expires))) {
+		forbidden("Invalid download link")
+		return
+	}
+
+	body, length, err := GetBlobStore().Get(r.Context(), attachmentBlobKey(attachment.blobKey))
+	if err != nil {
+		log.Printf("Failed to read attachment: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(200)
+	io.Copy(w, body)
+}
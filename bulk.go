@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	bulkModeAtomic  = "atomic"
+	bulkModePartial = "partial"
+)
+
+type bulkOperation struct {
+	Op        string    `json:"op"`
+	ContactId string    `json:"contact_id"`
+	Version   *int64    `json:"version"`
+	Data      *Contacts `json:"data"`
+}
+
+type bulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+type bulkResult struct {
+	Index     int      `json:"index"`
+	Op        string   `json:"op"`
+	Status    int      `json:"status"`
+	ContactId string   `json:"contact_id,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+func bulkMaxOperations() int {
+	n, err := strconv.Atoi(getEnv("BULK_MAX_OPERATIONS", "1000"))
+	if err != nil || n <= 0 {
+		return 1000
+	}
+	return n
+}
+
+// validationMessages - Format validator errors the same way the handlers do
+func validationMessages(err error) []string {
+	errMsgs := []string{}
+	errors, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []string{err.Error()}
+	}
+	for _, e := range errors {
+		errMsgs = append(errMsgs, fmt.Sprintf("%s is %s", e.Field(), e.ActualTag()))
+	}
+	return errMsgs
+}
+
+// validateBulkOperation - Check an operation before anything is executed,
+// using the same rules as CreateContact / UpdateContact
+func validateBulkOperation(validate *validator.Validate, fields map[string]CustomFields, region string, op bulkOperation) []string {
+	switch op.Op {
+	case "create":
+		if op.Data == nil {
+			return []string{"data is required"}
+		}
+	case "update":
+		if op.ContactId == "" {
+			return []string{"contact_id is required"}
+		}
+		if op.Data == nil {
+			return []string{"data is required"}
+		}
+	case "delete":
+		if op.ContactId == "" {
+			return []string{"contact_id is required"}
+		}
+		return nil
+	default:
+		return []string{fmt.Sprintf("op %q is not supported", op.Op)}
+	}
+
+	if err := validate.Struct(*op.Data); err != nil {
+		return validationMessages(err)
+	}
+	if err := normalizeContactPhone(op.Data, region, nil); err != nil {
+		return []string{err.Error()}
+	}
+	if errMsgs := validateCustomFieldValues(fields, op.Data.CustomFields); len(errMsgs) > 0 {
+		return errMsgs
+	}
+	return nil
+}
+
+// executeBulkOperation - Run one already validated operation
+func executeBulkOperation(exec sqlExecutor, op bulkOperation, userId int64) bulkResult {
+	result := bulkResult{Op: op.Op, ContactId: op.ContactId}
+
+	var ok bool
+	var err error
+	switch op.Op {
+	case "create":
+		result.ContactId, err = insertContact(exec, *op.Data, userId, historyActor{UserId: userId, Source: "bulk"})
+		ok = err == nil
+		result.Status = http.StatusCreated
+	case "update":
+		ok, err = updateContactRow(exec, *op.Data, op.ContactId, userId, op.Version, historyActor{UserId: userId, Source: "bulk"})
+		result.Status = http.StatusOK
+	case "delete":
+		ok, err = softDeleteContactRow(exec, op.ContactId, userId, op.Version, historyActor{UserId: userId, Source: "bulk"})
+		result.Status = http.StatusOK
+	}
+
+	switch {
+	case err != nil:
+		log.Printf("Bulk %s failed: %v", op.Op, err)
+		result.Status = http.StatusInternalServerError
+		result.Errors = []string{"Internal Server Error"}
+	case !ok && op.Version != nil:
+		result.Status = http.StatusPreconditionFailed
+		result.Errors = []string{"Contact not found or version mismatch"}
+	case !ok:
+		result.Status = http.StatusNotFound
+		result.Errors = []string{"Contact not found"}
+	}
+	return result
+}
+
+// executeBulkOperationTx - Run one operation in its own transaction (partial mode)
+func executeBulkOperationTx(db *sql.DB, op bulkOperation, userId int64) bulkResult {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Bulk %s failed: %v", op.Op, err)
+		return bulkResult{Op: op.Op, ContactId: op.ContactId, Status: http.StatusInternalServerError, Errors: []string{"Internal Server Error"}}
+	}
+	defer tx.Rollback()
+
+	result := executeBulkOperation(tx, op, userId)
+	if result.Status < 300 {
+		if err := tx.Commit(); err != nil {
+			log.Printf("Bulk %s failed: %v", op.Op, err)
+			result.Status = http.StatusInternalServerError
+			result.Errors = []string{"Internal Server Error"}
+		}
+	}
+	return result
+}
+
+func BulkContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var req bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	if req.Mode == "" {
+		req.Mode = bulkModeAtomic
+	}
+	maxOps := bulkMaxOperations()
+	errMsgs := []string{}
+	if req.Mode != bulkModeAtomic && req.Mode != bulkModePartial {
+		errMsgs = append(errMsgs, "mode must be atomic or partial")
+	}
+	if len(req.Operations) == 0 {
+		errMsgs = append(errMsgs, "operations is required")
+	}
+	if len(req.Operations) > maxOps {
+		errMsgs = append(errMsgs, fmt.Sprintf("operations must not exceed %d items", maxOps))
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	fields, err := loadCustomFields(db, ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	validate := validator.New()
+
+	results := make([]bulkResult, len(req.Operations))
+	invalid := 0
+	for i, op := range req.Operations {
+		results[i] = bulkResult{Index: i, Op: op.Op, ContactId: op.ContactId}
+		if errs := validateBulkOperation(validate, fields, userPhoneRegion(ctxUser), op); errs != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Errors = errs
+			invalid++
+		}
+	}
+
+	// atomic mode doesn't touch the database unless every item is valid
+	if req.Mode == bulkModeAtomic && invalid > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Validation failed, no changes were applied",
+			"results": results,
+		})
+		return
+	}
+
+	// atomic: one transaction for everything; partial: one per operation
+	var tx *sql.Tx
+	if req.Mode == bulkModeAtomic {
+		var err error
+		tx, err = db.Begin()
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		defer tx.Rollback()
+	}
+
+	succeeded := 0
+	for i, op := range req.Operations {
+		if results[i].Status != 0 {
+			continue
+		}
+
+		var result bulkResult
+		if req.Mode == bulkModeAtomic {
+			result = executeBulkOperation(tx, op, ctxUser.UserId)
+		} else {
+			result = executeBulkOperationTx(db, op, ctxUser.UserId)
+		}
+		result.Index = i
+		results[i] = result
+		if result.Status < 300 {
+			succeeded++
+			continue
+		}
+
+		if req.Mode == bulkModeAtomic {
+			for j := range results {
+				if j != i && results[j].Status < 300 {
+					results[j].Status = http.StatusFailedDependency
+					results[j].Errors = []string{"Rolled back"}
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(result.Status)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": fmt.Sprintf("Operation %d failed, no changes were applied", i),
+				"results": results,
+			})
+			return
+		}
+	}
+
+	if req.Mode == bulkModeAtomic {
+		if err := tx.Commit(); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+	}
+
+	if succeeded > 0 {
+		invalidateContactSuggest(ctxUser.UserId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":   "Bulk operation completed",
+		"mode":      req.Mode,
+		"succeeded": succeeded,
+		"failed":    len(req.Operations) - succeeded,
+		"results":   results,
+	})
+}
@@ -0,0 +1,1044 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+// CardDAV (RFC 6352) on top of the contacts and addresses tables. Every user
+// has one principal at /carddav/ which is also the address book home, and a
+// single address book at /carddav/contacts/ holding one vCard per contact.
+
+const (
+	davNS  = "DAV:"
+	cardNS = "urn:ietf:params:xml:ns:carddav"
+	csNS   = "http://calendarserver.org/ns/"
+
+	carddavPrincipalPath = "/carddav/"
+	carddavBookPath      = "/carddav/contacts/"
+
+	carddavSyncTokenPrefix = "urn:x-contact-sync:"
+	carddavMaxBodyBytes    = 1 << 20
+	carddavAllow           = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT"
+)
+
+var davPrefixes = map[string]string{davNS: "d", cardNS: "card", csNS: "cs"}
+
+// davPropNames - Children of a <d:prop> element, i.e. the requested properties
+type davPropNames []xml.Name
+
+func (p *davPropNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*p = append(*p, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type davPropfind struct {
+	AllProp  *struct{}    `xml:"DAV: allprop"`
+	PropName *struct{}    `xml:"DAV: propname"`
+	Prop     davPropNames `xml:"DAV: prop"`
+}
+
+type davReport struct {
+	XMLName   xml.Name
+	Prop      davPropNames `xml:"DAV: prop"`
+	SyncToken string       `xml:"DAV: sync-token"`
+	Hrefs     []string     `xml:"DAV: href"`
+	Filter    *cardFilter  `xml:"urn:ietf:params:xml:ns:carddav filter"`
+}
+
+type cardFilter struct {
+	Test        string           `xml:"test,attr"`
+	PropFilters []cardPropFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+type cardPropFilter struct {
+	Name         string          `xml:"name,attr"`
+	Test         string          `xml:"test,attr"`
+	IsNotDefined *struct{}       `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []cardTextMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type cardTextMatch struct {
+	MatchType string `xml:"match-type,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	Value     string `xml:",chardata"`
+}
+
+// davResponse - One <d:response> of a multistatus body. Status is set for
+// hrefs that don't exist (multiget misses, sync-collection deletions).
+type davResponse struct {
+	Href    string
+	Status  int
+	Found   []string
+	Missing []xml.Name
+}
+
+type carddavCard struct {
+	Contact   Contacts
+	Addresses []Addresses
+}
+
+func (c carddavCard) name() string {
+	return vcardUID(c.Contact) + ".vcf"
+}
+
+func (c carddavCard) href() string {
+	return carddavBookPath + url.PathEscape(c.name())
+}
+
+// etag - Changes whenever the contact or any of the addresses in its ADR
+// properties change
+func (c carddavCard) etag() string {
+	parts := []string{contactETag(c.Contact)}
+	for _, a := range c.Addresses {
+		parts = append(parts, addressETag(a))
+	}
+	return combinedETag(parts...)
+}
+
+func (c carddavCard) vcard() string {
+	var b strings.Builder
+	writeVCard(&b, c.Contact, c.Addresses, "3.0")
+	return b.String()
+}
+
+func davElement(name xml.Name, inner string) string {
+	tag, open := name.Local, name.Local
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+		open = tag
+	} else if name.Space != "" {
+		tag = "x:" + name.Local
+		open = tag + ` xmlns:x="` + davEscape(name.Space) + `"`
+	}
+	if inner == "" {
+		return "<" + open + "/>"
+	}
+	return "<" + open + ">" + inner + "</" + tag + ">"
+}
+
+func davEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func davHref(href string) string {
+	return "<d:href>" + davEscape(href) + "</d:href>"
+}
+
+func davStatus(status int) string {
+	return fmt.Sprintf("<d:status>HTTP/1.1 %d %s</d:status>", status, http.StatusText(status))
+}
+
+// davProps - Render the requested properties using a resource's property
+// function, splitting them into found and missing
+func davProps(response *davResponse, names []xml.Name, prop func(xml.Name) (string, bool)) {
+	for _, name := range names {
+		if inner, ok := prop(name); ok {
+			response.Found = append(response.Found, davElement(name, inner))
+		} else {
+			response.Missing = append(response.Missing, name)
+		}
+	}
+}
+
+func writeMultistatus(w http.ResponseWriter, responses []davResponse, syncToken string) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="` + cardNS + `" xmlns:cs="` + csNS + `">`)
+	for _, response := range responses {
+		b.WriteString("<d:response>" + davHref(response.Href))
+		if response.Status != 0 {
+			b.WriteString(davStatus(response.Status))
+		}
+		if len(response.Found) > 0 {
+			b.WriteString("<d:propstat><d:prop>" + strings.Join(response.Found, "") + "</d:prop>" + davStatus(http.StatusOK) + "</d:propstat>")
+		}
+		if len(response.Missing) > 0 {
+			b.WriteString("<d:propstat><d:prop>")
+			for _, name := range response.Missing {
+				b.WriteString(davElement(name, ""))
+			}
+			b.WriteString("</d:prop>" + davStatus(http.StatusNotFound) + "</d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	if syncToken != "" {
+		b.WriteString("<d:sync-token>" + davEscape(syncToken) + "</d:sync-token>")
+	}
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+// writeDAVError - Precondition failure body (RFC 4918 section 16)
+func writeDAVError(w http.ResponseWriter, status int, condition xml.Name, description string) {
+	body := `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+		`<d:error xmlns:d="DAV:" xmlns:card="` + cardNS + `">` + davElement(condition, "")
+	if description != "" {
+		body += "<d:responsedescription>" + davEscape(description) + "</d:responsedescription>"
+	}
+	body += "</d:error>"
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// readDAVBody - Decode an XML request body; an empty body leaves v untouched
+func readDAVBody(w http.ResponseWriter, r *http.Request, v any) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, carddavMaxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) == "" {
+		return nil
+	}
+	return xml.Unmarshal(body, v)
+}
+
+func carddavDepth(r *http.Request) string {
+	if r.Header.Get("Depth") == "0" {
+		return "0"
+	}
+	// 1 and infinity are the same here, the tree is only two levels deep
+	return "1"
+}
+
+// CardDAV - Single entry point for /carddav/*path, dispatching on the
+// resource (principal, address book, card) and the method
+func CardDAV(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("DAV", "1, 3, addressbook")
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", carddavAllow)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	path := ps.ByName("path")
+	switch {
+	case path == "/" || path == "":
+		if r.Method != "PROPFIND" {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		carddavPropfindPrincipal(w, r)
+	case path == "/contacts" || path == "/contacts/":
+		switch r.Method {
+		case "PROPFIND":
+			carddavPropfindBook(w, r)
+		case "REPORT":
+			carddavReport(w, r)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(path, "/contacts/") && !strings.Contains(path[len("/contacts/"):], "/"):
+		name := path[len("/contacts/"):]
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			carddavGetCard(w, r, name)
+		case http.MethodPut:
+			carddavPutCard(w, r, name)
+		case http.MethodDelete:
+			carddavDeleteCard(w, r, name)
+		case "PROPFIND":
+			carddavPropfindCard(w, r, name)
+		default:
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.Error(w, "Not Found", http.StatusNotFound)
+	}
+}
+
+// CardDAVWellKnown - /.well-known/carddav (RFC 6764) points clients at the principal
+func CardDAVWellKnown(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	http.Redirect(w, r, carddavPrincipalPath, http.StatusMovedPermanently)
+}
+
+func carddavPrincipalProp(user Users) func(xml.Name) (string, bool) {
+	return func(name xml.Name) (string, bool) {
+		switch name {
+		case xml.Name{Space: davNS, Local: "resourcetype"}:
+			return "<d:collection/><d:principal/>", true
+		case xml.Name{Space: davNS, Local: "displayname"}:
+			return davEscape(user.Name), true
+		case xml.Name{Space: davNS, Local: "current-user-principal"},
+			xml.Name{Space: davNS, Local: "principal-URL"},
+			xml.Name{Space: davNS, Local: "owner"},
+			xml.Name{Space: cardNS, Local: "addressbook-home-set"}:
+			return davHref(carddavPrincipalPath), true
+		case xml.Name{Space: davNS, Local: "current-user-privilege-set"}:
+			return "<d:privilege><d:read/></d:privilege>", true
+		}
+		return "", false
+	}
+}
+
+var (
+	carddavPrincipalAllProps = []xml.Name{
+		{Space: davNS, Local: "resourcetype"},
+		{Space: davNS, Local: "displayname"},
+		{Space: davNS, Local: "current-user-principal"},
+		{Space: cardNS, Local: "addressbook-home-set"},
+	}
+	carddavBookAllProps = []xml.Name{
+		{Space: davNS, Local: "resourcetype"},
+		{Space: davNS, Local: "displayname"},
+		{Space: davNS, Local: "sync-token"},
+		{Space: csNS, Local: "getctag"},
+	}
+	carddavCardAllProps = []xml.Name{
+		{Space: davNS, Local: "resourcetype"},
+		{Space: davNS, Local: "getetag"},
+		{Space: davNS, Local: "getcontenttype"},
+		{Space: davNS, Local: "getlastmodified"},
+	}
+)
+
+// propfindNames - The properties a PROPFIND asks for; allprop and an empty
+// body both mean the resource's default set
+func propfindNames(w http.ResponseWriter, r *http.Request, allProps []xml.Name) ([]xml.Name, bool) {
+	var body davPropfind
+	if err := readDAVBody(w, r, &body); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(body.Prop) == 0 {
+		return allProps, true
+	}
+	return body.Prop, true
+}
+
+func carddavPropfindPrincipal(w http.ResponseWriter, r *http.Request) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	names, ok := propfindNames(w, r, carddavPrincipalAllProps)
+	if !ok {
+		return
+	}
+
+	responses := []davResponse{{Href: carddavPrincipalPath}}
+	davProps(&responses[0], names, carddavPrincipalProp(ctxUser))
+
+	if carddavDepth(r) == "1" {
+		book, err := carddavBookProp(ctxUser)
+		if err != nil {
+			log.Printf("CardDAV PROPFIND failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		response := davResponse{Href: carddavBookPath}
+		davProps(&response, names, book)
+		responses = append(responses, response)
+	}
+	writeMultistatus(w, responses, "")
+}
+
+func carddavBookProp(user Users) (func(xml.Name) (string, bool), error) {
+	token, err := carddavSyncToken(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := carddavPrincipalProp(user)
+	return func(name xml.Name) (string, bool) {
+		switch name {
+		case xml.Name{Space: davNS, Local: "resourcetype"}:
+			return "<d:collection/><card:addressbook/>", true
+		case xml.Name{Space: davNS, Local: "displayname"}:
+			return "Contacts", true
+		case xml.Name{Space: davNS, Local: "sync-token"}:
+			return davEscape(carddavSyncTokenPrefix + strconv.FormatInt(token, 10)), true
+		case xml.Name{Space: csNS, Local: "getctag"}:
+			return strconv.FormatInt(token, 10), true
+		case xml.Name{Space: davNS, Local: "supported-report-set"}:
+			return "<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report>", true
+		case xml.Name{Space: cardNS, Local: "supported-address-data"}:
+			return `<card:address-data-type content-type="text/vcard" version="3.0"/>`, true
+		case xml.Name{Space: cardNS, Local: "max-resource-size"}:
+			return strconv.Itoa(carddavMaxBodyBytes), true
+		case xml.Name{Space: davNS, Local: "current-user-privilege-set"}:
+			return "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+				"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+				"<d:privilege><d:unbind/></d:privilege>", true
+		case xml.Name{Space: davNS, Local: "current-user-principal"},
+			xml.Name{Space: davNS, Local: "owner"}:
+			return principal(name)
+		}
+		return "", false
+	}, nil
+}
+
+// carddavCardProp - Properties of one card. address-data is only rendered
+// when asked for, it is never part of allprop.
+func carddavCardProp(card carddavCard) func(xml.Name) (string, bool) {
+	return func(name xml.Name) (string, bool) {
+		switch name {
+		case xml.Name{Space: davNS, Local: "resourcetype"}:
+			return "", true
+		case xml.Name{Space: davNS, Local: "getetag"}:
+			return davEscape(card.etag()), true
+		case xml.Name{Space: davNS, Local: "getcontenttype"}:
+			return "text/vcard; charset=utf-8", true
+		case xml.Name{Space: davNS, Local: "getlastmodified"}:
+			modified := card.Contact.UpdatedAt
+			if modified == nil {
+				modified = card.Contact.CreatedAt
+			}
+			if modified == nil {
+				return "", false
+			}
+			t, err := time.Parse(time.RFC3339Nano, *modified)
+			if err != nil {
+				return "", false
+			}
+			return t.UTC().Format(http.TimeFormat), true
+		case xml.Name{Space: cardNS, Local: "address-data"}:
+			return davEscape(card.vcard()), true
+		}
+		return "", false
+	}
+}
+
+func carddavPropfindBook(w http.ResponseWriter, r *http.Request) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	names, ok := propfindNames(w, r, carddavBookAllProps)
+	if !ok {
+		return
+	}
+
+	book, err := carddavBookProp(ctxUser)
+	if err != nil {
+		log.Printf("CardDAV PROPFIND failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	responses := []davResponse{{Href: carddavBookPath}}
+	davProps(&responses[0], names, book)
+
+	if carddavDepth(r) == "1" {
+		cards, err := loadCardDAVCards(ctxUser.UserId, "")
+		if err != nil {
+			log.Printf("CardDAV PROPFIND failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		for _, card := range cards {
+			response := davResponse{Href: card.href()}
+			davProps(&response, names, carddavCardProp(card))
+			responses = append(responses, response)
+		}
+	}
+	writeMultistatus(w, responses, "")
+}
+
+func carddavPropfindCard(w http.ResponseWriter, r *http.Request, name string) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	names, ok := propfindNames(w, r, carddavCardAllProps)
+	if !ok {
+		return
+	}
+
+	card, err := findCardDAVCard(ctxUser.UserId, name)
+	if err != nil {
+		carddavLookupError(w, err)
+		return
+	}
+	response := davResponse{Href: card.href()}
+	davProps(&response, names, carddavCardProp(card))
+	writeMultistatus(w, []davResponse{response}, "")
+}
+
+func carddavReport(w http.ResponseWriter, r *http.Request) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	var report davReport
+	if err := readDAVBody(w, r, &report); err != nil || report.XMLName.Local == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	names := []xml.Name(report.Prop)
+	if len(names) == 0 {
+		names = carddavCardAllProps
+	}
+
+	switch report.XMLName {
+	case xml.Name{Space: davNS, Local: "sync-collection"}:
+		carddavSyncCollection(w, ctxUser.UserId, report.SyncToken, names)
+	case xml.Name{Space: cardNS, Local: "addressbook-multiget"}:
+		responses := []davResponse{}
+		for _, href := range report.Hrefs {
+			name, ok := carddavHrefName(href)
+			if !ok {
+				responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			card, err := findCardDAVCard(ctxUser.UserId, name)
+			if errors.Is(err, sql.ErrNoRows) {
+				responses = append(responses, davResponse{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			if err != nil {
+				log.Printf("CardDAV multiget failed: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			response := davResponse{Href: card.href()}
+			davProps(&response, names, carddavCardProp(card))
+			responses = append(responses, response)
+		}
+		writeMultistatus(w, responses, "")
+	case xml.Name{Space: cardNS, Local: "addressbook-query"}:
+		cards, err := loadCardDAVCards(ctxUser.UserId, "")
+		if err != nil {
+			log.Printf("CardDAV query failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		responses := []davResponse{}
+		for _, card := range cards {
+			if report.Filter != nil && !report.Filter.matches(card) {
+				continue
+			}
+			response := davResponse{Href: card.href()}
+			davProps(&response, names, carddavCardProp(card))
+			responses = append(responses, response)
+		}
+		writeMultistatus(w, responses, "")
+	default:
+		writeDAVError(w, http.StatusForbidden, xml.Name{Space: davNS, Local: "supported-report"}, "")
+	}
+}
+
+// carddavSyncToken - The last number of the user's change sequence (see
+// recordSyncChanges). It doubles as the CTag.
+func carddavSyncToken(userId int64) (int64, error) {
+	var token int64
+	err := GetDB().QueryRow("SELECT COALESCE(MAX(seq), 0) FROM sync_sequences WHERE user_id = ?", userId).Scan(&token)
+	return token, err
+}
+
+// carddavSyncCollection - RFC 6578 sync. A token is the number of the last
+// change the client has seen; every card whose contact or addresses changed
+// after it is returned, trashed contacts as 404 tombstones.
+func carddavSyncCollection(w http.ResponseWriter, userId int64, rawToken string, names []xml.Name) {
+	token, err := carddavSyncToken(userId)
+	if err != nil {
+		log.Printf("CardDAV sync failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	newToken := carddavSyncTokenPrefix + strconv.FormatInt(token, 10)
+
+	if rawToken == "" {
+		cards, err := loadCardDAVCards(userId, "")
+		if err != nil {
+			log.Printf("CardDAV sync failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		responses := []davResponse{}
+		for _, card := range cards {
+			response := davResponse{Href: card.href()}
+			davProps(&response, names, carddavCardProp(card))
+			responses = append(responses, response)
+		}
+		writeMultistatus(w, responses, newToken)
+		return
+	}
+
+	since, err := strconv.ParseInt(strings.TrimPrefix(rawToken, carddavSyncTokenPrefix), 10, 64)
+	if err != nil || !strings.HasPrefix(rawToken, carddavSyncTokenPrefix) || since < 0 || since > token {
+		writeDAVError(w, http.StatusForbidden, xml.Name{Space: davNS, Local: "valid-sync-token"}, "")
+		return
+	}
+
+	// a purged contact or address has no row left to name its card by, a
+	// token from before the purge can't be answered and the client has to
+	// start over
+	var purged int
+	err = GetDB().QueryRow(`SELECT COUNT(*) FROM sync_changes s WHERE s.user_id = ? AND s.seq > ? AND (
+		(s.entity = 'contact' AND NOT EXISTS (SELECT 1 FROM contacts c WHERE c.contact_id = s.entity_id))
+		OR (s.entity = 'address' AND NOT EXISTS (SELECT 1 FROM addresses a WHERE a.address_id = s.entity_id)))`, userId, since).Scan(&purged)
+	if err != nil {
+		log.Printf("CardDAV sync failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if purged > 0 {
+		writeDAVError(w, http.StatusForbidden, xml.Name{Space: davNS, Local: "valid-sync-token"}, "")
+		return
+	}
+
+	contactIds, err := queryIds(GetDB(), `SELECT entity_id FROM sync_changes WHERE user_id = ? AND seq > ? AND entity = 'contact'
+		UNION SELECT a.contact_id FROM sync_changes s JOIN addresses a ON a.address_id = s.entity_id WHERE s.user_id = ? AND s.seq > ? AND s.entity = 'address'`, userId, since, userId, since)
+	if err != nil {
+		log.Printf("CardDAV sync failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	responses := []davResponse{}
+	if len(contactIds) == 0 {
+		writeMultistatus(w, responses, newToken)
+		return
+	}
+
+	clause, args := inClause(contactIds)
+	cards, err := loadCardDAVCards(userId, " AND contact_id IN "+clause, args...)
+	if err != nil {
+		log.Printf("CardDAV sync failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, card := range cards {
+		response := davResponse{Href: card.href()}
+		davProps(&response, names, carddavCardProp(card))
+		responses = append(responses, response)
+	}
+
+	rows, err := GetDB().Query("SELECT "+contactColumns+", vcard_uid FROM contacts WHERE user_id = ? AND deleted_at IS NOT NULL AND contact_id IN "+clause, append([]any{userId}, args...)...)
+	if err != nil {
+		log.Printf("CardDAV sync failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var card carddavCard
+		if err := rows.Scan(append(contactScanDest(&card.Contact), &card.Contact.VCardUID)...); err != nil {
+			log.Printf("CardDAV sync failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		responses = append(responses, davResponse{Href: card.href(), Status: http.StatusNotFound})
+	}
+
+	writeMultistatus(w, responses, newToken)
+}
+
+// matches - addressbook-query filter (RFC 6352 section 10.5) over the
+// properties this server produces
+func (f *cardFilter) matches(card carddavCard) bool {
+	if len(f.PropFilters) == 0 {
+		return true
+	}
+	all := f.Test == "allof"
+	for _, pf := range f.PropFilters {
+		ok := pf.matches(card)
+		if all && !ok {
+			return false
+		}
+		if !all && ok {
+			return true
+		}
+	}
+	return all
+}
+
+func (pf cardPropFilter) matches(card carddavCard) bool {
+	values := carddavPropValues(card, strings.ToUpper(pf.Name))
+	if pf.IsNotDefined != nil {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if len(pf.TextMatches) == 0 {
+		return true
+	}
+
+	all := pf.Test == "allof"
+	for _, tm := range pf.TextMatches {
+		ok := false
+		for _, value := range values {
+			if tm.matches(value) {
+				ok = true
+				break
+			}
+		}
+		if all && !ok {
+			return false
+		}
+		if !all && ok {
+			return true
+		}
+	}
+	return all
+}
+
+func (tm cardTextMatch) matches(value string) bool {
+	// i;unicode-casemap is the default collation, compare case-insensitively
+	value, needle := strings.ToLower(value), strings.ToLower(strings.TrimSpace(tm.Value))
+	var ok bool
+	switch tm.MatchType {
+	case "equals":
+		ok = value == needle
+	case "starts-with":
+		ok = strings.HasPrefix(value, needle)
+	case "ends-with":
+		ok = strings.HasSuffix(value, needle)
+	default:
+		ok = strings.Contains(value, needle)
+	}
+	if tm.Negate == "yes" {
+		return !ok
+	}
+	return ok
+}
+
+func carddavPropValues(card carddavCard, name string) []string {
+	c := card.Contact
+	values := []string{}
+	switch name {
+	case "FN":
+		values = append(values, strings.TrimSpace(c.FirstName+" "+c.LastName))
+	case "N":
+		values = append(values, c.LastName+";"+c.FirstName)
+	case "EMAIL":
+		values = append(values, c.Email)
+	case "TEL":
+		values = append(values, c.Phone)
+	case "UID":
+		values = append(values, vcardUID(c))
+	case "ADR":
+		for _, a := range card.Addresses {
+			values = append(values, strings.Join([]string{a.Street, a.City, a.Province, a.PostalCode, a.Country}, ";"))
+		}
+	}
+	result := []string{}
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// carddavHrefName - Resource name of a card href inside the address book
+func carddavHrefName(href string) (string, bool) {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	if !strings.HasPrefix(href, carddavBookPath) {
+		return "", false
+	}
+	name, err := url.PathUnescape(href[len(carddavBookPath):])
+	if err != nil || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// loadCardDAVCards - Live contacts of a user with their addresses. where is
+// appended to the query and may refer to the contacts table as c.
+func loadCardDAVCards(userId int64, where string, args ...any) ([]carddavCard, error) {
+	rows, err := GetDB().Query("SELECT "+contactColumns+", vcard_uid FROM contacts c WHERE user_id = ? AND deleted_at IS NULL"+where+" ORDER BY contact_id", append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []carddavCard{}
+	ids := []string{}
+	for rows.Next() {
+		var card carddavCard
+		if err := rows.Scan(append(contactScanDest(&card.Contact), &card.Contact.VCardUID)...); err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+		ids = append(ids, card.Contact.ContactId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	addresses, err := loadAddressesByContact(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cards {
+		cards[i].Addresses = addresses[cards[i].Contact.ContactId]
+	}
+	return cards, nil
+}
+
+// findCardDAVCard - Resolve a resource name. Cards created over CardDAV are
+// found by vcard_uid, contacts created through the API by contact-<id>.vcf.
+func findCardDAVCard(userId int64, name string) (carddavCard, error) {
+	stem, ok := strings.CutSuffix(name, ".vcf")
+	if !ok || stem == "" {
+		return carddavCard{}, sql.ErrNoRows
+	}
+	contactId := ""
+	if id, found := strings.CutPrefix(stem, "contact-"); found {
+		contactId = id
+	}
+
+	cards, err := loadCardDAVCards(userId, " AND (vcard_uid = ? OR (vcard_uid IS NULL AND contact_id = ?))", stem, contactId)
+	if err != nil {
+		return carddavCard{}, err
+	}
+	if len(cards) == 0 {
+		return carddavCard{}, sql.ErrNoRows
+	}
+	return cards[0], nil
+}
+
+func carddavLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	log.Printf("CardDAV lookup failed: %v", err)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+func carddavGetCard(w http.ResponseWriter, r *http.Request, name string) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	card, err := findCardDAVCard(ctxUser.UserId, name)
+	if err != nil {
+		carddavLookupError(w, err)
+		return
+	}
+	if notModified(w, r, card.etag()) {
+		return
+	}
+
+	body := card.vcard()
+	w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, body)
+}
+
+func carddavPutCard(w http.ResponseWriter, r *http.Request, name string) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	stem, ok := strings.CutSuffix(name, ".vcf")
+	if !ok || stem == "" || len(stem) > 255 {
+		http.Error(w, "Card names must end in .vcf", http.StatusForbidden)
+		return
+	}
+
+	existing, err := findCardDAVCard(ctxUser.UserId, name)
+	found := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		carddavLookupError(w, err)
+		return
+	}
+	if found && r.Header.Get("If-None-Match") == "*" {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+	if (!found && r.Header.Get("If-Match") != "") || (found && ifMatchFails(r, existing.etag())) {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	cards, err := parseVCards(http.MaxBytesReader(w, r.Body, carddavMaxBodyBytes))
+	if err != nil || len(cards) != 1 {
+		writeDAVError(w, http.StatusForbidden, xml.Name{Space: cardNS, Local: "valid-address-data"}, "the body must contain exactly one vCard")
+		return
+	}
+	// only FN/N, EMAIL, TEL and ADR are stored, anything else is dropped
+	contact, addresses, _ := mapVCard(cards[0])
+
+	validate := validator.New()
+	if err := validate.Struct(contact); err != nil {
+		writeDAVError(w, http.StatusForbidden, xml.Name{Space: cardNS, Local: "valid-address-data"}, strings.Join(validationMessages(err), ", "))
+		return
+	}
+	// a card written back unchanged keeps the number the user once typed
+	var current *Contacts
+	if found {
+		current = &existing.Contact
+	}
+	if err := normalizeContactPhone(&contact, userPhoneRegion(ctxUser), current); err != nil {
+		writeDAVError(w, http.StatusForbidden, xml.Name{Space: cardNS, Local: "valid-address-data"}, err.Error())
+		return
+	}
+
+	db := GetDB()
+
+	// a card can't carry custom fields: an update keeps the stored values,
+	// a new card fails while the user has required ones
+	if !found {
+		fields, err := loadCustomFields(db, ctxUser.UserId)
+		if err != nil {
+			log.Printf("CardDAV PUT failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if errMsgs := validateCustomFieldValues(fields, contact.CustomFields); len(errMsgs) > 0 {
+			writeDAVError(w, http.StatusForbidden, xml.Name{Space: cardNS, Local: "valid-address-data"}, strings.Join(errMsgs, ", "))
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("CardDAV PUT failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	actor := historyActor{UserId: ctxUser.UserId, Source: "carddav"}
+	status := http.StatusNoContent
+	contactId := existing.Contact.ContactId
+	if found {
+		ok, err = updateContactRow(tx, contact, contactId, ctxUser.UserId, &existing.Contact.Version, actor)
+		if err == nil && !ok {
+			http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+			return
+		}
+		if err == nil {
+			err = replaceCardDAVAddresses(tx, actor, contactId, existing.Addresses, addresses)
+		}
+	} else {
+		status = http.StatusCreated
+		// a trashed contact may still hold this name; it keeps its data but
+		// is served as contact-<id>.vcf from now on
+		_, err = tx.Exec("UPDATE contacts SET vcard_uid = NULL WHERE user_id = ? AND vcard_uid = ? AND deleted_at IS NOT NULL", ctxUser.UserId, stem)
+		if err == nil {
+			contactId, err = insertContact(tx, contact, ctxUser.UserId, actor)
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE contacts SET vcard_uid = ? WHERE contact_id = ?", stem, contactId)
+		}
+		if err == nil {
+			err = replaceCardDAVAddresses(tx, actor, contactId, nil, addresses)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("CardDAV PUT failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	if card, err := findCardDAVCard(ctxUser.UserId, name); err == nil {
+		w.Header().Set("ETag", card.etag())
+	}
+	w.WriteHeader(status)
+}
+
+// replaceCardDAVAddresses - Make the live addresses of a contact match the
+// card's ADR properties. Unchanged addresses keep their id and version, the
+// others are moved to the trash and new ones inserted.
+func replaceCardDAVAddresses(exec sqlExecutor, actor historyActor, contactId string, current []Addresses, wanted []Addresses) error {
+	same := func(a, b Addresses) bool {
+		return a.Street == b.Street && a.City == b.City && a.Province == b.Province && a.PostalCode == b.PostalCode && a.Country == b.Country
+	}
+
+	used := make([]bool, len(wanted))
+	deletedAt := softDeleteTime()
+	for _, address := range current {
+		kept := false
+		for i, w := range wanted {
+			if !used[i] && same(address, w) {
+				used[i], kept = true, true
+				break
+			}
+		}
+		if kept {
+			continue
+		}
+		if _, err := exec.Exec("UPDATE addresses SET deleted_at = ?, version = version + 1 WHERE address_id = ? AND deleted_at IS NULL", deletedAt, address.AddressId); err != nil {
+			return err
+		}
+		if err := recordAddressHistory(exec, actor, "deleted", "address_id = ?", address.AddressId); err != nil {
+			return err
+		}
+	}
+	for i, address := range wanted {
+		if used[i] {
+			continue
+		}
+		address.ContactId = contactId
+		if err := insertAddressRow(exec, address, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func carddavDeleteCard(w http.ResponseWriter, r *http.Request, name string) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	card, err := findCardDAVCard(ctxUser.UserId, name)
+	if err != nil {
+		carddavLookupError(w, err)
+		return
+	}
+	if ifMatchFails(r, card.etag()) {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	db := GetDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("CardDAV DELETE failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	ok, err := softDeleteContactRow(tx, card.Contact.ContactId, ctxUser.UserId, &card.Contact.Version, historyActor{UserId: ctxUser.UserId, Source: "carddav"})
+	if err == nil && ok {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("CardDAV DELETE failed: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/carddav"
+	"github.com/julienschmidt/httprouter"
+)
+
+type davHeaderKey struct{}
+
+// davTestClient - Adds the headers stored in the request context (the
+// client library can't send If-Match) and remembers the last status code
+type davTestClient struct {
+	status int
+}
+
+func (c *davTestClient) Do(r *http.Request) (*http.Response, error) {
+	if header, ok := r.Context().Value(davHeaderKey{}).(http.Header); ok {
+		for name, values := range header {
+			r.Header[name] = values
+		}
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err == nil {
+		c.status = resp.StatusCode
+	}
+	return resp, err
+}
+
+func withIfMatch(etag string) context.Context {
+	return context.WithValue(context.Background(), davHeaderKey{}, http.Header{"If-Match": {strconv.Quote(etag)}})
+}
+
+func newCardDAVTest(t *testing.T) (*carddav.Client, *davTestClient, Users) {
+	t.Helper()
+
+	openTestDB(t)
+	user := createTestUser(t, "dav@example.com")
+
+	router := httprouter.New()
+	for _, method := range []string{"OPTIONS", "PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE"} {
+		router.Handle(method, "/carddav/*path", BasicAuthMiddleware(CardDAV))
+	}
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	httpClient := &davTestClient{}
+	client, err := carddav.NewClient(webdav.HTTPClientWithBasicAuth(httpClient, user.Email, user.Email), server.URL+carddavPrincipalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, httpClient, user
+}
+
+func testVCard(uid, given, family, email string) vcard.Card {
+	card := vcard.Card{}
+	card.SetValue(vcard.FieldVersion, "3.0")
+	card.SetValue(vcard.FieldUID, uid)
+	card.SetValue(vcard.FieldFormattedName, given+" "+family)
+	card.SetName(&vcard.Name{GivenName: given, FamilyName: family})
+	card.SetValue(vcard.FieldEmail, email)
+	card.SetValue(vcard.FieldTelephone, "0812-3456-7890")
+	return card
+}
+
+func TestCardDAVDiscovery(t *testing.T) {
+	client, _, _ := newCardDAVTest(t)
+	ctx := context.Background()
+
+	if err := client.HasSupport(ctx); err != nil {
+		t.Fatal(err)
+	}
+	principal, err := client.FindCurrentUserPrincipal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal != carddavPrincipalPath {
+		t.Errorf("got principal %q, want %q", principal, carddavPrincipalPath)
+	}
+	home, err := client.FindAddressBookHomeSet(ctx, principal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, err := client.FindAddressBooks(ctx, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 || books[0].Path != carddavBookPath {
+		t.Fatalf("got address books %+v, want one at %s", books, carddavBookPath)
+	}
+	if books[0].MaxResourceSize != carddavMaxBodyBytes {
+		t.Errorf("got max resource size %d, want %d", books[0].MaxResourceSize, carddavMaxBodyBytes)
+	}
+}
+
+func TestCardDAVMultiGet(t *testing.T) {
+	client, _, user := newCardDAVTest(t)
+	ctx := context.Background()
+
+	result, err := GetDB().Exec("INSERT INTO contacts (first_name, last_name, email, phone, user_id) VALUES ('Siti', 'Aminah', 'siti@example.com', '+6281234567891', ?)", user.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contactId, _ := result.LastInsertId()
+	if _, err := GetDB().Exec("INSERT INTO addresses (street, city, province, country, postal_code, contact_id) VALUES ('Jl. Merdeka 1', 'Bandung', 'Jawa Barat', 'Indonesia', '40111', ?)", contactId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PutAddressObject(ctx, carddavBookPath+"budi.vcf", testVCard("budi", "Budi", "Santoso", "budi@example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	seeded := fmt.Sprintf("%scontact-%d.vcf", carddavBookPath, contactId)
+	objects, err := client.MultiGetAddressBook(ctx, carddavBookPath, &carddav.AddressBookMultiGet{
+		Paths:       []string{seeded, carddavBookPath + "budi.vcf"},
+		DataRequest: carddav.AddressDataRequest{AllProp: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d address objects, want 2", len(objects))
+	}
+
+	seededCard, budi := objects[0], objects[1]
+	if seededCard.Path != seeded || budi.Path != carddavBookPath+"budi.vcf" {
+		t.Errorf("got paths %q and %q", seededCard.Path, budi.Path)
+	}
+	if fn := seededCard.Card.PreferredValue(vcard.FieldFormattedName); fn != "Siti Aminah" {
+		t.Errorf("got FN %q, want Siti Aminah", fn)
+	}
+	if adr := seededCard.Card.Address(); adr == nil || adr.Locality != "Bandung" {
+		t.Errorf("got ADR %+v, want the Bandung address", adr)
+	}
+	if email := budi.Card.PreferredValue(vcard.FieldEmail); email != "budi@example.com" {
+		t.Errorf("got EMAIL %q, want budi@example.com", email)
+	}
+	if seededCard.ETag == "" || budi.ETag == "" {
+		t.Error("multiget returned no ETags")
+	}
+}
+
+func TestCardDAVSyncCollection(t *testing.T) {
+	client, httpClient, _ := newCardDAVTest(t)
+	ctx := context.Background()
+
+	for _, uid := range []string{"ani", "dewi"} {
+		if _, err := client.PutAddressObject(ctx, carddavBookPath+uid+".vcf", testVCard(uid, uid, "Test", uid+"@example.com")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	initial, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initial.SyncToken == "" {
+		t.Fatal("initial sync returned no token")
+	}
+	if len(initial.Updated) != 2 || len(initial.Deleted) != 0 {
+		t.Fatalf("initial sync: got %d updated and %d deleted, want 2 and 0", len(initial.Updated), len(initial.Deleted))
+	}
+
+	if _, err := client.PutAddressObject(ctx, carddavBookPath+"ani.vcf", testVCard("ani", "Ani", "Wijaya", "ani@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveAll(ctx, carddavBookPath+"dewi.vcf"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{SyncToken: initial.SyncToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := []string{}
+	for _, object := range changes.Updated {
+		updated = append(updated, object.Path)
+	}
+	if !slices.Contains(updated, carddavBookPath+"ani.vcf") {
+		t.Errorf("got updated %v, want it to contain ani.vcf", updated)
+	}
+	if !slices.Equal(changes.Deleted, []string{carddavBookPath + "dewi.vcf"}) {
+		t.Errorf("got deleted %v, want dewi.vcf", changes.Deleted)
+	}
+	if changes.SyncToken == "" {
+		t.Error("incremental sync returned no token")
+	}
+
+	// the new token is accepted again
+	if _, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{SyncToken: changes.SyncToken}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{SyncToken: "urn:x-other:1"}); err == nil || httpClient.status != http.StatusForbidden {
+		t.Errorf("foreign token: got %d, %v, want 403", httpClient.status, err)
+	}
+}
+
+func TestCardDAVConditionalWrites(t *testing.T) {
+	client, httpClient, _ := newCardDAVTest(t)
+	ctx := context.Background()
+	path := carddavBookPath + "rina.vcf"
+
+	created, err := client.PutAddressObject(ctx, path, testVCard("rina", "Rina", "Putri", "rina@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.status != http.StatusCreated || created.ETag == "" {
+		t.Fatalf("create: got %d with ETag %q", httpClient.status, created.ETag)
+	}
+
+	fetched, err := client.GetAddressObject(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.ETag != created.ETag {
+		t.Errorf("GET ETag %q differs from PUT ETag %q", fetched.ETag, created.ETag)
+	}
+	if fn := fetched.Card.PreferredValue(vcard.FieldFormattedName); fn != "Rina Putri" {
+		t.Errorf("got FN %q, want Rina Putri", fn)
+	}
+	if tel := fetched.Card.PreferredValue(vcard.FieldTelephone); tel != "+6281234567890" {
+		t.Errorf("got TEL %q, want the E.164 form +6281234567890", tel)
+	}
+	var raw string
+	if err := GetDB().QueryRow("SELECT phone_raw FROM contacts WHERE vcard_uid = 'rina'").Scan(&raw); err != nil || raw != "0812-3456-7890" {
+		t.Errorf("got phone_raw %q, %v, want 0812-3456-7890", raw, err)
+	}
+
+	edited := testVCard("rina", "Rina", "Kusuma", "rina@example.com")
+	if _, err := client.PutAddressObject(withIfMatch("stale"), path, edited); err == nil || httpClient.status != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with stale If-Match: got %d, %v, want 412", httpClient.status, err)
+	}
+	updated, err := client.PutAddressObject(withIfMatch(created.ETag), path, edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.status != http.StatusNoContent || updated.ETag == created.ETag {
+		t.Fatalf("PUT with If-Match: got %d with ETag %q", httpClient.status, updated.ETag)
+	}
+
+	if err := client.RemoveAll(withIfMatch(created.ETag), path); err == nil || httpClient.status != http.StatusPreconditionFailed {
+		t.Fatalf("DELETE with stale If-Match: got %d, %v, want 412", httpClient.status, err)
+	}
+	if err := client.RemoveAll(withIfMatch(updated.ETag), path); err != nil {
+		t.Fatal(err)
+	}
+	if httpClient.status != http.StatusNoContent {
+		t.Errorf("DELETE with If-Match: got %d, want 204", httpClient.status)
+	}
+
+	if _, err := client.GetAddressObject(ctx, path); err == nil || httpClient.status != http.StatusNotFound {
+		t.Errorf("GET after DELETE: got %d, %v, want 404", httpClient.status, err)
+	}
+}
+
+// Deletions through the JSON API show up once, whatever the MySQL time zone
+// and however many writes share a second
+func TestCardDAVSyncTombstones(t *testing.T) {
+	client, _, user := newCardDAVTest(t)
+	setTestTimeZone(t, "+07:00")
+	ctx := context.Background()
+
+	for _, uid := range []string{"eka", "fajar"} {
+		if _, err := client.PutAddressObject(ctx, carddavBookPath+uid+".vcf", testVCard(uid, uid, "Test", uid+"@example.com")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initial, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var contactId string
+	if err := GetDB().QueryRow("SELECT contact_id FROM contacts WHERE vcard_uid = 'fajar'").Scan(&contactId); err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	router.DELETE("/contact/:id", AuthMiddleware(DeleteContact))
+	if code, body := serveJSON(t, router, "DELETE", "/contact/"+contactId, user, ""); code != http.StatusOK {
+		t.Fatalf("DELETE /contact/%s: got %d %v", contactId, code, body)
+	}
+
+	changes, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{SyncToken: initial.SyncToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Updated) != 0 || !slices.Equal(changes.Deleted, []string{carddavBookPath + "fajar.vcf"}) {
+		t.Errorf("got %d updated and deleted %v, want only fajar.vcf deleted", len(changes.Updated), changes.Deleted)
+	}
+	if changes.SyncToken == initial.SyncToken {
+		t.Error("the delete didn't change the sync token")
+	}
+
+	again, err := client.SyncCollection(ctx, carddavBookPath, &carddav.SyncQuery{SyncToken: changes.SyncToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Updated) != 0 || len(again.Deleted) != 0 {
+		t.Errorf("sync without changes: got %d updated and deleted %v", len(again.Updated), again.Deleted)
+	}
+}
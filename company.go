@@ -0,0 +1,703 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+type Companies struct {
+	CompanyId   string      `json:"company_id"`
+	Name        string      `json:"name" validate:"required,max=150"`
+	Website     string      `json:"website" validate:"omitempty,url,max=255"`
+	Industry    string      `json:"industry" validate:"max=100"`
+	MemberCount *int64      `json:"member_count,omitempty"`
+	Addresses   []Addresses `json:"addresses,omitempty"`
+	CreatedAt   *string     `json:"created_at,omitempty"`
+	UpdatedAt   *string     `json:"updated_at,omitempty"`
+}
+
+type contactCompanyRequest struct {
+	CompanyId string `json:"company_id" validate:"required,numeric"`
+	JobTitle  string `json:"job_title" validate:"max=100"`
+}
+
+const companyColumns = "co.company_id, co.name, co.website, co.industry, co.created_at, co.updated_at"
+
+// companyMemberCount counts live contacts only; trashed contacts keep their
+// link and come back with it when restored
+const companyMemberCount = "(SELECT COUNT(*) FROM contacts c WHERE c.company_id = co.company_id AND c.user_id = co.user_id AND c.deleted_at IS NULL)"
+
+func scanCompany(row rowScanner, company *Companies, extra ...any) error {
+	return row.Scan(append([]any{&company.CompanyId, &company.Name, &company.Website, &company.Industry, &company.CreatedAt, &company.UpdatedAt}, extra...)...)
+}
+
+func findCompany(companyId string, userId int64) (Companies, error) {
+	var company Companies
+	err := scanCompany(GetDB().QueryRow("SELECT "+companyColumns+", "+companyMemberCount+" FROM companies co WHERE co.company_id = ? AND co.user_id = ?", companyId, userId), &company, &company.MemberCount)
+	return company, err
+}
+
+// loadCompanyAddresses - A company's addresses, oldest first
+func loadCompanyAddresses(companyId string) ([]Addresses, error) {
+	rows, err := GetDB().Query("SELECT "+addressColumns+" FROM addresses WHERE company_id = ? ORDER BY address_id", companyId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []Addresses{}
+	for rows.Next() {
+		var address Addresses
+		if err := scanAddress(rows, &address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+// loadCompaniesByContact - The company of every linked contact in one
+// query, keyed by contact_id, for include=company
+func loadCompaniesByContact(userId int64, contactIds []string) (map[string]Companies, error) {
+	result := map[string]Companies{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	clause, args := inClause(contactIds)
+	rows, err := GetDB().Query("SELECT "+companyColumns+", c.contact_id FROM contacts c JOIN companies co ON co.company_id = c.company_id AND co.user_id = c.user_id WHERE c.user_id = ? AND c.contact_id IN "+clause, append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var company Companies
+		var contactId string
+		if err := scanCompany(rows, &company, &contactId); err != nil {
+			return nil, err
+		}
+		result[contactId] = company
+	}
+	return result, rows.Err()
+}
+
+// readCompanyRequest - Decode and validate a company body, writing the 400
+// response itself
+func readCompanyRequest(w http.ResponseWriter, r *http.Request) (Companies, bool) {
+	var company Companies
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&company) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return company, false
+	}
+	company.Name = strings.TrimSpace(company.Name)
+	company.Website = strings.TrimSpace(company.Website)
+	company.Industry = strings.TrimSpace(company.Industry)
+
+	validate := validator.New()
+	if err := validate.Struct(company); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return company, false
+	}
+	return company, true
+}
+
+func CreateCompany(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	company, ok := readCompanyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	result, err := db.Exec("INSERT INTO companies (user_id, name, website, industry) VALUES (?, ?, ?, ?)", ctxUser.UserId, company.Name, company.Website, company.Industry)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company already exists",
+		})
+		return
+	}
+	var id int64
+	if err == nil {
+		id, err = result.LastInsertId()
+	}
+	if err == nil {
+		company, err = findCompany(strconv.FormatInt(id, 10), ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Company created successfully",
+		"data":    company,
+	})
+}
+
+// GetCompanies - All companies of the user by name, with their live member
+// counts
+func GetCompanies(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rows, err := db.Query("SELECT "+companyColumns+", "+companyMemberCount+" FROM companies co WHERE co.user_id = ? ORDER BY co.name", ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	companies := []Companies{}
+	for rows.Next() {
+		var company Companies
+		if err := scanCompany(rows, &company, &company.MemberCount); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		companies = append(companies, company)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    companies,
+	})
+}
+
+func GetCompanyId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	company, err := findCompany(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company not found",
+		})
+		return
+	}
+
+	company.Addresses, err = loadCompanyAddresses(company.CompanyId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    company,
+	})
+}
+
+func UpdateCompany(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	company, ok := readCompanyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := findCompany(ps.ByName("id"), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company not found",
+		})
+		return
+	}
+
+	_, err := db.Exec("UPDATE companies SET name = ?, website = ?, industry = ? WHERE company_id = ? AND user_id = ?", company.Name, company.Website, company.Industry, ps.ByName("id"), ctxUser.UserId)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company already exists",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	company, _ = findCompany(ps.ByName("id"), ctxUser.UserId)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Company updated successfully",
+		"data":    company,
+	})
+}
+
+// DeleteCompany - Remove a company and its addresses. Its contacts, trashed
+// ones included, stay and lose the link and their job title.
+func DeleteCompany(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	// deleting first waits for SetContactCompany transactions holding the
+	// row, so the unlink below sees every contact they linked
+	result, err := tx.Exec("DELETE FROM companies WHERE company_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId)
+	var deleted int64
+	if err == nil {
+		deleted, _ = result.RowsAffected()
+		if deleted > 0 {
+			var contactIds []string
+			contactIds, err = queryIds(tx, "SELECT contact_id FROM contacts WHERE company_id = ? AND user_id = ? ORDER BY contact_id", ps.ByName("id"), ctxUser.UserId)
+			if err == nil {
+				err = execIn(tx, "UPDATE contacts SET company_id = NULL, job_title = '', version = version + 1 WHERE contact_id IN", contactIds)
+			}
+			if err == nil {
+				err = recordSyncChanges(tx, ctxUser.UserId, "contact", contactIds)
+			}
+		}
+		if err == nil && deleted > 0 {
+			_, err = tx.Exec("DELETE FROM addresses WHERE company_id = ?", ps.ByName("id"))
+		}
+	}
+	if err == nil && deleted > 0 {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if deleted == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Company deleted successfully",
+	})
+}
+
+func GetCompanyAddresses(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	company, err := findCompany(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company not found",
+		})
+		return
+	}
+
+	addresses, err := loadCompanyAddresses(company.CompanyId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	parts := []string{"company" + company.CompanyId}
+	for _, address := range addresses {
+		parts = append(parts, addressETag(address))
+	}
+	if notModified(w, r, combinedETag(parts...)) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    addresses,
+	})
+}
+
+// readCompanyAddressRequest - Decode and validate an address body; the
+// contact_id an address of a contact needs does not apply here
+func readCompanyAddressRequest(w http.ResponseWriter, r *http.Request) (Addresses, bool) {
+	var address Addresses
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&address) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return address, false
+	}
+
+	validate := validator.New()
+	if err := validate.StructExcept(address, "ContactId"); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return address, false
+	}
+	return address, true
+}
+
+func CreateCompanyAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	address, ok := readCompanyAddressRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	company, err := findCompany(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company not found",
+		})
+		return
+	}
+
+	result, err := db.Exec("INSERT INTO addresses (street, city, province, country, postal_code, company_id) VALUES (?, ?, ?, ?, ?, ?)", address.Street, address.City, address.Province, address.Country, address.PostalCode, company.CompanyId)
+	var id int64
+	if err == nil {
+		id, err = result.LastInsertId()
+	}
+	if err == nil {
+		err = scanAddress(db.QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ?", id), &address)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("ETag", addressETag(address))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Address created successfully",
+		"data":    address,
+	})
+}
+
+// loadCompanyAddressForWrite - Fetch the company's address about to be
+// modified and enforce If-Match. Writes the 404 / 412 response itself and
+// returns false on failure.
+func loadCompanyAddressForWrite(w http.ResponseWriter, r *http.Request, companyId string, addressId string, userId int64) (Addresses, bool) {
+	var address Addresses
+	err := scanAddress(GetDB().QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ? AND company_id = (SELECT company_id FROM companies WHERE company_id = ? AND user_id = ?)", addressId, companyId, userId), &address)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Address not found",
+		})
+		return address, false
+	}
+
+	if ifMatchFails(r, addressETag(address)) {
+		writePreconditionFailed(w)
+		return address, false
+	}
+	return address, true
+}
+
+func UpdateCompanyAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	address, ok := readCompanyAddressRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadCompanyAddressForWrite(w, r, ps.ByName("id"), ps.ByName("addressId"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	query, args := versionedUpdate("UPDATE addresses SET street = ?, city = ?, province = ?, country = ?, postal_code = ?, version = version + 1 WHERE address_id = ? AND company_id = ?", r, current.Version,
+		address.Street, address.City, address.Province, address.Country, address.PostalCode, current.AddressId, *current.CompanyId)
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeStaleOrMissing(w, r, "Address not found")
+		return
+	}
+
+	if err := scanAddress(db.QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ?", current.AddressId), &current); err == nil {
+		w.Header().Set("ETag", addressETag(current))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Address updated successfully",
+		"data":    current,
+	})
+}
+
+// DeleteCompanyAddress - Companies have no trash, so the address is removed
+// right away
+func DeleteCompanyAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadCompanyAddressForWrite(w, r, ps.ByName("id"), ps.ByName("addressId"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	query, args := versionedUpdate("DELETE FROM addresses WHERE address_id = ? AND company_id = ?", r, current.Version, current.AddressId, *current.CompanyId)
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		writeStaleOrMissing(w, r, "Address not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Address deleted successfully",
+	})
+}
+
+// SetContactCompany - Link a contact to one of the user's companies with a
+// job title, replacing any previous link
+func SetContactCompany(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req contactCompanyRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	req.JobTitle = strings.TrimSpace(req.JobTitle)
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	// the shared lock keeps the company from being deleted until the link
+	// is committed
+	var companyId string
+	err = tx.QueryRow("SELECT company_id FROM companies WHERE company_id = ? AND user_id = ? FOR SHARE", req.CompanyId, ctxUser.UserId).Scan(&companyId)
+	if err == sql.ErrNoRows {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Company not found",
+		})
+		return
+	}
+
+	var updated int64
+	if err == nil {
+		query, args := versionedUpdate("UPDATE contacts SET company_id = ?, job_title = ?, version = version + 1 WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", r, current.Version, companyId, req.JobTitle, current.ContactId, ctxUser.UserId)
+		var result sql.Result
+		result, err = tx.Exec(query, args...)
+		if err == nil {
+			updated, _ = result.RowsAffected()
+		}
+	}
+	if err == nil && updated > 0 {
+		err = recordSyncChanges(tx, ctxUser.UserId, "contact", []string{current.ContactId})
+	}
+	if err == nil && updated > 0 {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if updated == 0 {
+		writeStaleOrMissing(w, r, "Contact not found")
+		return
+	}
+
+	if err := scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", current.ContactId), &current); err == nil {
+		w.Header().Set("ETag", contactETag(current))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Contact company updated successfully",
+		"data":    current,
+	})
+}
+
+// DeleteContactCompany - Unlink a contact from its company; a contact
+// without one is left as is
+func DeleteContactCompany(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	if current.CompanyId != nil {
+		tx, err := db.Begin()
+		var updated int64
+		if err == nil {
+			defer tx.Rollback()
+			query, args := versionedUpdate("UPDATE contacts SET company_id = NULL, job_title = '', version = version + 1 WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", r, current.Version, current.ContactId, ctxUser.UserId)
+			var result sql.Result
+			result, err = tx.Exec(query, args...)
+			if err == nil {
+				updated, _ = result.RowsAffected()
+			}
+		}
+		if err == nil && updated > 0 {
+			err = recordSyncChanges(tx, ctxUser.UserId, "contact", []string{current.ContactId})
+		}
+		if err == nil && updated > 0 {
+			err = tx.Commit()
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		if updated == 0 {
+			writeStaleOrMissing(w, r, "Contact not found")
+			return
+		}
+		_ = scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", current.ContactId), &current)
+	}
+
+	w.Header().Set("ETag", contactETag(current))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Contact company removed successfully",
+		"data":    current,
+	})
+}
@@ -10,14 +10,26 @@ import (
 )
 
 type Contacts struct {
-	ContactId string  `json:"contact_id"`
-	FirstName string  `json:"first_name" validate:"required"`
-	LastName  string  `json:"last_name" validate:"required"`
-	Email     string  `json:"email" validate:"required,email"`
-	Phone     string  `json:"phone" validate:"required"`
-	UserId    string  `json:"user_id"`
-	CreatedAt *string `json:"created_at,omitempty"`
-	UpdatedAt *string `json:"updated_at,omitempty"`
+	ContactId          string         `json:"contact_id"`
+	FirstName          string         `json:"first_name" validate:"required"`
+	LastName           string         `json:"last_name" validate:"required"`
+	Email              string         `json:"email" validate:"required,email"`
+	Phone              string         `json:"phone" validate:"required"`
+	PhoneRaw           *string        `json:"phone_raw,omitempty"`
+	PhoneNational      string         `json:"phone_national,omitempty"`
+	PhoneInternational string         `json:"phone_international,omitempty"`
+	UserId             string         `json:"user_id"`
+	Version            int64          `json:"version"`
+	CreatedAt          *string        `json:"created_at,omitempty"`
+	UpdatedAt          *string        `json:"updated_at,omitempty"`
+	DeletedAt          *string        `json:"deleted_at,omitempty"`
+	VCardUID           *string        `json:"-"`
+	CustomFields       map[string]any `json:"custom_fields,omitempty"`
+	PhotoKey           *string        `json:"-"`
+	PhotoType          *string        `json:"-"`
+	Photo              *ContactPhoto  `json:"photo,omitempty"`
+	CompanyId          *string        `json:"company_id"`
+	JobTitle           string         `json:"job_title"`
 }
 
 func CreateContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -61,7 +73,21 @@ func CreateContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	ctxUser := r.Context().Value("user").(Users)
 
-	_, err := db.Exec("INSERT INTO contacts (first_name, last_name, email, phone, user_id) VALUES (?, ?, ?, ?, ?)", contact.FirstName, contact.LastName, contact.Email, contact.Phone, ctxUser.UserId)
+	if errMsgs, err := customFieldErrors(db, ctxUser.UserId, contact.CustomFields); err != nil || len(errMsgs) > 0 {
+		writeCustomFieldErrors(w, errMsgs, err)
+		return
+	}
+
+	if err := normalizeContactPhone(&contact, userPhoneRegion(ctxUser), nil); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{err.Error()},
+		})
+		return
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(500)
@@ -70,14 +96,38 @@ func CreateContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		})
 		return
 	}
+	defer tx.Rollback()
+
+	contactId, err := insertContact(tx, contact, ctxUser.UserId, historyActor{UserId: ctxUser.UserId, Source: "api"})
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
 
 	data := map[string]any{
+		"contact_id": contactId,
 		"first_name": contact.FirstName,
 		"last_name":  contact.LastName,
 		"email":      contact.Email,
 		"phone":      contact.Phone,
 		"user_id":    ctxUser.UserId,
 	}
+	contact.fillPhoneDisplay()
+	data["phone_raw"] = contact.PhoneRaw
+	data["phone_national"] = contact.PhoneNational
+	data["phone_international"] = contact.PhoneInternational
+	if contact.CustomFields != nil {
+		data["custom_fields"] = contact.CustomFields
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(201)
@@ -93,7 +143,20 @@ func GetContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	ctxUser := r.Context().Value("user").(Users)
 
-	rows, err := db.Query("SELECT * FROM contacts WHERE user_id = ?", ctxUser.UserId)
+	listQuery, errMsgs := parseContactListQuery(r, ctxUser.UserId)
+	projection, projectionErrs := parseContactProjection(r)
+	errMsgs = append(errMsgs, projectionErrs...)
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	query, args := listQuery.build()
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(500)
@@ -107,7 +170,7 @@ func GetContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	var contacts []Contacts
 	for rows.Next() {
 		var contact Contacts
-		if err := rows.Scan(&contact.ContactId, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.UserId, &contact.CreatedAt, &contact.UpdatedAt); err != nil {
+		if err := scanContact(rows, &contact); err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(500)
 			json.NewEncoder(w).Encode(map[string]any{
@@ -118,22 +181,66 @@ func GetContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		contacts = append(contacts, contact)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(map[string]any{
+	var nextCursor *string
+	if listQuery.Limit > 0 && len(contacts) > listQuery.Limit {
+		contacts = contacts[:listQuery.Limit]
+		cursor := listQuery.nextCursor(contacts[len(contacts)-1])
+		nextCursor = &cursor
+	}
+
+	if err := attachCustomFields(contacts); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	response := map[string]any{
 		"message": "Success",
 		"data":    contacts,
-	})
+	}
+	if listQuery.Limit > 0 {
+		response["next_cursor"] = nextCursor
+	}
+
+	if !projection.empty() {
+		data, err := projection.render(ctxUser.UserId, contacts)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		response["data"] = data
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response)
 }
 
 func GetContactId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projection, errMsgs := parseContactProjection(r)
+	if errMsgs != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
 	db := GetDB()
 
 	ctxUser := r.Context().Value("user").(Users)
 
 	var contact Contacts
 
-	err := db.QueryRow("SELECT * FROM contacts WHERE contact_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId).Scan(&contact.ContactId, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.UserId, &contact.CreatedAt, &contact.UpdatedAt)
+	err := scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId), &contact)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(404)
@@ -143,11 +250,59 @@ func GetContactId(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
+	withFields := []Contacts{contact}
+	if err := attachCustomFields(withFields); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	contact = withFields[0]
+
+	etag := contactETag(contact)
+
+	var data any = contact
+	if !projection.empty() {
+		rendered, err := projection.render(ctxUser.UserId, []Contacts{contact})
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		data = rendered[0]
+		parts := []string{etag}
+		// addresses carry their own version; notes, events, relationships
+		// and the rest don't bump the contact's, so their content is part
+		// of the ETag and any change to them shows
+		for _, name := range projection.Include {
+			if addresses, ok := rendered[0][name].([]Addresses); ok {
+				for _, address := range addresses {
+					parts = append(parts, addressETag(address))
+				}
+				continue
+			}
+			b, _ := json.Marshal(rendered[0][name])
+			parts = append(parts, name+":"+string(b))
+		}
+		if len(parts) > 1 {
+			etag = combinedETag(parts...)
+		}
+	}
+
+	if notModified(w, r, etag) {
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(map[string]any{
 		"message": "Success",
-		"data":    contact,
+		"data":    data,
 	})
 }
 
@@ -192,7 +347,29 @@ func UpdateContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	ctxUser := r.Context().Value("user").(Users)
 
-	rows, err := db.Exec("UPDATE contacts SET first_name = ?, last_name = ?, email = ?, phone = ? WHERE contact_id = ? AND user_id = ?", contact.FirstName, contact.LastName, contact.Email, contact.Phone, ps.ByName("id"), ctxUser.UserId)
+	// a full update is held to the same rules as a create; without
+	// custom_fields (allowed while none is required) the stored values are
+	// left as they are
+	if errMsgs, err := customFieldErrors(db, ctxUser.UserId, contact.CustomFields); err != nil || len(errMsgs) > 0 {
+		writeCustomFieldErrors(w, errMsgs, err)
+		return
+	}
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	if err := normalizeContactPhone(&contact, userPhoneRegion(ctxUser), &current); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{err.Error()},
+		})
+		return
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(500)
@@ -201,27 +378,51 @@ func UpdateContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		})
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, _ := rows.RowsAffected()
-	if rowsAffected == 0 {
+	updated, err := updateContactRow(tx, contact, current.ContactId, ctxUser.UserId, ifMatchVersion(r, current.Version), historyActor{UserId: ctxUser.UserId, Source: "api"})
+	if err == nil && updated {
+		err = tx.Commit()
+	}
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(404)
+		w.WriteHeader(500)
 		json.NewEncoder(w).Encode(map[string]any{
-			"message": "Contact not found",
+			"message": "Internal Server Error",
 		})
 		return
 	}
 
+	if !updated {
+		writeStaleOrMissing(w, r, "Contact not found")
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	if err := scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", current.ContactId), &current); err == nil {
+		w.Header().Set("ETag", contactETag(current))
+	}
+
+	data := map[string]any{
+		"first_name": contact.FirstName,
+		"last_name":  contact.LastName,
+		"email":      contact.Email,
+		"phone":      contact.Phone,
+	}
+	contact.fillPhoneDisplay()
+	data["phone_raw"] = contact.PhoneRaw
+	data["phone_national"] = contact.PhoneNational
+	data["phone_international"] = contact.PhoneInternational
+	if contact.CustomFields != nil {
+		data["custom_fields"] = contact.CustomFields
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(map[string]any{
 		"message": "Contact updated successfully",
-		"data": map[string]any{
-			"first_name": contact.FirstName,
-			"last_name":  contact.LastName,
-			"email":      contact.Email,
-			"phone":      contact.Phone,
-		},
+		"data":    data,
 	})
 }
 
@@ -230,7 +431,12 @@ func DeleteContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 
 	ctxUser := r.Context().Value("user").(Users)
 
-	rows, err := db.Exec("DELETE FROM contacts WHERE contact_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId)
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(500)
@@ -239,20 +445,196 @@ func DeleteContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		})
 		return
 	}
+	defer tx.Rollback()
 
-	rowsAffected, _ := rows.RowsAffected()
-	if rowsAffected == 0 {
+	deleted, err := softDeleteContactRow(tx, current.ContactId, ctxUser.UserId, ifMatchVersion(r, current.Version), historyActor{UserId: ctxUser.UserId, Source: "api"})
+	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(404)
+		w.WriteHeader(500)
 		json.NewEncoder(w).Encode(map[string]any{
-			"message": "Contact not found",
+			"message": "Internal Server Error",
 		})
 		return
 	}
 
+	if !deleted {
+		writeStaleOrMissing(w, r, "Contact not found")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(map[string]any{
 		"message": "Contact deleted successfully",
 	})
 }
+
+func PatchContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	// patch against the full representation so custom_fields paths resolve
+	withFields := []Contacts{current}
+	if err := attachCustomFields(withFields); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	current = withFields[0]
+
+	doc, err := toJSONDocument(current)
+	if err == nil {
+		doc, err = applyRequestPatch(r, doc)
+	}
+	var patched Contacts
+	if err == nil {
+		err = fromJSONDocument(doc, &patched)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(patchErrorStatus(err))
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// identifiers and timestamps are not patchable
+	patched.ContactId = current.ContactId
+	patched.UserId = current.UserId
+	patched.Version = current.Version
+	patched.CreatedAt = current.CreatedAt
+	patched.UpdatedAt = current.UpdatedAt
+	// the company link is changed through /contact/:id/company
+	patched.CompanyId = current.CompanyId
+	patched.JobTitle = current.JobTitle
+
+	validate := validator.New()
+
+	if err := validate.Struct(patched); err != nil {
+		errors := err.(validator.ValidationErrors)
+		errMsgs := []string{}
+		for _, e := range errors {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s is %s", e.Field(), e.ActualTag()))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	if err := normalizeContactPhone(&patched, userPhoneRegion(ctxUser), &current); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{err.Error()},
+		})
+		return
+	}
+
+	if patched.CustomFields == nil {
+		patched.CustomFields = map[string]any{}
+	}
+	if errMsgs, err := customFieldErrors(db, ctxUser.UserId, patched.CustomFields); err != nil || len(errMsgs) > 0 {
+		writeCustomFieldErrors(w, errMsgs, err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	updated, err := updateContactRow(tx, patched, current.ContactId, ctxUser.UserId, ifMatchVersion(r, current.Version), historyActor{UserId: ctxUser.UserId, Source: "api"})
+	if err == nil && updated {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if !updated {
+		writeStaleOrMissing(w, r, "Contact not found")
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	if err := scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", current.ContactId), &patched); err == nil {
+		w.Header().Set("ETag", contactETag(patched))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Contact updated successfully",
+		"data":    patched,
+	})
+}
+
+// loadContactForWrite - Fetch the contact about to be modified and enforce
+// If-Match. Writes the 404 / 412 response itself and returns false on failure.
+func loadContactForWrite(w http.ResponseWriter, r *http.Request, contactId string, userId int64) (Contacts, bool) {
+	var contact Contacts
+	err := scanContact(GetDB().QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", contactId, userId), &contact)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return contact, false
+	}
+
+	if ifMatchFails(r, contactETag(contact)) {
+		writePreconditionFailed(w)
+		return contact, false
+	}
+	return contact, true
+}
+
+// versionedUpdate - When the client sent If-Match, also require the row to
+// still be at the version we checked so a concurrent write can't slip in
+// between the check and the UPDATE/DELETE.
+func versionedUpdate(query string, r *http.Request, version int64, args ...any) (string, []any) {
+	if r.Header.Get("If-Match") != "" {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
+	return query, args
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const contactChannelMaxEntries = 20
+
+// ContactEmails - One email address of a contact. The primary one is
+// mirrored into contacts.email.
+type ContactEmails struct {
+	EmailId   string  `json:"email_id"`
+	Label     string  `json:"label"`
+	Email     string  `json:"email"`
+	Primary   bool    `json:"primary"`
+	CreatedAt *string `json:"created_at,omitempty"`
+	UpdatedAt *string `json:"updated_at,omitempty"`
+}
+
+// ContactPhones - One phone number of a contact. The primary one is
+// mirrored into contacts.phone.
+type ContactPhones struct {
+	PhoneId       string  `json:"phone_id"`
+	Label         string  `json:"label"`
+	Phone         string  `json:"phone"`
+	National      string  `json:"national,omitempty"`
+	International string  `json:"international,omitempty"`
+	Primary       bool    `json:"primary"`
+	CreatedAt     *string `json:"created_at,omitempty"`
+	UpdatedAt     *string `json:"updated_at,omitempty"`
+}
+
+// contactChannel - Describes one of the labelled child tables (emails,
+// phones) so both share the same storage and handlers
+type contactChannel struct {
+	Noun          string // "email", used in messages and request bodies
+	Table         string
+	IdColumn      string
+	ValueColumn   string
+	ContactColumn string // contacts column holding the primary value
+	Param         string // route parameter of the entry id
+	Rule          string // validator rule for the value
+	RawColumn     string // contacts column for the value as typed, if any
+	normalize     func(value string, region string) (string, error)
+	render        func(entry channelEntry) any
+}
+
+type channelEntry struct {
+	Id        string
+	ContactId string
+	Label     string
+	Value     string
+	Primary   bool
+	CreatedAt *string
+	UpdatedAt *string
+}
+
+type contactChannelRequest struct {
+	Label   string `json:"label" validate:"omitempty,oneof=work home mobile other"`
+	Email   string `json:"email"`
+	Phone   string `json:"phone"`
+	Primary *bool  `json:"primary"`
+}
+
+var emailChannel = contactChannel{
+	Noun:          "email",
+	Table:         "contact_emails",
+	IdColumn:      "email_id",
+	ValueColumn:   "email",
+	ContactColumn: "email",
+	Param:         "emailId",
+	Rule:          "required,email,max=255",
+	render: func(e channelEntry) any {
+		return ContactEmails{EmailId: e.Id, Label: e.Label, Email: e.Value, Primary: e.Primary, CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt}
+	},
+}
+
+var phoneChannel = contactChannel{
+	Noun:          "phone",
+	Table:         "contact_phones",
+	IdColumn:      "phone_id",
+	ValueColumn:   "phone",
+	ContactColumn: "phone",
+	Param:         "phoneId",
+	Rule:          "required,max=50",
+	RawColumn:     "phone_raw",
+	normalize: func(value string, region string) (string, error) {
+		number, err := parsePhone(value, region)
+		return number.E164, err
+	},
+	render: func(e channelEntry) any {
+		phone := ContactPhones{PhoneId: e.Id, Label: e.Label, Phone: e.Value, Primary: e.Primary, CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt}
+		phone.National, phone.International, _ = phoneDisplay(e.Value)
+		return phone
+	},
+}
+
+func (c contactChannel) columns() string {
+	return c.IdColumn + ", contact_id, label, " + c.ValueColumn + ", is_primary, created_at, updated_at"
+}
+
+func (c contactChannel) value(req contactChannelRequest) string {
+	if c.Noun == "phone" {
+		return strings.TrimSpace(req.Phone)
+	}
+	return strings.TrimSpace(req.Email)
+}
+
+func scanChannelEntry(row rowScanner, entry *channelEntry) error {
+	return row.Scan(&entry.Id, &entry.ContactId, &entry.Label, &entry.Value, &entry.Primary, &entry.CreatedAt, &entry.UpdatedAt)
+}
+
+// loadChannelByContact - Entries of many contacts keyed by contact_id,
+// primary first. Callers must have already scoped contactIds to the user.
+func loadChannelByContact(c contactChannel, contactIds []string) (map[string][]any, error) {
+	result := map[string][]any{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(contactIds)-1) + "?"
+	args := make([]any, len(contactIds))
+	for i, id := range contactIds {
+		args[i] = id
+	}
+
+	rows, err := GetDB().Query("SELECT "+c.columns()+" FROM "+c.Table+" WHERE contact_id IN ("+placeholders+") ORDER BY is_primary DESC, "+c.IdColumn, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry channelEntry
+		if err := scanChannelEntry(rows, &entry); err != nil {
+			return nil, err
+		}
+		result[entry.ContactId] = append(result[entry.ContactId], c.render(entry))
+	}
+	return result, rows.Err()
+}
+
+// setPrimaryChannelValue - Keep the primary entry in step with the top-level
+// contact field, creating it when the contact has none yet
+func setPrimaryChannelValue(exec sqlExecutor, c contactChannel, contactId string, value string) error {
+	if _, err := exec.Exec("UPDATE "+c.Table+" SET "+c.ValueColumn+" = ? WHERE contact_id = ? AND is_primary = 1", value, contactId); err != nil {
+		return err
+	}
+	_, err := exec.Exec("INSERT INTO "+c.Table+" (contact_id, label, "+c.ValueColumn+", is_primary) SELECT ?, 'other', ?, 1 FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM "+c.Table+" WHERE contact_id = ? AND is_primary = 1)", contactId, value, contactId)
+	return err
+}
+
+// syncPrimaryChannels - Mirror contact.email / contact.phone into the
+// primary email and phone entries
+func syncPrimaryChannels(exec sqlExecutor, contactId string, contact Contacts) error {
+	if err := setPrimaryChannelValue(exec, emailChannel, contactId, contact.Email); err != nil {
+		return err
+	}
+	return setPrimaryChannelValue(exec, phoneChannel, contactId, contact.Phone)
+}
+
+// lockChannelContact - Lock the live contact row so concurrent primary
+// changes on the same contact are serialized
+func lockChannelContact(tx *sql.Tx, contactId string, userId int64) (bool, error) {
+	var locked string
+	err := tx.QueryRow("SELECT contact_id FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL FOR UPDATE", contactId, userId).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// readChannelRequest - Decode and validate an entry body, writing the 400 itself
+func readChannelRequest(w http.ResponseWriter, r *http.Request, c contactChannel) (contactChannelRequest, bool) {
+	var req contactChannelRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return req, false
+	}
+	if req.Label == "" {
+		req.Label = "other"
+	}
+
+	validate := validator.New()
+	errMsgs := []string{}
+	if err := validate.Struct(req); err != nil {
+		errMsgs = validationMessages(err)
+	}
+	if err := validate.Var(c.value(req), c.Rule); err != nil {
+		for _, e := range err.(validator.ValidationErrors) {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s is %s", c.Noun, e.ActualTag()))
+		}
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return req, false
+	}
+	return req, true
+}
+
+func listContactChannel(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c contactChannel) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	entries, err := loadChannelByContact(c, []string{ps.ByName("id")})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	data := entries[ps.ByName("id")]
+	if data == nil {
+		data = []any{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    data,
+	})
+}
+
+func getContactChannelEntry(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c contactChannel) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var entry channelEntry
+	err := scanChannelEntry(db.QueryRow("SELECT "+c.columns()+" FROM "+c.Table+" WHERE "+c.IdColumn+" = ? AND contact_id IN (SELECT contact_id FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL)", ps.ByName(c.Param), ps.ByName("id"), ctxUser.UserId), &entry)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("Contact %s not found", c.Noun),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    c.render(entry),
+	})
+}
+
+// writeContactChannelEntry - Create (entryId == "") or replace an entry.
+// Making an entry primary demotes the previous one and copies the value to
+// the contact; every change bumps the contact version.
+func writeContactChannelEntry(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c contactChannel, entryId string) {
+	req, ok := readChannelRequest(w, r, c)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	contactId := ps.ByName("id")
+	found, err := lockChannelContact(tx, contactId, ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !found {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	var current channelEntry
+	var count int
+	if entryId == "" {
+		err = tx.QueryRow("SELECT COUNT(*) FROM "+c.Table+" WHERE contact_id = ?", contactId).Scan(&count)
+	} else {
+		err = scanChannelEntry(tx.QueryRow("SELECT "+c.columns()+" FROM "+c.Table+" WHERE "+c.IdColumn+" = ? AND contact_id = ?", entryId, contactId), &current)
+		if err == sql.ErrNoRows {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(404)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": fmt.Sprintf("Contact %s not found", c.Noun),
+			})
+			return
+		}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	errMsgs := []string{}
+	if entryId == "" && count >= contactChannelMaxEntries {
+		errMsgs = append(errMsgs, fmt.Sprintf("a contact can have at most %d %ss", contactChannelMaxEntries, c.Noun))
+	}
+	if current.Primary && req.Primary != nil && !*req.Primary {
+		errMsgs = append(errMsgs, fmt.Sprintf("make another %s primary instead of unsetting this one", c.Noun))
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	// the first entry of a contact is always primary
+	primary := current.Primary || (req.Primary != nil && *req.Primary) || (entryId == "" && count == 0)
+	raw := c.value(req)
+	value := raw
+	if c.normalize != nil {
+		if value, err = c.normalize(raw, userPhoneRegion(ctxUser)); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]any{
+				"errors": []string{err.Error()},
+			})
+			return
+		}
+	}
+
+	if primary && !current.Primary {
+		_, err = tx.Exec("UPDATE "+c.Table+" SET is_primary = 0 WHERE contact_id = ?", contactId)
+	}
+	if err == nil && entryId == "" {
+		var result sql.Result
+		result, err = tx.Exec("INSERT INTO "+c.Table+" (contact_id, label, "+c.ValueColumn+", is_primary) VALUES (?, ?, ?, ?)", contactId, req.Label, value, primary)
+		if err == nil {
+			var id int64
+			id, err = result.LastInsertId()
+			entryId = fmt.Sprint(id)
+		}
+	} else if err == nil {
+		_, err = tx.Exec("UPDATE "+c.Table+" SET label = ?, "+c.ValueColumn+" = ?, is_primary = ? WHERE "+c.IdColumn+" = ?", req.Label, value, primary, entryId)
+	}
+	if err == nil && primary && c.RawColumn != "" {
+		_, err = tx.Exec("UPDATE contacts SET "+c.ContactColumn+" = ?, "+c.RawColumn+" = ?, version = version + 1 WHERE contact_id = ?", value, raw, contactId)
+	} else if err == nil && primary {
+		_, err = tx.Exec("UPDATE contacts SET "+c.ContactColumn+" = ?, version = version + 1 WHERE contact_id = ?", value, contactId)
+	} else if err == nil {
+		_, err = tx.Exec("UPDATE contacts SET version = version + 1 WHERE contact_id = ?", contactId)
+		if err == nil {
+			err = recordSyncChanges(tx, ctxUser.UserId, "contact", []string{contactId})
+		}
+	}
+	if err == nil && primary {
+		err = recordContactHistory(tx, historyActor{UserId: ctxUser.UserId, Source: "api"}, "updated", contactId)
+	}
+	var entry channelEntry
+	if err == nil {
+		err = scanChannelEntry(tx.QueryRow("SELECT "+c.columns()+" FROM "+c.Table+" WHERE "+c.IdColumn+" = ?", entryId), &entry)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	if primary {
+		invalidateContactSuggest(ctxUser.UserId)
+	}
+
+	status, message := 200, fmt.Sprintf("Contact %s updated successfully", c.Noun)
+	if current.Id == "" {
+		status, message = 201, fmt.Sprintf("Contact %s created successfully", c.Noun)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": message,
+		"data":    c.render(entry),
+	})
+}
+
+// deleteContactChannelEntry - Remove a non-primary entry. The primary one
+// backs contacts.email / contacts.phone and has to be replaced first.
+func deleteContactChannelEntry(w http.ResponseWriter, r *http.Request, ps httprouter.Params, c contactChannel) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	contactId := ps.ByName("id")
+	found, err := lockChannelContact(tx, contactId, ctxUser.UserId)
+	var entry channelEntry
+	if err == nil && found {
+		err = scanChannelEntry(tx.QueryRow("SELECT "+c.columns()+" FROM "+c.Table+" WHERE "+c.IdColumn+" = ? AND contact_id = ?", ps.ByName(c.Param), contactId), &entry)
+		found = err != sql.ErrNoRows
+		if !found {
+			err = nil
+		}
+	}
+	if err == nil && found && !entry.Primary {
+		_, err = tx.Exec("DELETE FROM "+c.Table+" WHERE "+c.IdColumn+" = ?", entry.Id)
+		if err == nil {
+			_, err = tx.Exec("UPDATE contacts SET version = version + 1 WHERE contact_id = ?", contactId)
+		}
+		if err == nil {
+			err = recordSyncChanges(tx, ctxUser.UserId, "contact", []string{contactId})
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !found {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("Contact %s not found", c.Noun),
+		})
+		return
+	}
+	if entry.Primary {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("The primary %s can't be deleted; make another %s primary first", c.Noun, c.Noun),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": fmt.Sprintf("Contact %s deleted successfully", c.Noun),
+	})
+}
+
+func GetContactEmails(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	listContactChannel(w, r, ps, emailChannel)
+}
+
+func GetContactEmailId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	getContactChannelEntry(w, r, ps, emailChannel)
+}
+
+func CreateContactEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeContactChannelEntry(w, r, ps, emailChannel, "")
+}
+
+func UpdateContactEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeContactChannelEntry(w, r, ps, emailChannel, ps.ByName(emailChannel.Param))
+}
+
+func DeleteContactEmail(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	deleteContactChannelEntry(w, r, ps, emailChannel)
+}
+
+func GetContactPhones(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	listContactChannel(w, r, ps, phoneChannel)
+}
+
+func GetContactPhoneId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	getContactChannelEntry(w, r, ps, phoneChannel)
+}
+
+func CreateContactPhone(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeContactChannelEntry(w, r, ps, phoneChannel, "")
+}
+
+func UpdateContactPhone(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeContactChannelEntry(w, r, ps, phoneChannel, ps.ByName(phoneChannel.Param))
+}
+
+func DeleteContactPhone(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	deleteContactChannelEntry(w, r, ps, phoneChannel)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// contactIncludes - Related resources that can be embedded with include=,
+// each loaded for a whole page of contacts at once
+var contactIncludes = map[string]func(userId int64, contactIds []string) (map[string]any, error){
+	"addresses": func(userId int64, contactIds []string) (map[string]any, error) {
+		byContact, err := loadAddressesByContact(contactIds)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]any{}
+		for _, id := range contactIds {
+			addresses := byContact[id]
+			if addresses == nil {
+				addresses = []Addresses{}
+			}
+			result[id] = addresses
+		}
+		return result, nil
+	},
+	"tags": func(userId int64, contactIds []string) (map[string]any, error) {
+		byContact, err := loadTagsByContact(contactIds)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]any{}
+		for _, id := range contactIds {
+			tags := byContact[id]
+			if tags == nil {
+				tags = []Tags{}
+			}
+			result[id] = tags
+		}
+		return result, nil
+	},
+	"emails": func(userId int64, contactIds []string) (map[string]any, error) {
+		return channelInclude(emailChannel, contactIds)
+	},
+	"phones": func(userId int64, contactIds []string) (map[string]any, error) {
+		return channelInclude(phoneChannel, contactIds)
+	},
+	"events": func(userId int64, contactIds []string) (map[string]any, error) {
+		byContact, err := loadEventsByContact(contactIds)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]any{}
+		for _, id := range contactIds {
+			events := byContact[id]
+			if events == nil {
+				events = []ContactEvents{}
+			}
+			result[id] = events
+		}
+		return result, nil
+	},
+	"notes": func(userId int64, contactIds []string) (map[string]any, error) {
+		byContact, err := loadNotesByContact(contactIds)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]any{}
+		for _, id := range contactIds {
+			notes := byContact[id]
+			if notes == nil {
+				notes = []ContactNotes{}
+			}
+			result[id] = notes
+		}
+		return result, nil
+	},
+	"relationships": func(userId int64, contactIds []string) (map[string]any, error) {
+		byContact, err := loadRelationshipsByContact(userId, contactIds)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]any{}
+		for _, id := range contactIds {
+			relationships := byContact[id]
+			if relationships == nil {
+				relationships = []ContactRelationships{}
+			}
+			result[id] = relationships
+		}
+		return result, nil
+	},
+	"company": func(userId int64, contactIds []string) (map[string]any, error) {
+		byContact, err := loadCompaniesByContact(userId, contactIds)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]any{}
+		for _, id := range contactIds {
+			if company, ok := byContact[id]; ok {
+				result[id] = company
+			} else {
+				result[id] = nil
+			}
+		}
+		return result, nil
+	},
+}
+
+func channelInclude(c contactChannel, contactIds []string) (map[string]any, error) {
+	byContact, err := loadChannelByContact(c, contactIds)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]any{}
+	for _, id := range contactIds {
+		entries := byContact[id]
+		if entries == nil {
+			entries = []any{}
+		}
+		result[id] = entries
+	}
+	return result, nil
+}
+
+type contactProjection struct {
+	Fields  []string
+	Include []string
+}
+
+// contactFieldNames - JSON names of the Contacts struct, used to validate fields=
+func contactFieldNames() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(Contacts{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// parseContactProjection - Read fields= and include= from the query string
+func parseContactProjection(r *http.Request) (*contactProjection, []string) {
+	p := &contactProjection{}
+	values := r.URL.Query()
+	errMsgs := []string{}
+
+	if v := values.Get("fields"); v != "" {
+		known := contactFieldNames()
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if !known[field] {
+				errMsgs = append(errMsgs, fmt.Sprintf("field %q is not supported", field))
+				continue
+			}
+			p.Fields = append(p.Fields, field)
+		}
+	}
+
+	if v := values.Get("include"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := contactIncludes[name]; !ok {
+				errMsgs = append(errMsgs, fmt.Sprintf("include %q is not supported", name))
+				continue
+			}
+			p.Include = append(p.Include, name)
+		}
+	}
+
+	if len(errMsgs) > 0 {
+		return nil, errMsgs
+	}
+	return p, nil
+}
+
+func (p *contactProjection) empty() bool {
+	return len(p.Fields) == 0 && len(p.Include) == 0
+}
+
+// render - Project contacts to the requested fields and embed the requested
+// includes. contact_id is always kept so clients can address the resource.
+func (p *contactProjection) render(userId int64, contacts []Contacts) ([]map[string]any, error) {
+	ids := make([]string, len(contacts))
+	for i, contact := range contacts {
+		ids[i] = contact.ContactId
+	}
+
+	included := map[string]map[string]any{}
+	for _, name := range p.Include {
+		byContact, err := contactIncludes[name](userId, ids)
+		if err != nil {
+			return nil, err
+		}
+		included[name] = byContact
+	}
+
+	result := make([]map[string]any, 0, len(contacts))
+	for _, contact := range contacts {
+		b, err := json.Marshal(contact)
+		if err != nil {
+			return nil, err
+		}
+		full := map[string]any{}
+		if err := json.Unmarshal(b, &full); err != nil {
+			return nil, err
+		}
+
+		item := full
+		if len(p.Fields) > 0 {
+			item = map[string]any{"contact_id": contact.ContactId}
+			for _, field := range p.Fields {
+				if v, ok := full[field]; ok {
+					item[field] = v
+				}
+			}
+		}
+		for _, name := range p.Include {
+			item[name] = included[name][contact.ContactId]
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
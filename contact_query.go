@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contactColumns = "contact_id, first_name, last_name, email, phone, user_id, version, created_at, updated_at, deleted_at, phone_raw, photo_key, photo_type, company_id, job_title"
+
+	contactListDefaultLimit = 20
+	contactListMaxLimit     = 100
+)
+
+// contactSortFields maps the public sort keys to their SQL expressions
+var contactSortFields = map[string]string{
+	"first_name": "first_name",
+	"last_name":  "last_name",
+	"email":      "email",
+	"created_at": "created_at",
+	"updated_at": "COALESCE(updated_at, created_at)",
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanContact(row rowScanner, contact *Contacts) error {
+	if err := row.Scan(contactScanDest(contact)...); err != nil {
+		return err
+	}
+	contact.fillPhoneDisplay()
+	contact.fillPhoto()
+	return nil
+}
+
+// contactScanDest - Scan destinations matching contactColumns, for queries
+// that select extra columns after them
+func contactScanDest(contact *Contacts) []any {
+	return []any{&contact.ContactId, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone, &contact.UserId, &contact.Version, &contact.CreatedAt, &contact.UpdatedAt, &contact.DeletedAt, &contact.PhoneRaw, &contact.PhotoKey, &contact.PhotoType, &contact.CompanyId, &contact.JobTitle}
+}
+
+type contactSort struct {
+	Field string
+	Desc  bool
+}
+
+type contactListQuery struct {
+	UserId  int64
+	Trashed bool
+	Sorts   []contactSort
+	Limit   int
+	After   []string
+	where   []string
+	args    []any
+}
+
+type contactCursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// parseContactListQuery - Read sort, limit, cursor and filters from the query string
+func parseContactListQuery(r *http.Request, userId int64) (*contactListQuery, []string) {
+	q := &contactListQuery{UserId: userId}
+	values := r.URL.Query()
+	errMsgs := []string{}
+
+	if v := values.Get("sort"); v != "" {
+		seen := map[string]bool{}
+		for _, key := range strings.Split(v, ",") {
+			key = strings.TrimSpace(key)
+			desc := strings.HasPrefix(key, "-")
+			key = strings.TrimPrefix(strings.TrimPrefix(key, "-"), "+")
+			if _, ok := contactSortFields[key]; !ok {
+				errMsgs = append(errMsgs, fmt.Sprintf("sort field %q is not supported", key))
+				continue
+			}
+			if seen[key] {
+				errMsgs = append(errMsgs, fmt.Sprintf("sort field %q is duplicated", key))
+				continue
+			}
+			seen[key] = true
+			q.Sorts = append(q.Sorts, contactSort{Field: key, Desc: desc})
+		}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > contactListMaxLimit {
+			errMsgs = append(errMsgs, fmt.Sprintf("limit must be between 1 and %d", contactListMaxLimit))
+		}
+		q.Limit = n
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if q.Limit == 0 {
+			q.Limit = contactListDefaultLimit
+		}
+		cursor, err := decodeContactCursor(v)
+		if err != nil || cursor.Sort != q.sortSpec() || !q.validCursorValues(cursor.Values) {
+			errMsgs = append(errMsgs, "cursor is invalid for this sort")
+		} else {
+			q.After = cursor.Values
+		}
+	}
+
+	if v := values.Get("tag"); v != "" {
+		if err := q.addTagFilter(strings.Split(v, ","), values.Get("tag_mode")); err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+
+	if v := values.Get("company_id"); v != "" {
+		if id, err := strconv.ParseInt(v, 10, 64); err != nil || id < 1 {
+			errMsgs = append(errMsgs, "company_id is invalid")
+		} else {
+			q.addFilter("company_id = ?", id)
+		}
+	}
+
+	errMsgs = append(errMsgs, q.addCustomFieldFilters(values)...)
+
+	if len(errMsgs) > 0 {
+		return nil, errMsgs
+	}
+	return q, nil
+}
+
+func (q *contactListQuery) sortSpec() string {
+	keys := []string{}
+	for _, s := range q.Sorts {
+		if s.Desc {
+			keys = append(keys, "-"+s.Field)
+		} else {
+			keys = append(keys, s.Field)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// addFilter - Add an extra WHERE condition on the contacts table
+func (q *contactListQuery) addFilter(cond string, args ...any) {
+	q.where = append(q.where, cond)
+	q.args = append(q.args, args...)
+}
+
+// build - Assemble the SELECT. contact_id is always the last sort key so the
+// keyset condition is total and the cursor never skips or repeats rows.
+func (q *contactListQuery) build() (string, []any) {
+	where := []string{"user_id = ?", "deleted_at IS NULL"}
+	if q.Trashed {
+		where[1] = "deleted_at IS NOT NULL"
+	}
+	args := []any{q.UserId}
+	where = append(where, q.where...)
+	args = append(args, q.args...)
+
+	exprs := []string{}
+	descs := []bool{}
+	for _, s := range q.Sorts {
+		exprs = append(exprs, contactSortFields[s.Field])
+		descs = append(descs, s.Desc)
+	}
+	exprs = append(exprs, "contact_id")
+	descs = append(descs, false)
+
+	if q.After != nil {
+		// (a > ?) OR (a = ? AND b > ?) OR ... with the operator flipped for DESC keys
+		ors := []string{}
+		for i := range exprs {
+			ands := []string{}
+			for j := 0; j < i; j++ {
+				ands = append(ands, exprs[j]+" = ?")
+				args = append(args, q.After[j])
+			}
+			op := ">"
+			if descs[i] {
+				op = "<"
+			}
+			ands = append(ands, exprs[i]+" "+op+" ?")
+			args = append(args, q.After[i])
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+		where = append(where, "("+strings.Join(ors, " OR ")+")")
+	}
+
+	order := []string{}
+	for i, expr := range exprs {
+		if descs[i] {
+			order = append(order, expr+" DESC")
+		} else {
+			order = append(order, expr+" ASC")
+		}
+	}
+
+	query := "SELECT " + contactColumns + " FROM contacts WHERE " + strings.Join(where, " AND ") + " ORDER BY " + strings.Join(order, ", ")
+	if q.Limit > 0 {
+		// one extra row tells us whether there is a next page
+		query += " LIMIT " + strconv.Itoa(q.Limit+1)
+	}
+	return query, args
+}
+
+// nextCursor - Encode the keyset position right after the given contact
+func (q *contactListQuery) nextCursor(last Contacts) string {
+	b, _ := json.Marshal(contactCursor{Sort: q.sortSpec(), Values: q.cursorValues(last)})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// advance - Move the query to the page after the given contact, for callers
+// that walk the whole listing page by page
+func (q *contactListQuery) advance(last Contacts) {
+	q.After = q.cursorValues(last)
+}
+
+func (q *contactListQuery) cursorValues(last Contacts) []string {
+	values := []string{}
+	for _, s := range q.Sorts {
+		values = append(values, contactSortValue(last, s.Field))
+	}
+	return append(values, last.ContactId)
+}
+
+// validCursorValues - One value per sort key plus the contact_id, each
+// looking like something cursorValues produced
+func (q *contactListQuery) validCursorValues(values []string) bool {
+	if len(values) != len(q.Sorts)+1 {
+		return false
+	}
+	for i, s := range q.Sorts {
+		if s.Field == "created_at" || s.Field == "updated_at" {
+			if _, err := time.Parse("2006-01-02 15:04:05.999999", values[i]); err != nil {
+				return false
+			}
+		}
+	}
+	id, err := strconv.ParseInt(values[len(values)-1], 10, 64)
+	return err == nil && id > 0
+}
+
+func decodeContactCursor(v string) (contactCursor, error) {
+	var cursor contactCursor
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(b, &cursor)
+	return cursor, err
+}
+
+func contactSortValue(contact Contacts, field string) string {
+	switch field {
+	case "first_name":
+		return contact.FirstName
+	case "last_name":
+		return contact.LastName
+	case "email":
+		return contact.Email
+	case "created_at":
+		return mysqlTime(contact.CreatedAt)
+	case "updated_at":
+		if contact.UpdatedAt != nil {
+			return mysqlTime(contact.UpdatedAt)
+		}
+		return mysqlTime(contact.CreatedAt)
+	}
+	return ""
+}
+
+// mysqlTime - Convert a scanned RFC3339 timestamp back into a DATETIME literal
+func mysqlTime(v *string) string {
+	if v == nil {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339Nano, *v)
+	if err != nil {
+		return *v
+	}
+	return t.Format("2006-01-02 15:04:05.999999")
+}
+
+// forEachContactPage - Walk every contact matched by q in keyset pages of
+// pageSize, so callers can stream large result sets without holding them
+func forEachContactPage(q *contactListQuery, pageSize int, fn func(contacts []Contacts) error) error {
+	db := GetDB()
+
+	q.Limit = pageSize
+	q.After = nil
+	for {
+		query, args := q.build()
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+
+		contacts := []Contacts{}
+		for rows.Next() {
+			var contact Contacts
+			if err := scanContact(rows, &contact); err != nil {
+				rows.Close()
+				return err
+			}
+			contacts = append(contacts, contact)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		more := len(contacts) > pageSize
+		if more {
+			contacts = contacts[:pageSize]
+		}
+		if len(contacts) > 0 {
+			if err := attachCustomFields(contacts); err != nil {
+				return err
+			}
+			if err := fn(contacts); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+		q.advance(contacts[len(contacts)-1])
+	}
+}
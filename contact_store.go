@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// sqlExecutor - Common subset of *sql.DB and *sql.Tx so the same write
+// helpers work inside and outside a transaction
+type sqlExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// insertContact - Insert a validated contact and return its new ID
+func insertContact(exec sqlExecutor, contact Contacts, userId int64, actor historyActor) (string, error) {
+	result, err := exec.Exec("INSERT INTO contacts (first_name, last_name, email, phone, phone_raw, user_id) VALUES (?, ?, ?, ?, ?, ?)", contact.FirstName, contact.LastName, contact.Email, contact.Phone, contact.PhoneRaw, userId)
+	if err != nil {
+		return "", err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	contactId := strconv.FormatInt(id, 10)
+	if err := syncPrimaryChannels(exec, contactId, contact); err != nil {
+		return "", err
+	}
+	if contact.CustomFields != nil {
+		if err := saveCustomFieldValues(exec, userId, contactId, contact.CustomFields); err != nil {
+			return "", err
+		}
+	}
+	if err := recordContactHistory(exec, actor, "created", contactId); err != nil {
+		return "", err
+	}
+	return contactId, nil
+}
+
+// updateContactRow - Overwrite the editable fields of a live contact. With a
+// non-nil version the row must still be at that version. Returns false when
+// no row matched. The primary email / phone entries follow the new values;
+// custom field values are replaced only when the contact carries a
+// custom_fields object.
+func updateContactRow(exec sqlExecutor, contact Contacts, contactId string, userId int64, version *int64, actor historyActor) (bool, error) {
+	query := "UPDATE contacts SET first_name = ?, last_name = ?, email = ?, phone = ?, phone_raw = ?, version = version + 1 WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL"
+	args := []any{contact.FirstName, contact.LastName, contact.Email, contact.Phone, contact.PhoneRaw, contactId, userId}
+	if version != nil {
+		query += " AND version = ?"
+		args = append(args, *version)
+	}
+	result, err := exec.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if err := syncPrimaryChannels(exec, contactId, contact); err != nil {
+		return false, err
+	}
+	if contact.CustomFields != nil {
+		if err := saveCustomFieldValues(exec, userId, contactId, contact.CustomFields); err != nil {
+			return false, err
+		}
+	}
+	if err := recordContactHistory(exec, actor, "updated", contactId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// softDeleteContactRow - Move a contact and its live addresses to the trash.
+// Both get the same deleted_at so a restore knows which addresses went
+// together with the contact. Run it inside a transaction.
+func softDeleteContactRow(exec sqlExecutor, contactId string, userId int64, version *int64, actor historyActor) (bool, error) {
+	deletedAt := softDeleteTime()
+
+	query := "UPDATE contacts SET deleted_at = ?, version = version + 1 WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL"
+	args := []any{deletedAt, contactId, userId}
+	if version != nil {
+		query += " AND version = ?"
+		args = append(args, *version)
+	}
+	result, err := exec.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	_, err = exec.Exec("UPDATE addresses SET deleted_at = ?, version = version + 1 WHERE contact_id = ? AND deleted_at IS NULL", deletedAt, contactId)
+	if err == nil {
+		err = recordContactHistory(exec, actor, "deleted", contactId)
+	}
+	if err == nil {
+		err = recordAddressHistory(exec, actor, "deleted", "contact_id = ? AND deleted_at = ?", contactId, deletedAt)
+	}
+	return err == nil, err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func contactListRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/contact", AuthMiddleware(GetContacts))
+	return router
+}
+
+// listContactIds - Walk every page of the listing and return the ids in the
+// order they came
+func listContactIds(t *testing.T, router http.Handler, user Users, query url.Values) []string {
+	t.Helper()
+
+	ids := []string{}
+	for page := 0; page < 10; page++ {
+		code, body := serveJSON(t, router, "GET", "/contact?"+query.Encode(), user, "")
+		if code != http.StatusOK {
+			t.Fatalf("GET /contact?%s: got %d %v", query.Encode(), code, body)
+		}
+		for _, item := range body["data"].([]any) {
+			ids = append(ids, item.(map[string]any)["contact_id"].(string))
+		}
+		next, _ := body["next_cursor"].(string)
+		if next == "" {
+			return ids
+		}
+		query.Set("cursor", next)
+	}
+	t.Fatal("the listing didn't end after 10 pages")
+	return nil
+}
+
+func TestGetContactsCursorPagination(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "cursor@example.com")
+	router := contactListRouter()
+
+	// ties on first_name are broken by contact_id
+	names := []string{"Budi", "Ani", "Budi", "Ani", "Citra", "Ani"}
+	byId := map[string]string{}
+	for _, name := range names {
+		byId[createTestContact(t, user, name)] = name
+	}
+	want := []string{"2", "4", "6", "1", "3", "5"}
+	wantDesc := []string{"5", "1", "3", "2", "4", "6"}
+
+	if got := listContactIds(t, router, user, url.Values{"sort": {"first_name"}, "limit": {"2"}}); !slices.Equal(got, want) {
+		t.Errorf("sort=first_name: got %v, want %v", got, want)
+	}
+	if got := listContactIds(t, router, user, url.Values{"sort": {"-first_name"}, "limit": {"4"}}); !slices.Equal(got, wantDesc) {
+		t.Errorf("sort=-first_name: got %v, want %v", got, wantDesc)
+	}
+	if got := listContactIds(t, router, user, url.Values{"sort": {"-created_at"}, "limit": {"5"}}); len(got) != len(names) {
+		t.Errorf("sort=-created_at: got %v, want all %d contacts once", got, len(names))
+	}
+
+	// the cursor holds the sort and the last row's keys
+	_, body := serveJSON(t, router, "GET", "/contact?sort=first_name&limit=2", user, "")
+	raw, err := base64.RawURLEncoding.DecodeString(body["next_cursor"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cursor contactCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Sort != "first_name" || !slices.Equal(cursor.Values, []string{"Ani", "4"}) {
+		t.Errorf("got cursor %+v, want first_name after Ani/4", cursor)
+	}
+}
+
+func TestGetContactsInvalidCursor(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "badcursor@example.com")
+	router := contactListRouter()
+
+	encode := func(c contactCursor) string {
+		b, _ := json.Marshal(c)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	for name, query := range map[string]string{
+		"not base64":         "sort=first_name&cursor=@@@",
+		"not JSON":           "sort=first_name&cursor=" + base64.RawURLEncoding.EncodeToString([]byte("Ani,4")),
+		"other sort":         "sort=last_name&cursor=" + encode(contactCursor{Sort: "first_name", Values: []string{"Ani", "4"}}),
+		"missing value":      "sort=first_name&cursor=" + encode(contactCursor{Sort: "first_name", Values: []string{"4"}}),
+		"extra value":        "sort=first_name&cursor=" + encode(contactCursor{Sort: "first_name", Values: []string{"Ani", "Test", "4"}}),
+		"tampered id":        "sort=first_name&cursor=" + encode(contactCursor{Sort: "first_name", Values: []string{"Ani", "4 OR 1=1"}}),
+		"tampered time":      "sort=created_at&cursor=" + encode(contactCursor{Sort: "created_at", Values: []string{"yesterday", "4"}}),
+		"sync token":         "cursor=" + encodeSyncToken(7),
+		"limit out of range": "limit=1000&cursor=" + encode(contactCursor{Sort: "", Values: []string{"4"}}),
+	} {
+		code, body := serveJSON(t, router, "GET", "/contact?"+query, user, "")
+		if code != http.StatusBadRequest || body["errors"] == nil {
+			t.Errorf("%s: got %d %v, want 400", name, code, body)
+		}
+	}
+
+	if code, body := serveJSON(t, router, "GET", "/contact?cursor="+encode(contactCursor{Sort: "", Values: []string{"4"}}), user, ""); code != http.StatusOK {
+		t.Errorf("valid cursor without sort: got %d %v, want 200", code, body)
+	}
+}
+
+func TestContactConditionalRequests(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "etag@example.com")
+	contactId := createTestContact(t, user, "Budi")
+	path := "/contact/" + contactId
+
+	router := httprouter.New()
+	router.GET("/contact/:id", AuthMiddleware(GetContactId))
+	router.PUT("/contact/:id", AuthMiddleware(UpdateContact))
+	router.PATCH("/contact/:id", AuthMiddleware(PatchContact))
+	router.DELETE("/contact/:id", AuthMiddleware(DeleteContact))
+
+	w := serve(t, router, "GET", path, user, "", nil)
+	first := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || first != `"c`+contactId+`-1"` {
+		t.Fatalf("GET: got %d with ETag %q", w.Code, first)
+	}
+	for _, header := range []string{first, "W/" + first, `"other", ` + first, "*"} {
+		if w := serve(t, router, "GET", path, user, "", http.Header{"If-None-Match": {header}}); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: got %d, want 304 without a body", header, w.Code)
+		}
+	}
+
+	// every write moves the version and with it the ETag
+	update := `{"first_name": "Budi", "last_name": "Santoso", "email": "budi@example.com", "phone": "081234567890"}`
+	w = serve(t, router, "PUT", path, user, update, http.Header{"If-Match": {first}})
+	second := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || second != `"c`+contactId+`-2"` {
+		t.Fatalf("PUT with If-Match: got %d with ETag %q, want version 2", w.Code, second)
+	}
+	if w := serve(t, router, "GET", path, user, "", http.Header{"If-None-Match": {first}}); w.Code != http.StatusOK {
+		t.Errorf("If-None-Match with the old ETag: got %d, want 200", w.Code)
+	}
+
+	// a stale copy can't overwrite or delete the newer one
+	for _, tc := range []struct{ method, body string }{
+		{"PUT", update},
+		{"PATCH", `{"last_name": "Wijaya"}`},
+		{"DELETE", ""},
+	} {
+		if w := serve(t, router, tc.method, path, user, tc.body, http.Header{"If-Match": {first}}); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s with a stale If-Match: got %d %s, want 412", tc.method, w.Code, w.Body.String())
+		}
+	}
+	// strong comparison: a weak tag never matches If-Match
+	if w := serve(t, router, "PATCH", path, user, `{"last_name": "Wijaya"}`, http.Header{"If-Match": {"W/" + second}}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a weak If-Match: got %d, want 412", w.Code)
+	}
+
+	var contact Contacts
+	if err := scanContact(GetDB().QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", contactId), &contact); err != nil {
+		t.Fatal(err)
+	}
+	if contact.Version != 2 || contact.LastName != "Santoso" || contact.DeletedAt != nil {
+		t.Errorf("the rejected writes changed the contact: %+v", contact)
+	}
+
+	w = serve(t, router, "PATCH", path, user, `{"last_name": "Wijaya"}`, http.Header{"If-Match": {second}})
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"c`+contactId+`-3"` {
+		t.Errorf("PATCH with If-Match: got %d with ETag %q, want version 3", w.Code, w.Header().Get("ETag"))
+	}
+	if w := serve(t, router, "DELETE", path, user, "", http.Header{"If-Match": {w.Header().Get("ETag")}}); w.Code != http.StatusOK {
+		t.Errorf("DELETE with If-Match: got %d %s, want 200", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	customFieldText   = "text"
+	customFieldNumber = "number"
+	customFieldDate   = "date"
+	customFieldEnum   = "enum"
+	customFieldURL    = "url"
+
+	customFieldDefaultMaxLength = 1000
+)
+
+// CustomFields - A user's definition of an extra contact field. Values live
+// in contact_custom_values and are exposed as contact.custom_fields[name].
+type CustomFields struct {
+	FieldId   int64    `json:"field_id"`
+	Name      string   `json:"name" validate:"required,max=50"`
+	Label     string   `json:"label" validate:"max=100"`
+	Type      string   `json:"type" validate:"required,oneof=text number date enum url"`
+	Required  bool     `json:"required"`
+	Options   []string `json:"options,omitempty" validate:"required_if=Type enum,max=100,dive,required,max=100"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	MaxLength *int     `json:"max_length,omitempty" validate:"omitempty,min=1,max=1000"`
+	CreatedAt *string  `json:"created_at,omitempty"`
+	UpdatedAt *string  `json:"updated_at,omitempty"`
+}
+
+var customFieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+const customFieldColumns = "field_id, name, label, type, required, options, min_value, max_value, max_length, created_at, updated_at"
+
+func scanCustomField(row rowScanner, field *CustomFields) error {
+	var options *string
+	if err := row.Scan(&field.FieldId, &field.Name, &field.Label, &field.Type, &field.Required, &options, &field.Min, &field.Max, &field.MaxLength, &field.CreatedAt, &field.UpdatedAt); err != nil {
+		return err
+	}
+	if options != nil {
+		return json.Unmarshal([]byte(*options), &field.Options)
+	}
+	return nil
+}
+
+// loadCustomFields - The user's field definitions keyed by name
+func loadCustomFields(exec sqlExecutor, userId int64) (map[string]CustomFields, error) {
+	rows, err := exec.Query("SELECT "+customFieldColumns+" FROM custom_fields WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fields := map[string]CustomFields{}
+	for rows.Next() {
+		var field CustomFields
+		if err := scanCustomField(rows, &field); err != nil {
+			return nil, err
+		}
+		fields[field.Name] = field
+	}
+	return fields, rows.Err()
+}
+
+// validateDefinition - Rules that the struct tags can't express
+func (f CustomFields) validateDefinition() []string {
+	errMsgs := []string{}
+	if !customFieldNamePattern.MatchString(f.Name) {
+		errMsgs = append(errMsgs, "name must start with a lowercase letter and contain only a-z, 0-9 and _")
+	}
+	if f.Type != customFieldEnum && len(f.Options) > 0 {
+		errMsgs = append(errMsgs, "options is only allowed for enum fields")
+	}
+	if f.Type != customFieldNumber && (f.Min != nil || f.Max != nil) {
+		errMsgs = append(errMsgs, "min and max are only allowed for number fields")
+	}
+	if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		errMsgs = append(errMsgs, "min must not be greater than max")
+	}
+	if f.Type != customFieldText && f.MaxLength != nil {
+		errMsgs = append(errMsgs, "max_length is only allowed for text fields")
+	}
+	return errMsgs
+}
+
+// validateValue - Check one value against the definition; nil means unset
+func (f CustomFields) validateValue(value any) error {
+	if value == nil {
+		if f.Required {
+			return fmt.Errorf("custom_fields.%s is required", f.Name)
+		}
+		return nil
+	}
+
+	if f.Type == customFieldNumber {
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("custom_fields.%s must be a number", f.Name)
+		}
+		if f.Min != nil && n < *f.Min {
+			return fmt.Errorf("custom_fields.%s must be at least %v", f.Name, *f.Min)
+		}
+		if f.Max != nil && n > *f.Max {
+			return fmt.Errorf("custom_fields.%s must be at most %v", f.Name, *f.Max)
+		}
+		return nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("custom_fields.%s must be a string", f.Name)
+	}
+	if f.Required && strings.TrimSpace(s) == "" {
+		return fmt.Errorf("custom_fields.%s is required", f.Name)
+	}
+
+	switch f.Type {
+	case customFieldText:
+		maxLength := customFieldDefaultMaxLength
+		if f.MaxLength != nil {
+			maxLength = *f.MaxLength
+		}
+		if len([]rune(s)) > maxLength {
+			return fmt.Errorf("custom_fields.%s must be at most %d characters", f.Name, maxLength)
+		}
+	case customFieldDate:
+		if _, err := time.Parse("2006-01-02", s); err != nil {
+			return fmt.Errorf("custom_fields.%s must be a date (YYYY-MM-DD)", f.Name)
+		}
+	case customFieldEnum:
+		for _, option := range f.Options {
+			if option == s {
+				return nil
+			}
+		}
+		return fmt.Errorf("custom_fields.%s must be one of %s", f.Name, strings.Join(f.Options, ", "))
+	case customFieldURL:
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(s) > customFieldDefaultMaxLength {
+			return fmt.Errorf("custom_fields.%s must be an http or https URL", f.Name)
+		}
+	}
+	return nil
+}
+
+// customFieldErrors - Validate contact.custom_fields against the user's
+// definitions. Every required field must be present.
+func customFieldErrors(exec sqlExecutor, userId int64, values map[string]any) ([]string, error) {
+	fields, err := loadCustomFields(exec, userId)
+	if err != nil {
+		return nil, err
+	}
+	return validateCustomFieldValues(fields, values), nil
+}
+
+// validateCustomFieldValues - customFieldErrors with the definitions
+// already loaded, for callers that check many contacts at once
+func validateCustomFieldValues(fields map[string]CustomFields, values map[string]any) []string {
+	errMsgs := []string{}
+	for name := range values {
+		if _, ok := fields[name]; !ok {
+			errMsgs = append(errMsgs, fmt.Sprintf("custom_fields.%s is not defined", name))
+		}
+	}
+	for name, field := range fields {
+		if err := field.validateValue(values[name]); err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+	sort.Strings(errMsgs)
+	return errMsgs
+}
+
+// writeCustomFieldErrors - 400 with the validation messages, or 500 when the
+// definitions couldn't be loaded
+func writeCustomFieldErrors(w http.ResponseWriter, errMsgs []string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	w.WriteHeader(400)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": errMsgs,
+	})
+}
+
+// saveCustomFieldValues - Replace the stored values of a contact with an
+// already validated custom_fields object
+func saveCustomFieldValues(exec sqlExecutor, userId int64, contactId string, values map[string]any) error {
+	fields, err := loadCustomFields(exec, userId)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.Exec("DELETE FROM contact_custom_values WHERE contact_id = ?", contactId); err != nil {
+		return err
+	}
+
+	for name, value := range values {
+		field, ok := fields[name]
+		if !ok || value == nil {
+			continue
+		}
+		var text, number, date any
+		switch field.Type {
+		case customFieldNumber:
+			number = value
+		case customFieldDate:
+			date = value
+		default:
+			text = value
+		}
+		if _, err := exec.Exec("INSERT INTO contact_custom_values (contact_id, field_id, value_text, value_number, value_date) VALUES (?, ?, ?, ?, ?)", contactId, field.FieldId, text, number, date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachCustomFields - Fill Contacts.CustomFields for a page of contacts
+func attachCustomFields(contacts []Contacts) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(contacts)-1) + "?"
+	args := make([]any, len(contacts))
+	byId := map[string]int{}
+	for i, contact := range contacts {
+		args[i] = contact.ContactId
+		byId[contact.ContactId] = i
+		contacts[i].CustomFields = map[string]any{}
+	}
+
+	rows, err := GetDB().Query("SELECT v.contact_id, f.name, f.type, v.value_text, v.value_number, DATE_FORMAT(v.value_date, '%Y-%m-%d') FROM contact_custom_values v JOIN custom_fields f ON f.field_id = v.field_id WHERE v.contact_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contactId, name, fieldType string
+		var text, date *string
+		var number *float64
+		if err := rows.Scan(&contactId, &name, &fieldType, &text, &number, &date); err != nil {
+			return err
+		}
+		var value any
+		switch {
+		case fieldType == customFieldNumber && number != nil:
+			value = *number
+		case fieldType == customFieldDate && date != nil:
+			value = *date
+		case text != nil:
+			value = *text
+		default:
+			continue
+		}
+		if i, ok := byId[contactId]; ok {
+			contacts[i].CustomFields[name] = value
+		}
+	}
+	return rows.Err()
+}
+
+// addCustomFieldFilters - custom.<name>=value, or custom.<name>.<op>=value
+// with op one of eq, gt, gte, lt, lte (number, date) and contains (text, url)
+func (q *contactListQuery) addCustomFieldFilters(values url.Values) []string {
+	var fields map[string]CustomFields
+	errMsgs := []string{}
+
+	for key, vs := range values {
+		rest, ok := strings.CutPrefix(key, "custom.")
+		if !ok {
+			continue
+		}
+		if fields == nil {
+			var err error
+			if fields, err = loadCustomFields(GetDB(), q.UserId); err != nil {
+				return []string{"custom field filters are unavailable"}
+			}
+		}
+
+		name, op, _ := strings.Cut(rest, ".")
+		if op == "" {
+			op = "eq"
+		}
+		field, ok := fields[name]
+		if !ok {
+			errMsgs = append(errMsgs, fmt.Sprintf("custom field %q is not defined", name))
+			continue
+		}
+
+		operators := map[string]string{"eq": "=", "gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+		column := "value_text"
+		var arg any = vs[0]
+		switch field.Type {
+		case customFieldNumber:
+			column = "value_number"
+			n, err := strconv.ParseFloat(vs[0], 64)
+			if err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf("custom.%s must be a number", name))
+				continue
+			}
+			arg = n
+		case customFieldDate:
+			column = "value_date"
+			if _, err := time.Parse("2006-01-02", vs[0]); err != nil {
+				errMsgs = append(errMsgs, fmt.Sprintf("custom.%s must be a date (YYYY-MM-DD)", name))
+				continue
+			}
+		case customFieldText, customFieldURL:
+			operators = map[string]string{"eq": "=", "contains": "LIKE"}
+			if op == "contains" {
+				arg = "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(vs[0]) + "%"
+			}
+		case customFieldEnum:
+			operators = map[string]string{"eq": "="}
+		}
+
+		operator, ok := operators[op]
+		if !ok {
+			errMsgs = append(errMsgs, fmt.Sprintf("operator %q is not supported for %s field %q", op, field.Type, name))
+			continue
+		}
+		q.addFilter("contact_id IN (SELECT contact_id FROM contact_custom_values WHERE field_id = ? AND "+column+" "+operator+" ?)", field.FieldId, arg)
+	}
+	return errMsgs
+}
+
+// readCustomField - Decode and validate a definition, writing the 400 itself
+func readCustomField(w http.ResponseWriter, r *http.Request) (CustomFields, bool) {
+	var field CustomFields
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&field) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return field, false
+	}
+
+	validate := validator.New()
+	errMsgs := []string{}
+	if err := validate.Struct(field); err != nil {
+		errMsgs = validationMessages(err)
+	}
+	errMsgs = append(errMsgs, field.validateDefinition()...)
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return field, false
+	}
+	return field, true
+}
+
+func marshalOptions(options []string) *string {
+	if len(options) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(options)
+	s := string(b)
+	return &s
+}
+
+func CreateCustomField(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	field, ok := readCustomField(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	result, err := db.Exec("INSERT INTO custom_fields (user_id, name, label, type, required, options, min_value, max_value, max_length) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		ctxUser.UserId, field.Name, field.Label, field.Type, field.Required, marshalOptions(field.Options), field.Min, field.Max, field.MaxLength)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Custom field already exists",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	field.FieldId, _ = result.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Custom field created successfully",
+		"data":    field,
+	})
+}
+
+func GetCustomFields(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rows, err := db.Query("SELECT "+customFieldColumns+" FROM custom_fields WHERE user_id = ? ORDER BY field_id", ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	fields := []CustomFields{}
+	for rows.Next() {
+		var field CustomFields
+		if err := scanCustomField(rows, &field); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		fields = append(fields, field)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    fields,
+	})
+}
+
+func GetCustomFieldId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var field CustomFields
+	if err := scanCustomField(db.QueryRow("SELECT "+customFieldColumns+" FROM custom_fields WHERE field_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId), &field); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Custom field not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    field,
+	})
+}
+
+// UpdateCustomField - Change label, required flag and validation rules. The
+// name and type are fixed once values may exist.
+func UpdateCustomField(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	field, ok := readCustomField(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var current CustomFields
+	if err := scanCustomField(db.QueryRow("SELECT "+customFieldColumns+" FROM custom_fields WHERE field_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId), &current); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Custom field not found",
+		})
+		return
+	}
+	if field.Name != current.Name || field.Type != current.Type {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"name and type can't be changed"},
+		})
+		return
+	}
+
+	_, err := db.Exec("UPDATE custom_fields SET label = ?, required = ?, options = ?, min_value = ?, max_value = ?, max_length = ? WHERE field_id = ? AND user_id = ?",
+		field.Label, field.Required, marshalOptions(field.Options), field.Min, field.Max, field.MaxLength, current.FieldId, ctxUser.UserId)
+	if err == nil {
+		err = scanCustomField(db.QueryRow("SELECT "+customFieldColumns+" FROM custom_fields WHERE field_id = ?", current.FieldId), &field)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Custom field updated successfully",
+		"data":    field,
+	})
+}
+
+// DeleteCustomField - Remove a definition and its values. Contacts that had
+// a value get a new version since their representation changes.
+func DeleteCustomField(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM custom_fields WHERE field_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId)
+	var deleted int64
+	if err == nil {
+		deleted, _ = result.RowsAffected()
+	}
+	if err == nil && deleted > 0 {
+		var contactIds []string
+		contactIds, err = queryIds(tx, "SELECT contact_id FROM contact_custom_values WHERE field_id = ? ORDER BY contact_id", ps.ByName("id"))
+		if err == nil {
+			err = execIn(tx, "UPDATE contacts SET version = version + 1 WHERE contact_id IN", contactIds)
+		}
+		if err == nil {
+			err = recordSyncChanges(tx, ctxUser.UserId, "contact", contactIds)
+		}
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM contact_custom_values WHERE field_id = ?", ps.ByName("id"))
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if deleted == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Custom field not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Custom field deleted successfully",
+	})
+}
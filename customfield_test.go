@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Import and CardDAV can't fill custom fields, so a required one stops them
+// from creating contacts, and a full PUT has to send it like a create
+func TestRequiredCustomFieldOnEveryWritePath(t *testing.T) {
+	client, _, user := newCardDAVTest(t)
+	contactId := createTestContact(t, user, "Budi")
+
+	router := httprouter.New()
+	router.POST("/custom-field", AuthMiddleware(CreateCustomField))
+	router.POST("/contact/:id", AuthMiddleware(staticSegment("id", notFound, map[string]httprouter.Handle{
+		"bulk":   BulkContacts,
+		"import": ImportContacts,
+	})))
+	router.PUT("/contact/:id", AuthMiddleware(UpdateContact))
+
+	if code, body := serveJSON(t, router, "POST", "/custom-field", user, `{"name": "tier", "type": "text", "required": true}`); code != http.StatusCreated {
+		t.Fatalf("create custom field: got %d %v", code, body)
+	}
+	wantErr := "custom_fields.tier is required"
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "contacts.csv")
+	part.Write([]byte("first_name,last_name,email,phone\nSiti,Aminah,siti@example.com,081234567891\n"))
+	mw.Close()
+	w := serve(t, router, "POST", "/contact/import", user, form.String(), http.Header{"Content-Type": {mw.FormDataContentType()}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), wantErr) {
+		t.Errorf("CSV import: got %d %s, want 400 with %q", w.Code, w.Body.String(), wantErr)
+	}
+
+	vcf := "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Siti Aminah\r\nN:Aminah;Siti;;;\r\nEMAIL:siti@example.com\r\nTEL:081234567891\r\nEND:VCARD\r\n"
+	w = serve(t, router, "POST", "/contact/import", user, vcf, http.Header{"Content-Type": {"text/vcard"}})
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), wantErr) {
+		t.Errorf("vCard import: got %d %s, want 400 with %q", w.Code, w.Body.String(), wantErr)
+	}
+
+	if _, err := client.PutAddressObject(context.Background(), carddavBookPath+"siti.vcf", testVCard("siti", "Siti", "Aminah", "siti@example.com")); err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("CardDAV create: got %v, want a 403 with %q", err, wantErr)
+	}
+
+	var count int
+	if err := GetDB().QueryRow("SELECT COUNT(*) FROM contacts WHERE user_id = ?", user.UserId).Scan(&count); err != nil || count != 1 {
+		t.Errorf("got %d contacts, %v, want only the one created before", count, err)
+	}
+
+	contact := `{"first_name": "Budi", "last_name": "Test", "email": "budi@example.com", "phone": "081234567890"`
+	if code, body := serveJSON(t, router, "PUT", "/contact/"+contactId, user, contact+`}`); code != http.StatusBadRequest || !strings.Contains(fmt.Sprint(body), wantErr) {
+		t.Errorf("PUT without custom_fields: got %d %v, want 400 with %q", code, body, wantErr)
+	}
+	if code, body := serveJSON(t, router, "POST", "/contact/bulk", user, `{"mode": "atomic", "operations": [{"op": "update", "contact_id": "`+contactId+`", "data": `+contact+`}}]}`); code == http.StatusOK || !strings.Contains(fmt.Sprint(body), wantErr) {
+		t.Errorf("bulk update without custom_fields: got %d %v, want an error with %q", code, body, wantErr)
+	}
+	if code, body := serveJSON(t, router, "PUT", "/contact/"+contactId, user, contact+`, "custom_fields": {"tier": "gold"}}`); code != http.StatusOK {
+		t.Errorf("PUT with custom_fields: got %d %v, want 200", code, body)
+	}
+
+	// an existing card keeps its stored values when written over CardDAV
+	card := testVCard("contact-"+contactId, "Budi", "Santoso", "budi@example.com")
+	if _, err := client.PutAddressObject(context.Background(), carddavBookPath+"contact-"+contactId+".vcf", card); err != nil {
+		t.Fatalf("CardDAV update: %v", err)
+	}
+	var tier string
+	if err := GetDB().QueryRow("SELECT value_text FROM contact_custom_values WHERE contact_id = ?", contactId).Scan(&tier); err != nil || tier != "gold" {
+		t.Errorf("got tier %q, %v after the CardDAV update, want gold", tier, err)
+	}
+}
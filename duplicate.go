@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	duplicateDefaultMinConfidence = 0.5
+	duplicateDefaultLimit         = 50
+	duplicateMaxLimit             = 200
+
+	// name pairs below this Jaro-Winkler similarity are not candidates
+	duplicateNameThreshold = 0.88
+	// blocks larger than this are usually very common name prefixes and
+	// would make the comparison quadratic; they're skipped for name matching
+	duplicateMaxBlockSize = 500
+)
+
+type duplicateCandidate struct {
+	contact Contacts
+	emails  map[string]bool
+	phones  map[string]bool
+	name    string
+	swapped string
+}
+
+type duplicatePair struct {
+	ContactIds []string   `json:"contact_ids"`
+	Contacts   []Contacts `json:"contacts"`
+	Confidence float64    `json:"confidence"`
+	Reasons    []string   `json:"reasons"`
+}
+
+// normalizeEmail - Lowercase, and for Gmail drop dots and +tags which the
+// provider ignores
+func normalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	if domain == "gmail.com" || domain == "googlemail.com" {
+		local, _, _ = strings.Cut(local, "+")
+		local = strings.ReplaceAll(local, ".", "")
+		domain = "gmail.com"
+	}
+	return local + "@" + domain
+}
+
+// normalizePhoneKey - E.164 when the number parses, otherwise its digits
+func normalizePhoneKey(phone string, region string) string {
+	if number, err := parsePhone(phone, region); err == nil {
+		return number.E164
+	}
+	return phoneDigits(phone)
+}
+
+// normalizeName - Lowercase, strip accents and punctuation, collapse spaces
+func normalizeName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			space = false
+		case !space && b.Len() > 0:
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// jaroWinkler - Similarity of two strings between 0 and 1
+func jaroWinkler(a, b string) float64 {
+	ar, br := []rune(a), []rune(b)
+	if len(ar) == 0 || len(br) == 0 {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	window := max(len(ar), len(br))/2 - 1
+	window = max(window, 0)
+	aMatched := make([]bool, len(ar))
+	bMatched := make([]bool, len(br))
+	matches := 0
+	for i := range ar {
+		lo, hi := max(0, i-window), min(len(br), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !bMatched[j] && ar[i] == br[j] {
+				aMatched[i], bMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range ar {
+		if !aMatched[i] {
+			continue
+		}
+		for !bMatched[j] {
+			j++
+		}
+		if ar[i] != br[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ar)) + m/float64(len(br)) + (m-float64(transpositions/2))/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ar), len(br)) && ar[prefix] == br[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// loadDuplicateCandidates - Live contacts of the user with every email and
+// phone they carry, normalized for comparison
+func loadDuplicateCandidates(user Users) ([]*duplicateCandidate, error) {
+	db := GetDB()
+	region := userPhoneRegion(user)
+
+	rows, err := db.Query("SELECT "+contactColumns+" FROM contacts WHERE user_id = ? AND deleted_at IS NULL ORDER BY contact_id", user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []*duplicateCandidate{}
+	byId := map[string]*duplicateCandidate{}
+	for rows.Next() {
+		var contact Contacts
+		if err := scanContact(rows, &contact); err != nil {
+			return nil, err
+		}
+		name := normalizeName(contact.FirstName + " " + contact.LastName)
+		candidate := &duplicateCandidate{
+			contact: contact,
+			emails:  map[string]bool{normalizeEmail(contact.Email): true},
+			phones:  map[string]bool{normalizePhoneKey(contact.Phone, region): true},
+			name:    name,
+			swapped: normalizeName(contact.LastName + " " + contact.FirstName),
+		}
+		candidates = append(candidates, candidate)
+		byId[contact.ContactId] = candidate
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, c := range []contactChannel{emailChannel, phoneChannel} {
+		rows, err := db.Query("SELECT ch.contact_id, ch."+c.ValueColumn+" FROM "+c.Table+" ch JOIN contacts c ON c.contact_id = ch.contact_id WHERE c.user_id = ? AND c.deleted_at IS NULL", user.UserId)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var contactId, value string
+			if err := rows.Scan(&contactId, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			candidate, ok := byId[contactId]
+			if !ok {
+				continue
+			}
+			if c.Noun == "email" {
+				candidate.emails[normalizeEmail(value)] = true
+			} else {
+				candidate.phones[normalizePhoneKey(value, region)] = true
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return candidates, nil
+}
+
+// findDuplicates - Candidate pairs sharing an email or phone, or with very
+// similar names. Only contacts in the same block are compared: the same
+// normalized email, the same phone, or the same two leading letters of
+// either name part.
+func findDuplicates(candidates []*duplicateCandidate, minConfidence float64) []duplicatePair {
+	type pairKey struct{ a, b int }
+	type evidence struct {
+		email, phone bool
+		name         float64
+	}
+
+	blocks := map[string][]int{}
+	for i, c := range candidates {
+		for email := range c.emails {
+			if email != "" {
+				blocks["e:"+email] = append(blocks["e:"+email], i)
+			}
+		}
+		for phone := range c.phones {
+			if len(phone) >= 6 {
+				blocks["p:"+phone] = append(blocks["p:"+phone], i)
+			}
+		}
+		for _, part := range strings.Fields(c.name) {
+			if r := []rune(part); len(r) >= 2 {
+				key := "n:" + string(r[:2])
+				if n := len(blocks[key]); n == 0 || blocks[key][n-1] != i {
+					blocks[key] = append(blocks[key], i)
+				}
+			}
+		}
+	}
+
+	found := map[pairKey]*evidence{}
+	for key, members := range blocks {
+		nameBlock := strings.HasPrefix(key, "n:")
+		if nameBlock && len(members) > duplicateMaxBlockSize {
+			continue
+		}
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				k := pairKey{members[x], members[y]}
+				if k.a == k.b {
+					continue
+				}
+				if k.a > k.b {
+					k.a, k.b = k.b, k.a
+				}
+				e := found[k]
+				if e == nil {
+					e = &evidence{}
+				}
+				switch key[0] {
+				case 'e':
+					e.email = true
+				case 'p':
+					e.phone = true
+				case 'n':
+					if e.name == 0 {
+						a, b := candidates[k.a], candidates[k.b]
+						e.name = math.Max(jaroWinkler(a.name, b.name), jaroWinkler(a.name, b.swapped))
+					}
+				}
+				if e.email || e.phone || e.name >= duplicateNameThreshold {
+					found[k] = e
+				}
+			}
+		}
+	}
+
+	pairs := []duplicatePair{}
+	for k, e := range found {
+		a, b := candidates[k.a], candidates[k.b]
+		if e.name == 0 {
+			e.name = math.Max(jaroWinkler(a.name, b.name), jaroWinkler(a.name, b.swapped))
+		}
+
+		// independent evidence combined as 1 - Π(1 - p)
+		miss := 1.0
+		reasons := []string{}
+		if e.email {
+			miss *= 1 - 0.9
+			reasons = append(reasons, "email")
+		}
+		if e.phone {
+			miss *= 1 - 0.8
+			reasons = append(reasons, "phone")
+		}
+		if e.name >= duplicateNameThreshold {
+			miss *= 1 - 0.7*e.name
+			reasons = append(reasons, "name")
+		}
+		confidence := math.Round((1-miss)*100) / 100
+		if confidence < minConfidence {
+			continue
+		}
+		pairs = append(pairs, duplicatePair{
+			ContactIds: []string{a.contact.ContactId, b.contact.ContactId},
+			Contacts:   []Contacts{a.contact, b.contact},
+			Confidence: confidence,
+			Reasons:    reasons,
+		})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Confidence != pairs[j].Confidence {
+			return pairs[i].Confidence > pairs[j].Confidence
+		}
+		ai, _ := strconv.ParseInt(pairs[i].ContactIds[0], 10, 64)
+		aj, _ := strconv.ParseInt(pairs[j].ContactIds[0], 10, 64)
+		if ai != aj {
+			return ai < aj
+		}
+		bi, _ := strconv.ParseInt(pairs[i].ContactIds[1], 10, 64)
+		bj, _ := strconv.ParseInt(pairs[j].ContactIds[1], 10, 64)
+		return bi < bj
+	})
+	return pairs
+}
+
+// GetContactDuplicates - Likely duplicate pairs, most confident first
+func GetContactDuplicates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	values := r.URL.Query()
+	errMsgs := []string{}
+
+	minConfidence := duplicateDefaultMinConfidence
+	if v := values.Get("min_confidence"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			errMsgs = append(errMsgs, "min_confidence must be between 0 and 1")
+		}
+		minConfidence = f
+	}
+	limit := duplicateDefaultLimit
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > duplicateMaxLimit {
+			errMsgs = append(errMsgs, fmt.Sprintf("limit must be between 1 and %d", duplicateMaxLimit))
+		}
+		limit = n
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	candidates, err := loadDuplicateCandidates(ctxUser)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	pairs := findDuplicates(candidates, minConfidence)
+	total := len(pairs)
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    pairs,
+		"total":   total,
+	})
+}
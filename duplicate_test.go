@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	// the examples from Winkler's paper
+	for _, tc := range []struct {
+		a, b string
+		want float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		{"budi", "budi", 1},
+		{"budi", "", 0},
+		{"abc", "xyz", 0},
+	} {
+		if got := jaroWinkler(tc.a, tc.b); math.Abs(got-tc.want) > 0.001 {
+			t.Errorf("jaroWinkler(%q, %q) = %.3f, want %.3f", tc.a, tc.b, got, tc.want)
+		}
+		if got, back := jaroWinkler(tc.a, tc.b), jaroWinkler(tc.b, tc.a); math.Abs(got-back) > 1e-9 {
+			t.Errorf("jaroWinkler(%q, %q) isn't symmetric: %f and %f", tc.a, tc.b, got, back)
+		}
+	}
+}
+
+func testCandidate(id, first, last, email, phone string) *duplicateCandidate {
+	return &duplicateCandidate{
+		contact: Contacts{ContactId: id, FirstName: first, LastName: last, Email: email, Phone: phone},
+		emails:  map[string]bool{normalizeEmail(email): true},
+		phones:  map[string]bool{normalizePhoneKey(phone, "ID"): true},
+		name:    normalizeName(first + " " + last),
+		swapped: normalizeName(last + " " + first),
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	candidates := []*duplicateCandidate{
+		testCandidate("1", "Budi", "Santoso", "budi@example.com", "+6281234567801"),
+		// a typo in the last name, nothing else shared
+		testCandidate("2", "Budi", "Santosa", "budi.s@example.com", "+6281234567802"),
+		// the same gmail address written differently, and the same number
+		testCandidate("3", "Siti", "Aminah", "siti.aminah@gmail.com", "0812-3456-7803"),
+		testCandidate("4", "S.", "Aminah", "SitiAminah+work@gmail.com", "+62 812 3456 7803"),
+		// first and last name swapped
+		testCandidate("5", "Wijaya", "Andi", "andi@example.com", "+6281234567805"),
+		testCandidate("6", "Andi", "Wijaya", "andi.w@example.com", "+6281234567806"),
+		// similar start, but too far apart to be a candidate
+		testCandidate("7", "Bunga", "Lestari", "bunga@example.com", "+6281234567807"),
+	}
+
+	type result struct {
+		ids     []string
+		reasons []string
+	}
+	pairs := findDuplicates(candidates, 0)
+	got := []result{}
+	for _, pair := range pairs {
+		got = append(got, result{pair.ContactIds, pair.Reasons})
+		if pair.Confidence <= 0 || pair.Confidence > 1 {
+			t.Errorf("pair %v: confidence %f out of range", pair.ContactIds, pair.Confidence)
+		}
+	}
+	want := []result{
+		{[]string{"3", "4"}, []string{"email", "phone"}},
+		{[]string{"5", "6"}, []string{"name"}},
+		{[]string{"1", "2"}, []string{"name"}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got pairs %v, want %v", got, want)
+	}
+	for i := range want {
+		if !slices.Equal(got[i].ids, want[i].ids) || !slices.Equal(got[i].reasons, want[i].reasons) {
+			t.Errorf("pair %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if pairs[1].Confidence != 0.7 {
+		t.Errorf("swapped names: got confidence %.2f, want 0.7", pairs[1].Confidence)
+	}
+
+	// a name match alone scores 0.7 at most, a shared email and phone more
+	strong := findDuplicates(candidates, 0.75)
+	if len(strong) != 1 || !slices.Equal(strong[0].ContactIds, []string{"3", "4"}) || strong[0].Confidence != 0.98 {
+		t.Errorf("min_confidence 0.75: got %d pairs, want only 3 and 4 at 0.98", len(strong))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// contactETag - Strong ETag of a single contact, derived from its version column
+func contactETag(contact Contacts) string {
+	return fmt.Sprintf("\"c%s-%d\"", contact.ContactId, contact.Version)
+}
+
+// addressETag - Strong ETag of a single address, derived from its version column
+func addressETag(address Addresses) string {
+	return fmt.Sprintf("\"a%s-%d\"", address.AddressId, address.Version)
+}
+
+// combinedETag - ETag for a representation made of several versioned parts
+// (a contact with embedded addresses, an address listing)
+func combinedETag(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, ",")))
+	return fmt.Sprintf("\"%x\"", sum[:10])
+}
+
+// etagListMatches - Compare against an If-Match / If-None-Match header value.
+// Weak comparison ignores the W/ prefix, as required for If-None-Match.
+func etagListMatches(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchFails - True when the request carries If-Match and none of its tags
+// match the current ETag, i.e. the client is editing a stale copy
+func ifMatchFails(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	return header != "" && !etagListMatches(header, etag, false)
+}
+
+// ifMatchVersion - The version a conditional write must still find, or nil
+// when the request is unconditional
+func ifMatchVersion(r *http.Request, version int64) *int64 {
+	if r.Header.Get("If-Match") == "" {
+		return nil
+	}
+	return &version
+}
+
+// notModified - Set the ETag header and answer 304 if If-None-Match matches
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	header := r.Header.Get("If-None-Match")
+	if header != "" && etagListMatches(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func writePreconditionFailed(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Precondition Failed",
+	})
+}
+
+// writeStaleOrMissing - Response for a conditional write that matched no row:
+// with If-Match the row moved to another version, otherwise it's gone
+func writeStaleOrMissing(w http.ResponseWriter, r *http.Request, message string) {
+	if r.Header.Get("If-Match") != "" {
+		writePreconditionFailed(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": message,
+	})
+}
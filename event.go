@@ -0,0 +1,647 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image has no zoneinfo
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultTimezone = "Asia/Jakarta"
+
+	upcomingDefaultDays = 30
+	upcomingMaxDays     = 366
+)
+
+// ContactEvents - A yearly date of a contact. Year is unknown for e.g. a
+// birthday given without the birth year.
+type ContactEvents struct {
+	EventId   string  `json:"event_id"`
+	ContactId string  `json:"contact_id"`
+	Type      string  `json:"type"`
+	Label     string  `json:"label"`
+	Year      *int    `json:"year"`
+	Month     int     `json:"month"`
+	Day       int     `json:"day"`
+	CreatedAt *string `json:"created_at,omitempty"`
+	UpdatedAt *string `json:"updated_at,omitempty"`
+}
+
+// UpcomingEvent - The next occurrence of an event
+type UpcomingEvent struct {
+	ContactEvents
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Date      string `json:"date"`
+	DaysUntil int    `json:"days_until"`
+	// age on a birthday, years married on an anniversary; only when the year is known
+	Years *int `json:"years,omitempty"`
+}
+
+type contactEventRequest struct {
+	Type  string `json:"type" validate:"required,oneof=birthday anniversary custom"`
+	Label string `json:"label" validate:"required_if=Type custom,max=100"`
+	Year  *int   `json:"year" validate:"omitempty,min=1800,max=9999"`
+	Month int    `json:"month" validate:"required,min=1,max=12"`
+	Day   int    `json:"day" validate:"required,min=1,max=31"`
+}
+
+const contactEventColumns = "e.event_id, e.contact_id, e.type, e.label, e.year, e.month, e.day, e.created_at, e.updated_at"
+
+func scanContactEvent(row rowScanner, event *ContactEvents) error {
+	return row.Scan(&event.EventId, &event.ContactId, &event.Type, &event.Label, &event.Year, &event.Month, &event.Day, &event.CreatedAt, &event.UpdatedAt)
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
+
+// validDate - Whether month/day exists, in the given year when known.
+// Feb 29 without a year is allowed.
+func validDate(year *int, month, day int) bool {
+	y := 2000 // a leap year
+	if year != nil {
+		y = *year
+	}
+	t := time.Date(y, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	return t.Month() == time.Month(month) && t.Day() == day
+}
+
+// eventDateIn - The date an event falls on in a given year. Feb 29 events
+// fall on Feb 28 in non-leap years.
+func eventDateIn(year, month, day int) time.Time {
+	if month == 2 && day == 29 && !isLeapYear(year) {
+		day = 28
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+}
+
+// nextEventDate - First occurrence on or after today
+func nextEventDate(event ContactEvents, today time.Time) time.Time {
+	date := eventDateIn(today.Year(), event.Month, event.Day)
+	if date.Before(today) {
+		date = eventDateIn(today.Year()+1, event.Month, event.Day)
+	}
+	return date
+}
+
+// upcomingEvents - Occurrences within days of today (inclusive), soonest first
+func upcomingEvents(events []UpcomingEvent, today time.Time, days int) []UpcomingEvent {
+	until := today.AddDate(0, 0, days)
+	result := []UpcomingEvent{}
+	for _, event := range events {
+		date := nextEventDate(event.ContactEvents, today)
+		if date.After(until) {
+			continue
+		}
+		event.Date = date.Format("2006-01-02")
+		event.DaysUntil = int(date.Sub(today).Hours() / 24)
+		if event.Year != nil && *event.Year <= date.Year() {
+			years := date.Year() - *event.Year
+			event.Years = &years
+		}
+		result = append(result, event)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Date != result[j].Date {
+			return result[i].Date < result[j].Date
+		}
+		return result[i].FirstName+" "+result[i].LastName < result[j].FirstName+" "+result[j].LastName
+	})
+	return result
+}
+
+// userLocation - The user's timezone, falling back to the default
+func userLocation(user Users) *time.Location {
+	if loc, err := time.LoadLocation(user.Timezone); err == nil && user.Timezone != "" {
+		return loc
+	}
+	loc, _ := time.LoadLocation(defaultTimezone)
+	return loc
+}
+
+// validTimezone - An IANA zone name such as Asia/Jakarta
+func validTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// loadEventsByContact - Events of each contact, for include=events
+func loadEventsByContact(contactIds []string) (map[string][]ContactEvents, error) {
+	result := map[string][]ContactEvents{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	clause, args := inClause(contactIds)
+	rows, err := GetDB().Query("SELECT "+contactEventColumns+" FROM contact_events e WHERE e.contact_id IN "+clause+" ORDER BY e.month, e.day, e.event_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event ContactEvents
+		if err := scanContactEvent(rows, &event); err != nil {
+			return nil, err
+		}
+		result[event.ContactId] = append(result[event.ContactId], event)
+	}
+	return result, rows.Err()
+}
+
+// loadUserEvents - Every event of the user's live contacts with the contact's name
+func loadUserEvents(userId int64) ([]UpcomingEvent, error) {
+	rows, err := GetDB().Query("SELECT "+contactEventColumns+", c.first_name, c.last_name FROM contact_events e JOIN contacts c ON c.contact_id = e.contact_id WHERE c.user_id = ? AND c.deleted_at IS NULL ORDER BY e.event_id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []UpcomingEvent{}
+	for rows.Next() {
+		var event UpcomingEvent
+		e := &event.ContactEvents
+		if err := rows.Scan(&e.EventId, &e.ContactId, &e.Type, &e.Label, &e.Year, &e.Month, &e.Day, &e.CreatedAt, &e.UpdatedAt, &event.FirstName, &event.LastName); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func findContactEvent(exec sqlExecutor, eventId string, contactId string, userId int64) (ContactEvents, error) {
+	var event ContactEvents
+	err := scanContactEvent(exec.QueryRow("SELECT "+contactEventColumns+" FROM contact_events e JOIN contacts c ON c.contact_id = e.contact_id WHERE e.event_id = ? AND e.contact_id = ? AND c.user_id = ? AND c.deleted_at IS NULL", eventId, contactId, userId), &event)
+	return event, err
+}
+
+// readContactEventRequest - Decode and validate an event, writing the error
+// response itself
+func readContactEventRequest(w http.ResponseWriter, r *http.Request) (contactEventRequest, bool) {
+	var req contactEventRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return req, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return req, false
+	}
+	if !validDate(req.Year, req.Month, req.Day) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"day does not exist in that month"},
+		})
+		return req, false
+	}
+	if req.Type != "custom" && req.Label == "" {
+		req.Label = req.Type
+	}
+	return req, true
+}
+
+// birthdayTaken - Whether the contact already has a birthday other than exceptId
+func birthdayTaken(exec sqlExecutor, contactId string, exceptId string) bool {
+	var count int
+	_ = exec.QueryRow("SELECT COUNT(*) FROM contact_events WHERE contact_id = ? AND type = 'birthday' AND event_id <> ?", contactId, exceptId).Scan(&count)
+	return count > 0
+}
+
+func GetContactEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	byContact, err := loadEventsByContact([]string{ps.ByName("id")})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	events := byContact[ps.ByName("id")]
+	if events == nil {
+		events = []ContactEvents{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    events,
+	})
+}
+
+func CreateContactEvent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readContactEventRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	if req.Type == "birthday" && birthdayTaken(db, ps.ByName("id"), "0") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact already has a birthday",
+		})
+		return
+	}
+
+	result, err := db.Exec("INSERT INTO contact_events (contact_id, type, label, year, month, day) VALUES (?, ?, ?, ?, ?, ?)", ps.ByName("id"), req.Type, req.Label, req.Year, req.Month, req.Day)
+	var event ContactEvents
+	if err == nil {
+		id, _ := result.LastInsertId()
+		event, err = findContactEvent(db, strconv.FormatInt(id, 10), ps.ByName("id"), ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Event created successfully",
+		"data":    event,
+	})
+}
+
+func GetContactEventId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	event, err := findContactEvent(GetDB(), ps.ByName("eventId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Event not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    event,
+	})
+}
+
+func UpdateContactEvent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readContactEventRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := findContactEvent(db, ps.ByName("eventId"), ps.ByName("id"), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Event not found",
+		})
+		return
+	}
+
+	if req.Type == "birthday" && birthdayTaken(db, ps.ByName("id"), ps.ByName("eventId")) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact already has a birthday",
+		})
+		return
+	}
+
+	_, err := db.Exec("UPDATE contact_events SET type = ?, label = ?, year = ?, month = ?, day = ? WHERE event_id = ?", req.Type, req.Label, req.Year, req.Month, req.Day, ps.ByName("eventId"))
+	var event ContactEvents
+	if err == nil {
+		event, err = findContactEvent(db, ps.ByName("eventId"), ps.ByName("id"), ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Event updated successfully",
+		"data":    event,
+	})
+}
+
+func DeleteContactEvent(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := findContactEvent(db, ps.ByName("eventId"), ps.ByName("id"), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Event not found",
+		})
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM contact_events WHERE event_id = ?", ps.ByName("eventId")); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Event deleted successfully",
+	})
+}
+
+// GetUpcomingEvents - GET /contact/upcoming?days=30. "Today" is the
+// current date in the user's timezone.
+func GetUpcomingEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	values := r.URL.Query()
+	errMsgs := []string{}
+
+	days := upcomingDefaultDays
+	if v := values.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > upcomingMaxDays {
+			errMsgs = append(errMsgs, fmt.Sprintf("days must be between 0 and %d", upcomingMaxDays))
+		}
+		days = n
+	}
+	eventType := values.Get("type")
+	if eventType != "" && eventType != "birthday" && eventType != "anniversary" && eventType != "custom" {
+		errMsgs = append(errMsgs, "type must be one of birthday, anniversary, custom")
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	events, err := loadUserEvents(ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if eventType != "" {
+		filtered := events[:0]
+		for _, event := range events {
+			if event.Type == eventType {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+
+	loc := userLocation(ctxUser)
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":  "Success",
+		"timezone": loc.String(),
+		"today":    today.Format("2006-01-02"),
+		"data":     upcomingEvents(events, today, days),
+	})
+}
+
+// writeEventCalendar - Serialize events as an iCalendar (RFC 5545) feed of
+// yearly all-day events
+func writeEventCalendar(w http.ResponseWriter, events []UpcomingEvent) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Contact Management API//EN",
+		"CALSCALE:GREGORIAN",
+		"X-WR-CALNAME:Contacts",
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, event := range events {
+		name := strings.TrimSpace(event.FirstName + " " + event.LastName)
+		summary := name + " - " + event.Label
+		switch event.Type {
+		case "birthday":
+			summary = "Ulang tahun " + name
+		case "anniversary":
+			summary = "Anniversary " + name
+		}
+
+		// year-less events start in 2000, a leap year, so Feb 29 is valid
+		year := 2000
+		if event.Year != nil {
+			year = *event.Year
+		}
+		start := time.Date(year, time.Month(event.Month), event.Day, 0, 0, 0, 0, time.UTC)
+		rule := "RRULE:FREQ=YEARLY"
+		if event.Month == 2 && event.Day == 29 {
+			// last day of February: the 29th in leap years, the 28th otherwise
+			rule = "RRULE:FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1"
+		}
+
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:contact-event-"+event.EventId+"@contact-management",
+			"DTSTAMP:"+stamp,
+			"DTSTART;VALUE=DATE:"+start.Format("20060102"),
+			"DTEND;VALUE=DATE:"+start.AddDate(0, 0, 1).Format("20060102"),
+			rule,
+			"SUMMARY:"+vcardEscape(summary),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT",
+		)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if err := vcardFoldLine(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// calendarFeedURL - Absolute URL of the feed for a token
+func calendarFeedURL(r *http.Request, token string) string {
+	return appBaseURL(r) + "/calendar/" + token + ".ics"
+}
+
+// appBaseURL - APP_BASE_URL, or the scheme and host the request came in on
+func appBaseURL(r *http.Request) string {
+	base := strings.TrimRight(getEnv("APP_BASE_URL", ""), "/")
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base
+}
+
+// CreateCalendarToken - Generate the secret token of the user's iCalendar
+// feed, replacing any previous one. The token is only returned here.
+func CreateCalendarToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	token := hex.EncodeToString(secret)
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := GetDB().Exec("UPDATE users SET calendar_token_hash = ? WHERE user_id = ?", hashAppPassword(token), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Calendar token created successfully",
+		"token":   token,
+		"url":     calendarFeedURL(r, token),
+	})
+}
+
+// DeleteCalendarToken - Revoke the feed URL
+func DeleteCalendarToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := GetDB().Exec("UPDATE users SET calendar_token_hash = NULL WHERE user_id = ?", ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Calendar token revoked successfully",
+	})
+}
+
+// CalendarFeed - GET /calendar/:token.ics, authenticated by the token alone
+// so calendar apps can subscribe to it
+func CalendarFeed(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	token := strings.TrimSuffix(ps.ByName("token"), ".ics")
+
+	var userId int64
+	err := GetDB().QueryRow("SELECT user_id FROM users WHERE calendar_token_hash = ?", hashAppPassword(token)).Scan(&userId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Calendar not found",
+		})
+		return
+	}
+
+	events, err := loadUserEvents(userId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.WriteHeader(200)
+	writeEventCalendar(w, events)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestContactETagCoversEvents(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "events@example.com")
+	contactId := createTestContact(t, user, "Budi")
+
+	router := httprouter.New()
+	router.GET("/contact/:id", AuthMiddleware(GetContactId))
+	router.POST("/contact/:id/events", AuthMiddleware(CreateContactEvent))
+	router.PUT("/contact/:id/events/:eventId", AuthMiddleware(UpdateContactEvent))
+	router.DELETE("/contact/:id/events/:eventId", AuthMiddleware(DeleteContactEvent))
+	path := "/contact/" + contactId + "?include=events"
+
+	var eventId string
+	checkETagChanges(t, router, user, path, func() {
+		code, body := serveJSON(t, router, "POST", "/contact/"+contactId+"/events", user, `{"type": "birthday", "month": 8, "day": 17}`)
+		if code != http.StatusCreated {
+			t.Fatalf("create event: got %d %v", code, body)
+		}
+		eventId = body["data"].(map[string]any)["event_id"].(string)
+	})
+	checkETagChanges(t, router, user, path, func() {
+		if code, body := serveJSON(t, router, "PUT", "/contact/"+contactId+"/events/"+eventId, user, `{"type": "birthday", "year": 1990, "month": 8, "day": 17}`); code != http.StatusOK {
+			t.Fatalf("update event: got %d %v", code, body)
+		}
+	})
+	checkETagChanges(t, router, user, path, func() {
+		if code, body := serveJSON(t, router, "DELETE", "/contact/"+contactId+"/events/"+eventId, user, ""); code != http.StatusOK {
+			t.Fatalf("delete event: got %d %v", code, body)
+		}
+	})
+}
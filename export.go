@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const exportPageSize = 500
+
+var exportContactHeader = []string{"contact_id", "first_name", "last_name", "email", "phone", "created_at", "updated_at"}
+var exportAddressHeader = []string{"address_id", "street", "city", "province", "country", "postal_code"}
+
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+// contactExportWriter - One output format of GET /contact/export. write is
+// called once per page with the page's addresses when they were requested.
+type contactExportWriter interface {
+	begin() error
+	write(contacts []Contacts, addresses map[string][]Addresses) error
+	end() error
+}
+
+type csvContactExport struct {
+	out           *csv.Writer
+	withAddresses bool
+}
+
+func (e *csvContactExport) begin() error {
+	header := append([]string{}, exportContactHeader...)
+	if e.withAddresses {
+		header = append(header, exportAddressHeader...)
+	}
+	return e.out.Write(header)
+}
+
+// write - With addresses there is one line per address; contacts without
+// any address still get a line with the address columns left empty.
+func (e *csvContactExport) write(contacts []Contacts, addresses map[string][]Addresses) error {
+	for _, c := range contacts {
+		record := []string{c.ContactId, c.FirstName, c.LastName, c.Email, c.Phone, stringValue(c.CreatedAt), stringValue(c.UpdatedAt)}
+		if !e.withAddresses {
+			if err := e.out.Write(record); err != nil {
+				return err
+			}
+			continue
+		}
+
+		list := addresses[c.ContactId]
+		if len(list) == 0 {
+			list = []Addresses{{}}
+		}
+		for _, a := range list {
+			line := append(append([]string{}, record...), a.AddressId, a.Street, a.City, a.Province, a.Country, a.PostalCode)
+			if err := e.out.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	e.out.Flush()
+	return e.out.Error()
+}
+
+func (e *csvContactExport) end() error {
+	e.out.Flush()
+	return e.out.Error()
+}
+
+type jsonlContactExport struct {
+	out           *json.Encoder
+	withAddresses bool
+}
+
+func (e *jsonlContactExport) begin() error {
+	return nil
+}
+
+func (e *jsonlContactExport) write(contacts []Contacts, addresses map[string][]Addresses) error {
+	for _, c := range contacts {
+		var item any = c
+		if e.withAddresses {
+			list := addresses[c.ContactId]
+			if list == nil {
+				list = []Addresses{}
+			}
+			item = struct {
+				Contacts
+				Addresses []Addresses `json:"addresses"`
+			}{c, list}
+		}
+		if err := e.out.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *jsonlContactExport) end() error {
+	return nil
+}
+
+// newContactExportWriter - Writer for the requested format, with its content
+// type and file extension; nil if the format is unknown
+func newContactExportWriter(format string, w io.Writer, withAddresses bool, vcardVersion string) (contactExportWriter, string, string) {
+	switch format {
+	case "csv":
+		return &csvContactExport{out: csv.NewWriter(w), withAddresses: withAddresses}, "text/csv; charset=utf-8", "csv"
+	case "jsonl":
+		return &jsonlContactExport{out: json.NewEncoder(w), withAddresses: withAddresses}, "application/x-ndjson", "jsonl"
+	case "vcf":
+		return &vcfContactExport{out: w, version: vcardVersion}, "text/vcard; charset=utf-8", "vcf"
+	}
+	return nil, "", ""
+}
+
+func ExportContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	listQuery, errMsgs := parseContactListQuery(r, ctxUser.UserId)
+	if errMsgs == nil {
+		errMsgs = []string{}
+	}
+
+	withAddresses := false
+	switch r.URL.Query().Get("include") {
+	case "":
+	case "addresses":
+		withAddresses = true
+	default:
+		errMsgs = append(errMsgs, "include must be addresses")
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	version, ok := vcardVersion(r)
+	if !ok {
+		errMsgs = append(errMsgs, "version must be 3.0 or 4.0")
+	}
+	// a vCard always carries its ADR properties
+	if format == "vcf" {
+		withAddresses = true
+	}
+	exporter, contentType, extension := newContactExportWriter(format, w, withAddresses, version)
+	if exporter == nil {
+		errMsgs = append(errMsgs, "format must be csv, jsonl or vcf")
+	}
+
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+
+	err := forEachContactPage(listQuery, exportPageSize, func(contacts []Contacts) error {
+		var addresses map[string][]Addresses
+		if withAddresses {
+			ids := make([]string, len(contacts))
+			for i, c := range contacts {
+				ids[i] = c.ContactId
+			}
+			var err error
+			if addresses, err = loadAddressesByContact(ids); err != nil {
+				return err
+			}
+		}
+
+		if !started {
+			started = true
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="contacts.`+extension+`"`)
+			w.WriteHeader(200)
+			if err := exporter.begin(); err != nil {
+				return err
+			}
+		}
+		if err := exporter.write(contacts, addresses); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Printf("Export failed: %v", err)
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		// the 200 is already on the wire; abort the connection so the
+		// client doesn't mistake a truncated export for a complete one
+		panic(http.ErrAbortHandler)
+	}
+
+	if !started {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="contacts.`+extension+`"`)
+		w.WriteHeader(200)
+		exporter.begin()
+	}
+	exporter.end()
+}
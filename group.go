@@ -0,0 +1,787 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+type Groups struct {
+	GroupId     int64   `json:"group_id"`
+	Name        string  `json:"name" validate:"required,max=100"`
+	Description string  `json:"description" validate:"max=500"`
+	MemberCount *int64  `json:"member_count,omitempty"`
+	CreatedAt   *string `json:"created_at,omitempty"`
+	UpdatedAt   *string `json:"updated_at,omitempty"`
+}
+
+type groupMember struct {
+	Position int64 `json:"position"`
+	Contacts
+}
+
+type groupMembersRequest struct {
+	ContactIds []string `json:"contact_ids" validate:"required,max=1000,dive,required"`
+}
+
+// groupMemberCount counts live members only; trashed contacts keep their
+// membership and come back with it when restored
+const groupMemberCount = "(SELECT COUNT(*) FROM contact_group_members m JOIN contacts c ON c.contact_id = m.contact_id WHERE m.group_id = g.group_id AND c.deleted_at IS NULL)"
+
+func findGroup(groupId string, userId int64) (Groups, error) {
+	var group Groups
+	err := GetDB().QueryRow("SELECT g.group_id, g.name, g.description, g.created_at, g.updated_at, "+groupMemberCount+" FROM contact_groups g WHERE g.group_id = ? AND g.user_id = ?", groupId, userId).Scan(&group.GroupId, &group.Name, &group.Description, &group.CreatedAt, &group.UpdatedAt, &group.MemberCount)
+	return group, err
+}
+
+// loadGroupMembers - One page of a group's live members in group order,
+// starting after the (position, contact_id) keyset position
+func loadGroupMembers(groupId int64, after []string, limit int) ([]groupMember, error) {
+	query := "SELECT m.position, " + prefixedContactColumns("c") + " FROM contact_group_members m JOIN contacts c ON c.contact_id = m.contact_id WHERE m.group_id = ? AND c.deleted_at IS NULL"
+	args := []any{groupId}
+	if after != nil {
+		query += " AND (m.position > ? OR (m.position = ? AND c.contact_id > ?))"
+		args = append(args, after[0], after[0], after[1])
+	}
+	query += " ORDER BY m.position, c.contact_id LIMIT " + strconv.Itoa(limit)
+
+	rows, err := GetDB().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []groupMember{}
+	for rows.Next() {
+		var member groupMember
+		if err := rows.Scan(append([]any{&member.Position}, contactScanDest(&member.Contacts)...)...); err != nil {
+			return nil, err
+		}
+		member.fillPhoneDisplay()
+		member.fillPhoto()
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func prefixedContactColumns(alias string) string {
+	columns := strings.Split(contactColumns, ", ")
+	for i, column := range columns {
+		columns[i] = alias + "." + column
+	}
+	return strings.Join(columns, ", ")
+}
+
+func groupCursorSort(groupId int64) string {
+	return fmt.Sprintf("group:%d", groupId)
+}
+
+// ownedLiveContacts - The subset of ids that are live contacts of the user
+func ownedLiveContacts(exec sqlExecutor, userId int64, ids []string) (map[string]bool, error) {
+	owned := map[string]bool{}
+	if len(ids) == 0 {
+		return owned, nil
+	}
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	args := []any{userId}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := exec.Query("SELECT contact_id FROM contacts WHERE user_id = ? AND deleted_at IS NULL AND contact_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		owned[id] = true
+	}
+	return owned, rows.Err()
+}
+
+func CreateGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var group Groups
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	group.Name = strings.TrimSpace(group.Name)
+
+	validate := validator.New()
+	if err := validate.Struct(group); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	result, err := db.Exec("INSERT INTO contact_groups (user_id, name, description) VALUES (?, ?, ?)", ctxUser.UserId, group.Name, group.Description)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group already exists",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	group.GroupId, _ = result.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Group created successfully",
+		"data":    group,
+	})
+}
+
+func GetGroups(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rows, err := db.Query("SELECT g.group_id, g.name, g.description, g.created_at, g.updated_at, "+groupMemberCount+" FROM contact_groups g WHERE g.user_id = ? ORDER BY g.name", ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	groups := []Groups{}
+	for rows.Next() {
+		var group Groups
+		if err := rows.Scan(&group.GroupId, &group.Name, &group.Description, &group.CreatedAt, &group.UpdatedAt, &group.MemberCount); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		groups = append(groups, group)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    groups,
+	})
+}
+
+func GetGroupId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	group, err := findGroup(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    group,
+	})
+}
+
+func UpdateGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var group Groups
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	group.Name = strings.TrimSpace(group.Name)
+
+	validate := validator.New()
+	if err := validate.Struct(group); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := findGroup(ps.ByName("id"), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	_, err := db.Exec("UPDATE contact_groups SET name = ?, description = ? WHERE group_id = ? AND user_id = ?", group.Name, group.Description, ps.ByName("id"), ctxUser.UserId)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group already exists",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	group, _ = findGroup(ps.ByName("id"), ctxUser.UserId)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Group updated successfully",
+		"data":    group,
+	})
+}
+
+// DeleteGroup - Remove a group and its memberships; the contacts stay
+func DeleteGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM contact_groups WHERE group_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId)
+	var deleted int64
+	if err == nil {
+		deleted, _ = result.RowsAffected()
+		if deleted > 0 {
+			_, err = tx.Exec("DELETE FROM contact_group_members WHERE group_id = ?", ps.ByName("id"))
+		}
+	}
+	if err == nil && deleted > 0 {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if deleted == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Group deleted successfully",
+	})
+}
+
+// readGroupMembersRequest - Decode and validate {"contact_ids": [...]},
+// writing the 400 response itself
+func readGroupMembersRequest(w http.ResponseWriter, r *http.Request) (groupMembersRequest, bool) {
+	var req groupMembersRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return req, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return req, false
+	}
+	return req, true
+}
+
+// AddGroupMembers - Append contacts to the end of the group in the given
+// order. Contacts already in the group keep their position.
+func AddGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readGroupMembersRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	group, err := findGroup(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	owned, err := ownedLiveContacts(db, ctxUser.UserId, req.ContactIds)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	missing := []string{}
+	for _, id := range req.ContactIds {
+		if !owned[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message":     "Contact not found",
+			"contact_ids": missing,
+		})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	// lock the group row so concurrent adds don't hand out the same positions
+	var locked, position int64
+	err = tx.QueryRow("SELECT group_id FROM contact_groups WHERE group_id = ? FOR UPDATE", group.GroupId).Scan(&locked)
+	if err == nil {
+		err = tx.QueryRow("SELECT COALESCE(MAX(position), 0) FROM contact_group_members WHERE group_id = ?", group.GroupId).Scan(&position)
+	}
+
+	added := 0
+	seen := map[string]bool{}
+	for _, id := range req.ContactIds {
+		if err != nil {
+			break
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result, execErr := tx.Exec("INSERT IGNORE INTO contact_group_members (group_id, contact_id, position) VALUES (?, ?, ?)", group.GroupId, id, position+1)
+		if execErr != nil {
+			err = execErr
+			break
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			position++
+			added++
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Adding group members failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Group members added successfully",
+		"added":   added,
+		"skipped": len(req.ContactIds) - added,
+	})
+}
+
+// SetGroupMembers - Replace the membership with exactly these contacts, in
+// this order
+func SetGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readGroupMembersRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	group, err := findGroup(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	owned, err := ownedLiveContacts(db, ctxUser.UserId, req.ContactIds)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	missing := []string{}
+	for _, id := range req.ContactIds {
+		if !owned[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message":     "Contact not found",
+			"contact_ids": missing,
+		})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM contact_group_members WHERE group_id = ?", group.GroupId)
+	position := int64(0)
+	seen := map[string]bool{}
+	for _, id := range req.ContactIds {
+		if err != nil {
+			break
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		position++
+		_, err = tx.Exec("INSERT INTO contact_group_members (group_id, contact_id, position) VALUES (?, ?, ?)", group.GroupId, id, position)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Setting group members failed: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Group members updated successfully",
+		"members": position,
+	})
+}
+
+func RemoveGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readGroupMembersRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	group, err := findGroup(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	placeholders := strings.Repeat("?, ", len(req.ContactIds)-1) + "?"
+	args := []any{group.GroupId}
+	for _, id := range req.ContactIds {
+		args = append(args, id)
+	}
+	result, err := db.Exec("DELETE FROM contact_group_members WHERE group_id = ? AND contact_id IN ("+placeholders+")", args...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	removed, _ := result.RowsAffected()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Group members removed successfully",
+		"removed": removed,
+	})
+}
+
+// GetGroupMembers - Members in group order, paginated with limit and cursor
+func GetGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	group, err := findGroup(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	values := r.URL.Query()
+	errMsgs := []string{}
+	limit := contactListDefaultLimit
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > contactListMaxLimit {
+			errMsgs = append(errMsgs, fmt.Sprintf("limit must be between 1 and %d", contactListMaxLimit))
+		}
+		limit = n
+	}
+	var after []string
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeContactCursor(v)
+		if err != nil || cursor.Sort != groupCursorSort(group.GroupId) || len(cursor.Values) != 2 {
+			errMsgs = append(errMsgs, "cursor is invalid for this group")
+		} else {
+			after = cursor.Values
+		}
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	members, err := loadGroupMembers(group.GroupId, after, limit+1)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	var nextCursor *string
+	if len(members) > limit {
+		members = members[:limit]
+		last := members[len(members)-1]
+		b, _ := json.Marshal(contactCursor{Sort: groupCursorSort(group.GroupId), Values: []string{strconv.FormatInt(last.Position, 10), last.ContactId}})
+		cursor := base64.RawURLEncoding.EncodeToString(b)
+		nextCursor = &cursor
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "Success",
+		"data":        members,
+		"next_cursor": nextCursor,
+	})
+}
+
+// ExportGroup - Stream a group's members in group order, in any of the
+// contact export formats
+func ExportGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	group, err := findGroup(ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Group not found",
+		})
+		return
+	}
+
+	errMsgs := []string{}
+	withAddresses := false
+	switch r.URL.Query().Get("include") {
+	case "":
+	case "addresses":
+		withAddresses = true
+	default:
+		errMsgs = append(errMsgs, "include must be addresses")
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	version, ok := vcardVersion(r)
+	if !ok {
+		errMsgs = append(errMsgs, "version must be 3.0 or 4.0")
+	}
+	if format == "vcf" {
+		withAddresses = true
+	}
+	exporter, contentType, extension := newContactExportWriter(format, w, withAddresses, version)
+	if exporter == nil {
+		errMsgs = append(errMsgs, "format must be csv, jsonl or vcf")
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="group-%d.%s"`, group.GroupId, extension))
+	w.WriteHeader(200)
+	flusher, _ := w.(http.Flusher)
+
+	err = exporter.begin()
+	var after []string
+	for err == nil {
+		var members []groupMember
+		if members, err = loadGroupMembers(group.GroupId, after, exportPageSize); err != nil || len(members) == 0 {
+			break
+		}
+
+		contacts := make([]Contacts, len(members))
+		ids := make([]string, len(members))
+		for i, member := range members {
+			contacts[i] = member.Contacts
+			ids[i] = member.ContactId
+		}
+		var addresses map[string][]Addresses
+		if withAddresses {
+			if addresses, err = loadAddressesByContact(ids); err != nil {
+				break
+			}
+		}
+		if err = exporter.write(contacts, addresses); err != nil {
+			break
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		last := members[len(members)-1]
+		after = []string{strconv.FormatInt(last.Position, 10), last.ContactId}
+	}
+	if err == nil {
+		err = exporter.end()
+	}
+	if err != nil {
+		log.Printf("Group export failed: %v", err)
+		// see ExportContacts: abort so a truncated file isn't taken as complete
+		panic(http.ErrAbortHandler)
+	}
+}
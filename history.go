@@ -0,0 +1,411 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// historyActor - Who made a change and through which part of the API:
+// api, bulk, import, carddav, merge or revert (migration for the baseline
+// recorded when history was introduced)
+type historyActor struct {
+	UserId int64
+	Source string
+}
+
+// the state stored after every change, built by MySQL from the row itself
+// so the snapshot always matches what was written
+const (
+	contactHistorySnapshot = "JSON_OBJECT('first_name', first_name, 'last_name', last_name, 'email', email, 'phone', phone, 'phone_raw', phone_raw, 'deleted_at', deleted_at)"
+	addressHistorySnapshot = "JSON_OBJECT('street', street, 'city', city, 'province', province, 'country', country, 'postal_code', postal_code, 'deleted_at', deleted_at)"
+)
+
+// recordContactHistory - Snapshot a contact as it is now, at its current
+// version, and add the change to the sync sequence. Call it in the
+// transaction that changed the contact.
+func recordContactHistory(exec sqlExecutor, actor historyActor, action string, contactId string) error {
+	_, err := exec.Exec("INSERT INTO contact_history (contact_id, entity, entity_id, version, action, actor_id, source, snapshot) SELECT contact_id, 'contact', contact_id, version, ?, ?, ?, "+contactHistorySnapshot+" FROM contacts WHERE contact_id = ?", action, actor.UserId, actor.Source, contactId)
+	if err != nil {
+		return err
+	}
+	return recordSyncChanges(exec, actor.UserId, "contact", []string{contactId})
+}
+
+// recordAddressHistory - Snapshot the addresses matching where and add them
+// to the sync sequence
+func recordAddressHistory(exec sqlExecutor, actor historyActor, action string, where string, args ...any) error {
+	_, err := exec.Exec("INSERT INTO contact_history (contact_id, entity, entity_id, version, action, actor_id, source, snapshot) SELECT contact_id, 'address', address_id, version, ?, ?, ?, "+addressHistorySnapshot+" FROM addresses WHERE "+where, append([]any{action, actor.UserId, actor.Source}, args...)...)
+	if err != nil {
+		return err
+	}
+	ids, err := queryIds(exec, "SELECT address_id FROM addresses WHERE "+where+" ORDER BY address_id", args...)
+	if err != nil {
+		return err
+	}
+	return recordSyncChanges(exec, actor.UserId, "address", ids)
+}
+
+// recordAddressHistoryIn - recordAddressHistory for a list of address ids;
+// a no-op without ids
+func recordAddressHistoryIn(exec sqlExecutor, actor historyActor, action string, addressIds []string) error {
+	if len(addressIds) == 0 {
+		return nil
+	}
+	clause, args := inClause(addressIds)
+	return recordAddressHistory(exec, actor, action, "address_id IN "+clause, args...)
+}
+
+// ContactHistory - One change of a contact or one of its addresses, with
+// the fields that changed compared to the previous snapshot of the same
+// entity
+type ContactHistory struct {
+	HistoryId string          `json:"history_id"`
+	Entity    string          `json:"entity"`
+	EntityId  string          `json:"entity_id"`
+	Version   int64           `json:"version"`
+	Action    string          `json:"action"`
+	Actor     HistoryActor    `json:"actor"`
+	Changes   []HistoryChange `json:"changes"`
+	Snapshot  map[string]any  `json:"snapshot"`
+	CreatedAt *string         `json:"created_at,omitempty"`
+}
+
+type HistoryActor struct {
+	UserId string `json:"user_id"`
+	Name   string `json:"name"`
+	Source string `json:"source"`
+}
+
+type HistoryChange struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
+}
+
+// diffSnapshots - Fields whose value differs, by name. Every field of the
+// first snapshot of an entity counts as changed from null.
+func diffSnapshots(previous, current map[string]any) []HistoryChange {
+	names := []string{}
+	for name := range current {
+		names = append(names, name)
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []HistoryChange{}
+	for _, name := range names {
+		if previous != nil && reflect.DeepEqual(previous[name], current[name]) {
+			continue
+		}
+		if previous == nil && current[name] == nil {
+			continue
+		}
+		changes = append(changes, HistoryChange{Field: name, From: previous[name], To: current[name]})
+	}
+	return changes
+}
+
+const historyCursorSort = "-history_id"
+
+// GetContactHistory - GET /contact/:id/history, newest change first.
+// entity=contact|address narrows it to the contact or its addresses.
+func GetContactHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	values := r.URL.Query()
+	limit := contactListDefaultLimit
+	var entity, before string
+	errMsgs := []string{}
+	if v := values.Get("entity"); v != "" {
+		if v != "contact" && v != "address" {
+			errMsgs = append(errMsgs, "entity must be one of contact, address")
+		}
+		entity = v
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > contactListMaxLimit {
+			errMsgs = append(errMsgs, fmt.Sprintf("limit must be between 1 and %d", contactListMaxLimit))
+		}
+		limit = n
+	}
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeContactCursor(v)
+		if err != nil || cursor.Sort != historyCursorSort || len(cursor.Values) != 1 {
+			errMsgs = append(errMsgs, "cursor is invalid")
+		} else {
+			before = cursor.Values[0]
+		}
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	// the previous snapshot is taken over the whole history, so the first
+	// entry of a page still gets its diff
+	query := "SELECT h.history_id, h.entity, h.entity_id, h.version, h.action, h.actor_id, COALESCE(u.name, ''), h.source, h.created_at, h.snapshot, h.previous FROM (SELECT history_id, entity, entity_id, version, action, actor_id, source, created_at, snapshot, LAG(snapshot) OVER (PARTITION BY entity, entity_id ORDER BY history_id) AS previous FROM contact_history WHERE contact_id = ?) h LEFT JOIN users u ON u.user_id = h.actor_id WHERE 1 = 1"
+	args := []any{ps.ByName("id")}
+	if entity != "" {
+		query += " AND h.entity = ?"
+		args = append(args, entity)
+	}
+	if before != "" {
+		query += " AND h.history_id < ?"
+		args = append(args, before)
+	}
+	rows, err := db.Query(query+" ORDER BY h.history_id DESC LIMIT "+strconv.Itoa(limit+1), args...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	history := []ContactHistory{}
+	for rows.Next() {
+		var entry ContactHistory
+		var snapshot string
+		var previous *string
+		err := rows.Scan(&entry.HistoryId, &entry.Entity, &entry.EntityId, &entry.Version, &entry.Action, &entry.Actor.UserId, &entry.Actor.Name, &entry.Actor.Source, &entry.CreatedAt, &snapshot, &previous)
+		if err == nil {
+			err = json.Unmarshal([]byte(snapshot), &entry.Snapshot)
+		}
+		var previousSnapshot map[string]any
+		if err == nil && previous != nil {
+			err = json.Unmarshal([]byte(*previous), &previousSnapshot)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		entry.Changes = diffSnapshots(previousSnapshot, entry.Snapshot)
+		history = append(history, entry)
+	}
+
+	var nextCursor *string
+	if len(history) > limit {
+		history = history[:limit]
+		b, _ := json.Marshal(contactCursor{Sort: historyCursorSort, Values: []string{history[len(history)-1].HistoryId}})
+		cursor := base64.RawURLEncoding.EncodeToString(b)
+		nextCursor = &cursor
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "Success",
+		"data":        history,
+		"next_cursor": nextCursor,
+	})
+}
+
+// addressSnapshot - The stored state of an address
+type addressSnapshot struct {
+	Street     string  `json:"street"`
+	City       string  `json:"city"`
+	Province   string  `json:"province"`
+	Country    string  `json:"country"`
+	PostalCode string  `json:"postal_code"`
+	DeletedAt  *string `json:"deleted_at"`
+}
+
+// revertAddresses - Bring the contact's addresses back to how they were at
+// history entry atId: ones that were live get their values back (and leave
+// the trash), ones added or deleted since go to the trash. Addresses that
+// were purged or moved to another contact can't come back.
+func revertAddresses(tx *sql.Tx, actor historyActor, contactId string, atId string) error {
+	states := map[string]addressSnapshot{}
+	rows, err := tx.Query("SELECT h.entity_id, h.snapshot FROM contact_history h WHERE h.entity = 'address' AND h.contact_id = ? AND h.history_id = (SELECT MAX(p.history_id) FROM contact_history p WHERE p.entity = 'address' AND p.entity_id = h.entity_id AND p.history_id <= ?)", contactId, atId)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, data string
+		var state addressSnapshot
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return err
+		}
+		if err := json.Unmarshal([]byte(data), &state); err != nil {
+			rows.Close()
+			return err
+		}
+		states[id] = state
+	}
+	rows.Close()
+
+	// addresses that were never recorded are left alone
+	tracked, err := queryIds(tx, "SELECT DISTINCT a.address_id FROM addresses a JOIN contact_history h ON h.entity = 'address' AND h.entity_id = a.address_id WHERE a.contact_id = ?", contactId)
+	if err != nil {
+		return err
+	}
+	if len(tracked) == 0 {
+		return nil
+	}
+	clause, args := inClause(tracked)
+	current := []Addresses{}
+	rows, err = tx.Query("SELECT "+addressColumns+" FROM addresses WHERE address_id IN "+clause, args...)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var address Addresses
+		if err := scanAddress(rows, &address); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, address)
+	}
+	rows.Close()
+
+	deletedAt := softDeleteTime()
+	var updated, restored, deleted []string
+	for _, address := range current {
+		state, existed := states[address.AddressId]
+		if !existed || state.DeletedAt != nil {
+			if address.DeletedAt == nil {
+				deleted = append(deleted, address.AddressId)
+			}
+			continue
+		}
+		same := address.Street == state.Street && address.City == state.City && address.Province == state.Province && address.Country == state.Country && address.PostalCode == state.PostalCode
+		if same && address.DeletedAt == nil {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE addresses SET street = ?, city = ?, province = ?, country = ?, postal_code = ?, deleted_at = NULL, version = version + 1 WHERE address_id = ?", state.Street, state.City, state.Province, state.Country, state.PostalCode, address.AddressId); err != nil {
+			return err
+		}
+		if address.DeletedAt != nil {
+			restored = append(restored, address.AddressId)
+		} else {
+			updated = append(updated, address.AddressId)
+		}
+	}
+	if err := execIn(tx, "UPDATE addresses SET deleted_at = ?, version = version + 1 WHERE address_id IN", deleted, deletedAt); err != nil {
+		return err
+	}
+
+	if err := recordAddressHistoryIn(tx, actor, "updated", updated); err != nil {
+		return err
+	}
+	if err := recordAddressHistoryIn(tx, actor, "restored", restored); err != nil {
+		return err
+	}
+	return recordAddressHistoryIn(tx, actor, "deleted", deleted)
+}
+
+// RevertContact - POST /contact/:id/revert/:version. Restores the contact's
+// fields and addresses as they were at that version; the revert itself is a
+// new version. Accepts If-Match.
+func RevertContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	var historyId, data string
+	err := db.QueryRow("SELECT history_id, snapshot FROM contact_history WHERE entity = 'contact' AND entity_id = ? AND version = ? ORDER BY history_id DESC LIMIT 1", current.ContactId, ps.ByName("version")).Scan(&historyId, &data)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Version not found",
+		})
+		return
+	}
+	var target Contacts
+	if err := json.Unmarshal([]byte(data), &target); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	actor := historyActor{UserId: ctxUser.UserId, Source: "revert"}
+	reverted, err := updateContactRow(tx, target, current.ContactId, ctxUser.UserId, ifMatchVersion(r, current.Version), actor)
+	if err == nil && reverted {
+		err = revertAddresses(tx, actor, current.ContactId, historyId)
+	}
+	if err == nil && reverted {
+		err = scanContact(tx.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", current.ContactId), &current)
+	}
+	if err == nil && reverted {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !reverted {
+		writeStaleOrMissing(w, r, "Contact not found")
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	w.Header().Set("ETag", contactETag(current))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Contact reverted successfully",
+		"data":    current,
+	})
+}
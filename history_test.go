@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRevertContact(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "history@example.com")
+
+	router := httprouter.New()
+	router.POST("/contact", AuthMiddleware(CreateContact))
+	router.PUT("/contact/:id", AuthMiddleware(UpdateContact))
+	router.GET("/contact/:id/history", AuthMiddleware(GetContactHistory))
+	router.POST("/contact/:id/revert/:version", AuthMiddleware(RevertContact))
+	router.POST("/address/", AuthMiddleware(CreateAddress))
+
+	code, body := serveJSON(t, router, "POST", "/contact", user, `{"first_name": "Budi", "last_name": "Santoso", "email": "budi@example.com", "phone": "081234567890"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create: got %d %v", code, body)
+	}
+	contactId := fmt.Sprint(body["data"].(map[string]any)["contact_id"])
+	path := "/contact/" + contactId
+
+	// version 2 gets a new name and, after it, an address
+	if code, body := serveJSON(t, router, "PUT", path, user, `{"first_name": "Budi", "last_name": "Wijaya", "email": "budi.w@example.com", "phone": "081234567890"}`); code != http.StatusOK {
+		t.Fatalf("update: got %d %v", code, body)
+	}
+	if code, body := serveJSON(t, router, "POST", "/address/", user, `{"street": "Jl. Merdeka 1", "city": "Bandung", "province": "Jawa Barat", "country": "Indonesia", "postal_code": "40111", "contact_id": "`+contactId+`"}`); code != http.StatusCreated {
+		t.Fatalf("create address: got %d %v", code, body)
+	}
+
+	code, body = serveJSON(t, router, "POST", path+"/revert/1", user, "")
+	if code != http.StatusOK {
+		t.Fatalf("revert: got %d %v", code, body)
+	}
+
+	var contact Contacts
+	if err := scanContact(GetDB().QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", contactId), &contact); err != nil {
+		t.Fatal(err)
+	}
+	if contact.LastName != "Santoso" || contact.Email != "budi@example.com" || contact.Version != 3 {
+		t.Errorf("got %s %s at version %d, want Santoso budi@example.com at version 3", contact.LastName, contact.Email, contact.Version)
+	}
+	var live int
+	GetDB().QueryRow("SELECT COUNT(*) FROM addresses WHERE contact_id = ? AND deleted_at IS NULL", contactId).Scan(&live)
+	if live != 0 {
+		t.Errorf("got %d live addresses, want the one added after version 1 trashed", live)
+	}
+
+	code, body = serveJSON(t, router, "GET", path+"/history?entity=contact", user, "")
+	if code != http.StatusOK {
+		t.Fatalf("history: got %d %v", code, body)
+	}
+	entries := body["data"].([]any)
+	if len(entries) != 3 {
+		t.Fatalf("got %d contact history entries, want 3", len(entries))
+	}
+	latest := entries[0].(map[string]any)
+	actor := latest["actor"].(map[string]any)
+	if latest["version"] != float64(3) || latest["action"] != "updated" || actor["source"] != "revert" {
+		t.Errorf("got latest entry %v, want version 3 updated by revert", latest)
+	}
+	changes := map[string][2]any{}
+	for _, c := range latest["changes"].([]any) {
+		change := c.(map[string]any)
+		changes[change["field"].(string)] = [2]any{change["from"], change["to"]}
+	}
+	if changes["last_name"] != [2]any{"Wijaya", "Santoso"} || changes["email"] != [2]any{"budi.w@example.com", "budi@example.com"} {
+		t.Errorf("got changes %v, want last_name and email back to version 1", changes)
+	}
+	if _, ok := changes["phone"]; ok {
+		t.Errorf("the unchanged phone is listed as a change: %v", changes["phone"])
+	}
+
+	code, body = serveJSON(t, router, "GET", path+"/history?entity=address", user, "")
+	if code != http.StatusOK || len(body["data"].([]any)) != 2 {
+		t.Errorf("address history: got %d %v, want the create and the revert", code, body)
+	} else if entry := body["data"].([]any)[0].(map[string]any); entry["action"] != "deleted" {
+		t.Errorf("got latest address entry %v, want deleted", entry)
+	}
+
+	if code, body := serveJSON(t, router, "POST", path+"/revert/99", user, ""); code != http.StatusNotFound {
+		t.Errorf("revert to a missing version: got %d %v, want 404", code, body)
+	}
+	if w := serve(t, router, "POST", path+"/revert/1", user, "", http.Header{"If-Match": {`"c` + contactId + `-2"`}}); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("revert with a stale If-Match: got %d, want 412", w.Code)
+	}
+}
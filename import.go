@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	importStatusQueued    = "queued"
+	importStatusRunning   = "running"
+	importStatusCompleted = "completed"
+	importStatusFailed    = "failed"
+
+	// importMaxReportedErrors caps the per-row error list kept for one import
+	importMaxReportedErrors = 1000
+	importJobTTL            = 24 * time.Hour
+)
+
+// importFields - Contacts fields a CSV column can be mapped to
+var importFields = map[string]bool{
+	"first_name": true,
+	"last_name":  true,
+	"email":      true,
+	"phone":      true,
+}
+
+type importRow struct {
+	Line    int
+	Contact Contacts
+}
+
+type importRowError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+type importJob struct {
+	mu         sync.Mutex
+	JobId      string           `json:"job_id"`
+	UserId     int64            `json:"-"`
+	Status     string           `json:"status"`
+	DryRun     bool             `json:"dry_run"`
+	TotalRows  int              `json:"total_rows"`
+	ValidRows  int              `json:"valid_rows"`
+	Processed  int              `json:"processed"`
+	Imported   int              `json:"imported"`
+	Errors     []importRowError `json:"errors"`
+	Message    string           `json:"message,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+var (
+	importJobsMu sync.Mutex
+	importJobs   = map[string]*importJob{}
+)
+
+func importEnvInt(key string, def int) int {
+	n, err := strconv.Atoi(getEnv(key, strconv.Itoa(def)))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// parseImportMapping - Resolve the CSV header to Contacts fields. Without an
+// explicit mapping a header matches a field by name ("First Name" -> first_name).
+func parseImportMapping(header []string, raw string) (map[int]string, error) {
+	mapping := map[string]string{}
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			return nil, errors.New("mapping must be a JSON object of column name to field")
+		}
+	} else {
+		for _, column := range header {
+			mapping[column] = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(column)), " ", "_")
+		}
+	}
+
+	columns := map[int]string{}
+	mapped := map[string]bool{}
+	for i, column := range header {
+		field, ok := mapping[column]
+		if !ok || field == "" {
+			continue
+		}
+		if !importFields[field] {
+			if raw == "" {
+				continue
+			}
+			return nil, fmt.Errorf("column %q is mapped to unknown field %q", column, field)
+		}
+		if mapped[field] {
+			return nil, fmt.Errorf("field %q is mapped more than once", field)
+		}
+		mapped[field] = true
+		columns[i] = field
+	}
+
+	for column := range mapping {
+		found := false
+		for _, h := range header {
+			if h == column {
+				found = true
+				break
+			}
+		}
+		if !found && raw != "" {
+			return nil, fmt.Errorf("column %q not found in CSV header", column)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("no CSV column is mapped to a contact field")
+	}
+	return columns, nil
+}
+
+// readImportCSV - Parse and validate every row. Line numbers are the
+// physical line the record starts on, header included. A CSV can't carry
+// custom fields, so while the user has required ones every row fails.
+func readImportCSV(file io.Reader, rawMapping string, region string, fields map[string]CustomFields) ([]importRow, []importRowError, int, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, 0, errors.New("CSV header is missing or invalid")
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	columns, err := parseImportMapping(header, rawMapping)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	validate := validator.New()
+
+	rows := []importRow{}
+	rowErrors := []importRowError{}
+	total := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		total++
+		if err != nil {
+			line := 0
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				line = parseErr.StartLine
+			}
+			rowErrors = append(rowErrors, importRowError{Line: line, Errors: []string{err.Error()}})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		var contact Contacts
+		for i, value := range record {
+			switch columns[i] {
+			case "first_name":
+				contact.FirstName = strings.TrimSpace(value)
+			case "last_name":
+				contact.LastName = strings.TrimSpace(value)
+			case "email":
+				contact.Email = strings.TrimSpace(value)
+			case "phone":
+				contact.Phone = strings.TrimSpace(value)
+			}
+		}
+
+		if err := validate.Struct(contact); err != nil {
+			rowErrors = append(rowErrors, importRowError{Line: line, Errors: validationMessages(err)})
+			continue
+		}
+		if err := normalizeContactPhone(&contact, region, nil); err != nil {
+			rowErrors = append(rowErrors, importRowError{Line: line, Errors: []string{err.Error()}})
+			continue
+		}
+		if errMsgs := validateCustomFieldValues(fields, contact.CustomFields); len(errMsgs) > 0 {
+			rowErrors = append(rowErrors, importRowError{Line: line, Errors: errMsgs})
+			continue
+		}
+		rows = append(rows, importRow{Line: line, Contact: contact})
+	}
+	return rows, rowErrors, total, nil
+}
+
+// commitImport - Insert rows in batches, one transaction per batch
+func commitImport(job *importJob, rows []importRow, batchSize int) error {
+	db := GetDB()
+
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, row := range rows[start:end] {
+			if _, err := insertContact(tx, row.Contact, job.UserId, historyActor{UserId: job.UserId, Source: "import"}); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		job.mu.Lock()
+		job.Processed = end
+		job.Imported = end
+		job.mu.Unlock()
+	}
+	return nil
+}
+
+// runImport - Commit the validated rows unless this is a dry run or some
+// rows failed without skip_invalid
+func runImport(job *importJob, rows []importRow, skipInvalid bool) {
+	job.mu.Lock()
+	job.Status = importStatusRunning
+	invalid := len(job.Errors) > 0
+	job.mu.Unlock()
+
+	var err error
+	message := "Import completed"
+	switch {
+	case job.DryRun:
+		message = "Dry run completed, nothing was imported"
+	case invalid && !skipInvalid:
+		message = "Some rows are invalid, nothing was imported"
+	default:
+		err = commitImport(job, rows, importEnvInt("IMPORT_BATCH_SIZE", 500))
+		invalidateContactSuggest(job.UserId)
+	}
+
+	now := time.Now().UTC()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.FinishedAt = &now
+	job.Status = importStatusCompleted
+	job.Message = message
+	if err != nil {
+		log.Printf("Import %s failed: %v", job.JobId, err)
+		job.Status = importStatusFailed
+		job.Message = fmt.Sprintf("Import stopped after %d rows because of a database error", job.Imported)
+	}
+}
+
+func registerImportJob(job *importJob) {
+	importJobsMu.Lock()
+	defer importJobsMu.Unlock()
+	for id, old := range importJobs {
+		old.mu.Lock()
+		expired := old.FinishedAt != nil && time.Since(*old.FinishedAt) > importJobTTL
+		old.mu.Unlock()
+		if expired {
+			delete(importJobs, id)
+		}
+	}
+	importJobs[job.JobId] = job
+}
+
+func ImportContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/vcard" || mediaType == "text/x-vcard" {
+		importVCards(w, r)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(importEnvInt("IMPORT_MAX_BYTES", 20<<20)))
+	if err := r.ParseMultipartForm(8 << 20); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"file is required"},
+		})
+		return
+	}
+	defer file.Close()
+
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+	skipInvalid, _ := strconv.ParseBool(r.FormValue("skip_invalid"))
+	async, _ := strconv.ParseBool(r.FormValue("async"))
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	fields, err := loadCustomFields(GetDB(), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	rows, rowErrors, total, err := readImportCSV(file, r.FormValue("mapping"), userPhoneRegion(ctxUser), fields)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{err.Error()},
+		})
+		return
+	}
+
+	job := &importJob{
+		JobId:     uuid.New().String(),
+		UserId:    ctxUser.UserId,
+		Status:    importStatusQueued,
+		DryRun:    dryRun,
+		TotalRows: total,
+		ValidRows: len(rows),
+		Errors:    rowErrors,
+		CreatedAt: time.Now().UTC(),
+	}
+	if len(job.Errors) > importMaxReportedErrors {
+		job.Errors = job.Errors[:importMaxReportedErrors]
+	}
+
+	if async || total > importEnvInt("IMPORT_ASYNC_THRESHOLD", 1000) {
+		registerImportJob(job)
+		go runImport(job, rows, skipInvalid)
+
+		w.Header().Set("Location", "/import/"+job.JobId)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(202)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Import started",
+			"data": map[string]any{
+				"job_id":     job.JobId,
+				"status_url": "/import/" + job.JobId,
+			},
+		})
+		return
+	}
+
+	runImport(job, rows, skipInvalid)
+
+	status := 200
+	if job.Status == importStatusFailed {
+		status = 500
+	} else if len(job.Errors) > 0 && !skipInvalid && !dryRun {
+		status = 400
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": job.Message,
+		"data":    job,
+	})
+}
+
+func GetImportJob(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	importJobsMu.Lock()
+	job, ok := importJobs[ps.ByName("jobId")]
+	importJobsMu.Unlock()
+	if !ok || job.UserId != ctxUser.UserId {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Import job not found",
+		})
+		return
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    job,
+	})
+}
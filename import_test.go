@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestReadImportCSVNormalizesPhones(t *testing.T) {
+	csv := "first_name,last_name,email,phone\n" +
+		"Budi,Santoso,budi@example.com,0812-3456-7890\n" +
+		"Siti,Aminah,siti@example.com,+65 6123 4567\n" +
+		"Andi,Wijaya,andi@example.com,0812\n"
+
+	rows, rowErrors, total, err := readImportCSV(strings.NewReader(csv), "", "ID", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(rows) != 2 {
+		t.Fatalf("got %d rows of %d, want 2 of 3", len(rows), total)
+	}
+	if c := rows[0].Contact; c.Phone != "+6281234567890" || c.PhoneRaw == nil || *c.PhoneRaw != "0812-3456-7890" {
+		t.Errorf("row 1: got phone %q, raw %v", c.Phone, c.PhoneRaw)
+	}
+	if c := rows[1].Contact; c.Phone != "+6561234567" {
+		t.Errorf("row 2: got phone %q", c.Phone)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 4 || fmt.Sprint(rowErrors[0].Errors) != "[phone is not a valid number for region ID]" {
+		t.Errorf("got row errors %+v", rowErrors)
+	}
+}
+
+func TestImportVCardsNormalizesPhones(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "vcf@example.com")
+
+	router := httprouter.New()
+	router.POST("/contact/import", AuthMiddleware(ImportContacts))
+
+	body := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Santoso;Budi;;;\r\nFN:Budi Santoso\r\nEMAIL:budi@example.com\r\nTEL:0812-3456-7890\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nN:Aminah;Siti;;;\r\nFN:Siti Aminah\r\nEMAIL:siti@example.com\r\nTEL:0812\r\nEND:VCARD\r\n"
+	r := httptest.NewRequest("POST", "/contact/import?skip_invalid=true", strings.NewReader(body))
+	r.Header.Set("Authorization", user.Email)
+	r.Header.Set("Content-Type", "text/vcard")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Data struct {
+			Invalid int `json:"invalid"`
+			Results []struct {
+				Status string   `json:"status"`
+				Errors []string `json:"errors"`
+			} `json:"results"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	if results := response.Data.Results; len(results) != 2 || results[0].Status != "imported" || results[1].Status != "invalid" {
+		t.Fatalf("got results %+v", results)
+	}
+
+	var phone, raw string
+	if err := GetDB().QueryRow("SELECT phone, phone_raw FROM contacts WHERE user_id = ?", user.UserId).Scan(&phone, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if phone != "+6281234567890" || raw != "0812-3456-7890" {
+		t.Errorf("got phone %q, phone_raw %q", phone, raw)
+	}
+}
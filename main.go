@@ -20,6 +20,13 @@ func main() {
 
 	log.Println("Starting Contact Management API...")
 
+	if err := InitBlobStore(); err != nil {
+		log.Fatal("Failed to configure blob storage:", err)
+	}
+
+	StartTrashPurger()
+	StartPhoneBackfill()
+
 	router := httprouter.New()
 
 	router.POST("/user", CreateUser)
@@ -29,16 +36,131 @@ func main() {
 	router.PUT("/user/:id", AuthMiddleware(UpdateUser))
 
 	router.POST("/contact", AuthMiddleware(CreateContact))
+	router.POST("/contact/:id", AuthMiddleware(staticSegment("id", notFound, map[string]httprouter.Handle{
+		"bulk":   BulkContacts,
+		"import": ImportContacts,
+		"merge":  MergeContacts,
+	})))
 	router.GET("/contact", AuthMiddleware(GetContacts))
-	router.GET("/contact/:id", AuthMiddleware(GetContactId))
+	router.GET("/contact/:id", AuthMiddleware(staticSegment("id", GetContactId, map[string]httprouter.Handle{
+		"suggest":    SuggestContacts,
+		"trash":      GetContactTrash,
+		"export":     ExportContacts,
+		"duplicates": GetContactDuplicates,
+		"upcoming":   GetUpcomingEvents,
+	})))
 	router.PUT("/contact/:id", AuthMiddleware(UpdateContact))
+	router.PATCH("/contact/:id", AuthMiddleware(PatchContact))
 	router.DELETE("/contact/:id", AuthMiddleware(DeleteContact))
+	router.POST("/contact/:id/restore", AuthMiddleware(RestoreContact))
+	router.GET("/contact/:id/vcard", AuthMiddleware(GetContactVCard))
+	router.POST("/contact/:id/photo", AuthMiddleware(UploadContactPhoto))
+	router.GET("/contact/:id/photo", AuthMiddleware(GetContactPhoto))
+	router.DELETE("/contact/:id/photo", AuthMiddleware(DeleteContactPhoto))
+	router.GET("/contact/:id/attachments", AuthMiddleware(GetContactAttachments))
+	router.POST("/contact/:id/attachments", AuthMiddleware(UploadContactAttachment))
+	router.GET("/contact/:id/attachments/:attachmentId", AuthMiddleware(GetContactAttachmentId))
+	router.DELETE("/contact/:id/attachments/:attachmentId", AuthMiddleware(DeleteContactAttachment))
+	router.GET("/attachment/:id", DownloadAttachment)
+	router.GET("/contact/:id/history", AuthMiddleware(GetContactHistory))
+	router.POST("/contact/:id/revert/:version", AuthMiddleware(RevertContact))
+	router.GET("/contact/:id/relationships", AuthMiddleware(GetContactRelationships))
+	router.POST("/contact/:id/relationships", AuthMiddleware(CreateContactRelationship))
+	router.GET("/contact/:id/relationships/:relationshipId", AuthMiddleware(GetContactRelationshipId))
+	router.PUT("/contact/:id/relationships/:relationshipId", AuthMiddleware(UpdateContactRelationship))
+	router.DELETE("/contact/:id/relationships/:relationshipId", AuthMiddleware(DeleteContactRelationship))
+	router.PUT("/contact/:id/company", AuthMiddleware(SetContactCompany))
+	router.DELETE("/contact/:id/company", AuthMiddleware(DeleteContactCompany))
+	router.POST("/contact/:id/tags", AuthMiddleware(AddContactTags))
+	router.PUT("/contact/:id/tags", AuthMiddleware(SetContactTags))
+	router.DELETE("/contact/:id/tags/:tagId", AuthMiddleware(RemoveContactTag))
+	router.GET("/contact/:id/emails", AuthMiddleware(GetContactEmails))
+	router.POST("/contact/:id/emails", AuthMiddleware(CreateContactEmail))
+	router.GET("/contact/:id/emails/:emailId", AuthMiddleware(GetContactEmailId))
+	router.PUT("/contact/:id/emails/:emailId", AuthMiddleware(UpdateContactEmail))
+	router.DELETE("/contact/:id/emails/:emailId", AuthMiddleware(DeleteContactEmail))
+	router.GET("/contact/:id/phones", AuthMiddleware(GetContactPhones))
+	router.POST("/contact/:id/phones", AuthMiddleware(CreateContactPhone))
+	router.GET("/contact/:id/phones/:phoneId", AuthMiddleware(GetContactPhoneId))
+	router.PUT("/contact/:id/phones/:phoneId", AuthMiddleware(UpdateContactPhone))
+	router.DELETE("/contact/:id/phones/:phoneId", AuthMiddleware(DeleteContactPhone))
+	router.GET("/contact/:id/notes", AuthMiddleware(GetContactNotes))
+	router.POST("/contact/:id/notes", AuthMiddleware(CreateContactNote))
+	router.GET("/contact/:id/notes/:noteId", AuthMiddleware(GetContactNoteId))
+	router.PUT("/contact/:id/notes/:noteId", AuthMiddleware(UpdateContactNote))
+	router.DELETE("/contact/:id/notes/:noteId", AuthMiddleware(DeleteContactNote))
+	router.GET("/activity", AuthMiddleware(GetActivity))
+	router.GET("/sync", AuthMiddleware(GetSync))
+	router.GET("/contact/:id/events", AuthMiddleware(GetContactEvents))
+	router.POST("/contact/:id/events", AuthMiddleware(CreateContactEvent))
+	router.GET("/contact/:id/events/:eventId", AuthMiddleware(GetContactEventId))
+	router.PUT("/contact/:id/events/:eventId", AuthMiddleware(UpdateContactEvent))
+	router.DELETE("/contact/:id/events/:eventId", AuthMiddleware(DeleteContactEvent))
+
+	router.POST("/calendar/token", AuthMiddleware(CreateCalendarToken))
+	router.DELETE("/calendar/token", AuthMiddleware(DeleteCalendarToken))
+	router.GET("/calendar/:token", CalendarFeed)
+
+	router.POST("/group", AuthMiddleware(CreateGroup))
+	router.GET("/group", AuthMiddleware(GetGroups))
+	router.GET("/group/:id", AuthMiddleware(GetGroupId))
+	router.PUT("/group/:id", AuthMiddleware(UpdateGroup))
+	router.DELETE("/group/:id", AuthMiddleware(DeleteGroup))
+	router.GET("/group/:id/members", AuthMiddleware(GetGroupMembers))
+	router.POST("/group/:id/members", AuthMiddleware(AddGroupMembers))
+	router.PUT("/group/:id/members", AuthMiddleware(SetGroupMembers))
+	router.DELETE("/group/:id/members", AuthMiddleware(RemoveGroupMembers))
+	router.GET("/group/:id/export", AuthMiddleware(ExportGroup))
+
+	router.POST("/company", AuthMiddleware(CreateCompany))
+	router.GET("/company", AuthMiddleware(GetCompanies))
+	router.GET("/company/:id", AuthMiddleware(GetCompanyId))
+	router.PUT("/company/:id", AuthMiddleware(UpdateCompany))
+	router.DELETE("/company/:id", AuthMiddleware(DeleteCompany))
+	router.GET("/company/:id/addresses", AuthMiddleware(GetCompanyAddresses))
+	router.POST("/company/:id/addresses", AuthMiddleware(CreateCompanyAddress))
+	router.PUT("/company/:id/addresses/:addressId", AuthMiddleware(UpdateCompanyAddress))
+	router.DELETE("/company/:id/addresses/:addressId", AuthMiddleware(DeleteCompanyAddress))
+
+	router.POST("/tag", AuthMiddleware(CreateTag))
+	router.GET("/tag", AuthMiddleware(GetTags))
+	router.GET("/tag/:id", AuthMiddleware(GetTagId))
+	router.PUT("/tag/:id", AuthMiddleware(UpdateTag))
+	router.DELETE("/tag/:id", AuthMiddleware(DeleteTag))
+
+	router.POST("/custom-field", AuthMiddleware(CreateCustomField))
+	router.GET("/custom-field", AuthMiddleware(GetCustomFields))
+	router.GET("/custom-field/:id", AuthMiddleware(GetCustomFieldId))
+	router.PUT("/custom-field/:id", AuthMiddleware(UpdateCustomField))
+	router.DELETE("/custom-field/:id", AuthMiddleware(DeleteCustomField))
+
+	router.GET("/merge", AuthMiddleware(GetContactMerges))
+	router.GET("/merge/:id", AuthMiddleware(GetContactMergeId))
+	router.POST("/merge/:id/undo", AuthMiddleware(UndoContactMerge))
+
+	router.GET("/import/:jobId", AuthMiddleware(GetImportJob))
+
+	router.POST("/app-password", AuthMiddleware(CreateAppPassword))
+	router.GET("/app-password", AuthMiddleware(GetAppPasswords))
+	router.DELETE("/app-password/:id", AuthMiddleware(DeleteAppPassword))
+
+	// CardDAV uses WebDAV methods and HTTP Basic auth
+	for _, method := range []string{"OPTIONS", "PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE"} {
+		router.Handle(method, "/carddav/*path", BasicAuthMiddleware(CardDAV))
+	}
+	for _, method := range []string{"GET", "PROPFIND"} {
+		router.Handle(method, "/.well-known/carddav", CardDAVWellKnown)
+	}
 
 	router.POST("/address/", AuthMiddleware(CreateAddress))
 	router.GET("/address/:contactId", AuthMiddleware(GetAddresses))
-	router.GET("/address/:contactId/:addressId", AuthMiddleware(GetAddressId))
+	router.GET("/address/:contactId/:addressId", AuthMiddleware(staticSegment("addressId", GetAddressId, map[string]httprouter.Handle{
+		"trash": GetAddressTrash,
+	})))
 	router.PUT("/address/:contactId/:addressId", AuthMiddleware(UpdateAddress))
+	router.PATCH("/address/:contactId/:addressId", AuthMiddleware(PatchAddress))
 	router.DELETE("/address/:contactId/:addressId", AuthMiddleware(DeleteAddress))
+	router.POST("/address/:contactId/:addressId/restore", AuthMiddleware(RestoreAddress))
 
 	router.GET("/docs/*filepath", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		http.ServeFile(w, r, "docs"+ps.ByName("filepath"))
@@ -55,3 +177,19 @@ func main() {
 	log.Printf("Server running on http://localhost:%s", port)
 	log.Fatal(http.ListenAndServe(":"+port, router))
 }
+
+func notFound(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	http.NotFound(w, r)
+}
+
+// staticSegment - Dispatch fixed names (e.g. /contact/suggest) that httprouter
+// can't register next to a wildcard segment, falling back to the wildcard handler
+func staticSegment(param string, fallback httprouter.Handle, static map[string]httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if handle, ok := static[ps.ByName(param)]; ok {
+			handle(w, r, ps)
+			return
+		}
+		fallback(w, r, ps)
+	}
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	mergeKeepSurvivor = "survivor"
+	mergeKeepMerged   = "merged"
+)
+
+var errMergeConflict = errors.New("merge conflict")
+
+type mergeRequest struct {
+	SurvivorId string `json:"survivor_id" validate:"required"`
+	MergedId   string `json:"merged_id" validate:"required,nefield=SurvivorId"`
+	// which contact each field is taken from, survivor when omitted
+	Fields map[string]string `json:"fields" validate:"dive,keys,oneof=first_name last_name email phone,endkeys,oneof=survivor merged"`
+}
+
+// mergeChannelState - What a merge did to the email or phone entries
+type mergeChannelState struct {
+	Moved   []string        `json:"moved"`
+	Created []string        `json:"created"`
+	Primary map[string]bool `json:"primary"` // is_primary of every entry before the merge
+}
+
+// mergeSnapshot - Everything needed to undo a merge
+type mergeSnapshot struct {
+	Survivor             Contacts          `json:"survivor"`
+	Merged               Contacts          `json:"merged"`
+	MovedAddresses       []string          `json:"moved_addresses"`
+	MovedNotes           []string          `json:"moved_notes"`
+	MovedEvents          []string          `json:"moved_events"`
+	MovedAttachments     []string          `json:"moved_attachments"`
+	MovedRelationships   []string          `json:"moved_relationships"`
+	RelatedRelationships []string          `json:"related_relationships"` // links pointing at the merged contact
+	Emails               mergeChannelState `json:"emails"`
+	Phones               mergeChannelState `json:"phones"`
+	AddedTags            []string          `json:"added_tags"`
+	AddedGroups          []string          `json:"added_groups"`
+	AddedCustomFields    []string          `json:"added_custom_fields"`
+}
+
+type ContactMerges struct {
+	MergeId    int64             `json:"merge_id"`
+	SurvivorId string            `json:"survivor_id"`
+	MergedId   string            `json:"merged_id"`
+	Fields     map[string]string `json:"fields"`
+	CreatedAt  *string           `json:"created_at,omitempty"`
+	UndoneAt   *string           `json:"undone_at"`
+	Before     *mergeSnapshot    `json:"before,omitempty"`
+}
+
+const contactMergeColumns = "merge_id, survivor_id, merged_id, fields, created_at, undone_at"
+
+func scanContactMerge(row rowScanner, merge *ContactMerges) error {
+	var fields string
+	if err := row.Scan(&merge.MergeId, &merge.SurvivorId, &merge.MergedId, &fields, &merge.CreatedAt, &merge.UndoneAt); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(fields), &merge.Fields)
+}
+
+// inClause - "(?, ?, ?)" and its arguments
+func inClause(ids []string) (string, []any) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return "(" + strings.Repeat("?, ", len(ids)-1) + "?)", args
+}
+
+// queryIds - First column of every row as a string
+func queryIds(exec sqlExecutor, query string, args ...any) ([]string, error) {
+	rows, err := exec.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// execIn - Run a statement ending in "IN" for ids; a no-op without ids
+func execIn(exec sqlExecutor, query string, ids []string, args ...any) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	clause, idArgs := inClause(ids)
+	_, err := exec.Exec(query+" "+clause, append(args, idArgs...)...)
+	return err
+}
+
+// mergeChannel - Move the merged contact's entries the survivor doesn't
+// have yet, then make the entry holding primaryValue the primary one
+func mergeChannel(tx *sql.Tx, c contactChannel, survivorId, mergedId, primaryValue string) (mergeChannelState, error) {
+	state := mergeChannelState{Primary: map[string]bool{}}
+	key := func(v string) string {
+		if c.Noun == "email" {
+			return strings.ToLower(v)
+		}
+		return v
+	}
+
+	rows, err := tx.Query("SELECT "+c.IdColumn+", contact_id, "+c.ValueColumn+", is_primary FROM "+c.Table+" WHERE contact_id IN (?, ?) ORDER BY "+c.IdColumn, survivorId, mergedId)
+	if err != nil {
+		return state, err
+	}
+	type entry struct {
+		id, contactId, value string
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		var primary bool
+		if err := rows.Scan(&e.id, &e.contactId, &e.value, &primary); err != nil {
+			rows.Close()
+			return state, err
+		}
+		state.Primary[e.id] = primary
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+
+	have := map[string]string{} // value -> entry id on the survivor
+	for _, e := range entries {
+		if e.contactId == survivorId {
+			have[key(e.value)] = e.id
+		}
+	}
+	for _, e := range entries {
+		if e.contactId == mergedId && have[key(e.value)] == "" {
+			state.Moved = append(state.Moved, e.id)
+			have[key(e.value)] = e.id
+		}
+	}
+	if err := execIn(tx, "UPDATE "+c.Table+" SET contact_id = ?, is_primary = 0 WHERE "+c.IdColumn+" IN", state.Moved, survivorId); err != nil {
+		return state, err
+	}
+
+	if _, err := tx.Exec("UPDATE "+c.Table+" SET is_primary = 0 WHERE contact_id = ?", survivorId); err != nil {
+		return state, err
+	}
+	if id := have[key(primaryValue)]; id != "" {
+		_, err = tx.Exec("UPDATE "+c.Table+" SET is_primary = 1 WHERE "+c.IdColumn+" = ?", id)
+		return state, err
+	}
+
+	// contacts saved before the entry tables existed may lack an entry
+	result, err := tx.Exec("INSERT INTO "+c.Table+" (contact_id, label, "+c.ValueColumn+", is_primary) VALUES (?, 'other', ?, 1)", survivorId, primaryValue)
+	if err != nil {
+		return state, err
+	}
+	id, _ := result.LastInsertId()
+	state.Created = append(state.Created, strconv.FormatInt(id, 10))
+	return state, nil
+}
+
+// undoMergeChannel - Reverse mergeChannel
+func undoMergeChannel(tx *sql.Tx, c contactChannel, mergedId string, state mergeChannelState) error {
+	if err := execIn(tx, "UPDATE "+c.Table+" SET contact_id = ? WHERE "+c.IdColumn+" IN", state.Moved, mergedId); err != nil {
+		return err
+	}
+	if err := execIn(tx, "DELETE FROM "+c.Table+" WHERE "+c.IdColumn+" IN", state.Created); err != nil {
+		return err
+	}
+	for id, primary := range state.Primary {
+		if _, err := tx.Exec("UPDATE "+c.Table+" SET is_primary = ? WHERE "+c.IdColumn+" = ?", primary, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeContacts - Fold merged into survivor inside tx and return the undo
+// snapshot. The merged contact goes to the trash.
+func mergeContacts(tx *sql.Tx, userId int64, req mergeRequest) (mergeSnapshot, error) {
+	var snapshot mergeSnapshot
+
+	rows, err := tx.Query("SELECT "+contactColumns+" FROM contacts WHERE contact_id IN (?, ?) AND user_id = ? AND deleted_at IS NULL FOR UPDATE", req.SurvivorId, req.MergedId, userId)
+	if err != nil {
+		return snapshot, err
+	}
+	found := 0
+	for rows.Next() {
+		var contact Contacts
+		if err := scanContact(rows, &contact); err != nil {
+			rows.Close()
+			return snapshot, err
+		}
+		if contact.ContactId == req.SurvivorId {
+			snapshot.Survivor = contact
+		} else {
+			snapshot.Merged = contact
+		}
+		found++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return snapshot, err
+	}
+	if found != 2 {
+		return snapshot, sql.ErrNoRows
+	}
+
+	survivor, merged := snapshot.Survivor, snapshot.Merged
+	result := survivor
+	if req.Fields["first_name"] == mergeKeepMerged {
+		result.FirstName = merged.FirstName
+	}
+	if req.Fields["last_name"] == mergeKeepMerged {
+		result.LastName = merged.LastName
+	}
+	if req.Fields["email"] == mergeKeepMerged {
+		result.Email = merged.Email
+	}
+	if req.Fields["phone"] == mergeKeepMerged {
+		result.Phone = merged.Phone
+		result.PhoneRaw = merged.PhoneRaw
+	}
+
+	if snapshot.MovedAddresses, err = queryIds(tx, "SELECT address_id FROM addresses WHERE contact_id = ? AND deleted_at IS NULL", merged.ContactId); err != nil {
+		return snapshot, err
+	}
+	if err := execIn(tx, "UPDATE addresses SET contact_id = ?, version = version + 1 WHERE address_id IN", snapshot.MovedAddresses, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	actor := historyActor{UserId: userId, Source: "merge"}
+	if err := recordAddressHistoryIn(tx, actor, "updated", snapshot.MovedAddresses); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.MovedNotes, err = queryIds(tx, "SELECT note_id FROM contact_notes WHERE contact_id = ?", merged.ContactId); err != nil {
+		return snapshot, err
+	}
+	if err := execIn(tx, "UPDATE contact_notes SET contact_id = ? WHERE note_id IN", snapshot.MovedNotes, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+
+	// events the survivor already has stay behind, as does a second birthday
+	if snapshot.MovedEvents, err = queryIds(tx, "SELECT event_id FROM contact_events e WHERE contact_id = ? AND NOT EXISTS (SELECT 1 FROM contact_events s WHERE s.contact_id = ? AND s.type = e.type AND (e.type = 'birthday' OR (s.month = e.month AND s.day = e.day AND s.label = e.label)))", merged.ContactId, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	if err := execIn(tx, "UPDATE contact_events SET contact_id = ? WHERE event_id IN", snapshot.MovedEvents, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.MovedAttachments, err = queryIds(tx, "SELECT attachment_id FROM contact_attachments WHERE contact_id = ?", merged.ContactId); err != nil {
+		return snapshot, err
+	}
+	if err := execIn(tx, "UPDATE contact_attachments SET contact_id = ? WHERE attachment_id IN", snapshot.MovedAttachments, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.MovedRelationships, snapshot.RelatedRelationships, err = mergeRelationships(tx, survivor.ContactId, merged.ContactId); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.Emails, err = mergeChannel(tx, emailChannel, survivor.ContactId, merged.ContactId, result.Email); err != nil {
+		return snapshot, err
+	}
+	if snapshot.Phones, err = mergeChannel(tx, phoneChannel, survivor.ContactId, merged.ContactId, result.Phone); err != nil {
+		return snapshot, err
+	}
+
+	if snapshot.AddedTags, err = queryIds(tx, "SELECT tag_id FROM contact_tags WHERE contact_id = ? AND tag_id NOT IN (SELECT tag_id FROM contact_tags WHERE contact_id = ?)", merged.ContactId, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	if err := execIn(tx, "INSERT INTO contact_tags (contact_id, tag_id) SELECT ?, tag_id FROM tags WHERE tag_id IN", snapshot.AddedTags, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+
+	// the survivor takes the merged contact's place in groups it wasn't in
+	if snapshot.AddedGroups, err = queryIds(tx, "SELECT group_id FROM contact_group_members WHERE contact_id = ? AND group_id NOT IN (SELECT group_id FROM contact_group_members WHERE contact_id = ?)", merged.ContactId, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	if len(snapshot.AddedGroups) > 0 {
+		clause, args := inClause(snapshot.AddedGroups)
+		if _, err := tx.Exec("INSERT INTO contact_group_members (group_id, contact_id, position) SELECT m.group_id, ?, m.position FROM (SELECT group_id, position FROM contact_group_members WHERE contact_id = ? AND group_id IN "+clause+") m", append([]any{survivor.ContactId, merged.ContactId}, args...)...); err != nil {
+			return snapshot, err
+		}
+	}
+
+	if snapshot.AddedCustomFields, err = queryIds(tx, "SELECT field_id FROM contact_custom_values WHERE contact_id = ? AND field_id NOT IN (SELECT field_id FROM contact_custom_values WHERE contact_id = ?)", merged.ContactId, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	if len(snapshot.AddedCustomFields) > 0 {
+		clause, args := inClause(snapshot.AddedCustomFields)
+		if _, err := tx.Exec("INSERT INTO contact_custom_values (contact_id, field_id, value_text, value_number, value_date) SELECT ?, v.field_id, v.value_text, v.value_number, v.value_date FROM (SELECT field_id, value_text, value_number, value_date FROM contact_custom_values WHERE contact_id = ? AND field_id IN "+clause+") v", append([]any{survivor.ContactId, merged.ContactId}, args...)...); err != nil {
+			return snapshot, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE contacts SET first_name = ?, last_name = ?, email = ?, phone = ?, phone_raw = ?, version = version + 1 WHERE contact_id = ?", result.FirstName, result.LastName, result.Email, result.Phone, result.PhoneRaw, survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	if err := recordContactHistory(tx, actor, "updated", survivor.ContactId); err != nil {
+		return snapshot, err
+	}
+	if _, err := softDeleteContactRow(tx, merged.ContactId, userId, nil, actor); err != nil {
+		return snapshot, err
+	}
+	return snapshot, nil
+}
+
+// MergeContacts - POST /contact/merge
+func MergeContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var req mergeRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	if req.Fields == nil {
+		req.Fields = map[string]string{}
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	snapshot, err := mergeContacts(tx, ctxUser.UserId, req)
+	if err == sql.ErrNoRows {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	var survivor Contacts
+	var mergeId int64
+	if err == nil {
+		err = scanContact(tx.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", req.SurvivorId), &survivor)
+	}
+	if err == nil {
+		var fields, data []byte
+		fields, _ = json.Marshal(req.Fields)
+		data, err = json.Marshal(snapshot)
+		if err == nil {
+			var result sql.Result
+			result, err = tx.Exec("INSERT INTO contact_merges (user_id, survivor_id, merged_id, fields, snapshot, survivor_version) VALUES (?, ?, ?, ?, ?, ?)", ctxUser.UserId, req.SurvivorId, req.MergedId, string(fields), string(data), survivor.Version)
+			if err == nil {
+				mergeId, err = result.LastInsertId()
+			}
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	w.Header().Set("ETag", contactETag(survivor))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":  "Contacts merged successfully",
+		"merge_id": mergeId,
+		"data":     survivor,
+	})
+}
+
+func GetContactMerges(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rows, err := db.Query("SELECT "+contactMergeColumns+" FROM contact_merges WHERE user_id = ? ORDER BY merge_id DESC LIMIT 100", ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	merges := []ContactMerges{}
+	for rows.Next() {
+		var merge ContactMerges
+		if err := scanContactMerge(rows, &merge); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		merges = append(merges, merge)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    merges,
+	})
+}
+
+// GetContactMergeId - A merge with both contacts as they were before it
+func GetContactMergeId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var merge ContactMerges
+	var fields, snapshot string
+	err := db.QueryRow("SELECT "+contactMergeColumns+", snapshot FROM contact_merges WHERE merge_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId).Scan(&merge.MergeId, &merge.SurvivorId, &merge.MergedId, &fields, &merge.CreatedAt, &merge.UndoneAt, &snapshot)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Merge not found",
+		})
+		return
+	}
+	_ = json.Unmarshal([]byte(fields), &merge.Fields)
+	merge.Before = &mergeSnapshot{}
+	_ = json.Unmarshal([]byte(snapshot), merge.Before)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    merge,
+	})
+}
+
+// undoContactMerge - Restore both contacts to their state before the merge.
+// Returns errMergeConflict when that state can't be restored safely.
+func undoContactMerge(tx *sql.Tx, userId int64, mergeId string) (string, error) {
+	var survivorId, mergedId, data string
+	var survivorVersion int64
+	var undoneAt *string
+	err := tx.QueryRow("SELECT survivor_id, merged_id, snapshot, survivor_version, undone_at FROM contact_merges WHERE merge_id = ? AND user_id = ? FOR UPDATE", mergeId, userId).Scan(&survivorId, &mergedId, &data, &survivorVersion, &undoneAt)
+	if err != nil {
+		return "", err
+	}
+	if undoneAt != nil {
+		return "Merge has already been undone", errMergeConflict
+	}
+
+	var version int64
+	if err := tx.QueryRow("SELECT version FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL FOR UPDATE", survivorId, userId).Scan(&version); err != nil || version != survivorVersion {
+		return "The surviving contact was changed or deleted after the merge", errMergeConflict
+	}
+	var trashed int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NOT NULL", mergedId, userId).Scan(&trashed); err != nil || trashed == 0 {
+		return "The merged contact is no longer in the trash", errMergeConflict
+	}
+
+	var snapshot mergeSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return "", err
+	}
+
+	if err := execIn(tx, "UPDATE addresses SET contact_id = ?, version = version + 1 WHERE address_id IN", snapshot.MovedAddresses, mergedId); err != nil {
+		return "", err
+	}
+	actor := historyActor{UserId: userId, Source: "merge"}
+	if err := recordAddressHistoryIn(tx, actor, "updated", snapshot.MovedAddresses); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "UPDATE contact_notes SET contact_id = ? WHERE note_id IN", snapshot.MovedNotes, mergedId); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "UPDATE contact_events SET contact_id = ? WHERE event_id IN", snapshot.MovedEvents, mergedId); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "UPDATE contact_attachments SET contact_id = ? WHERE attachment_id IN", snapshot.MovedAttachments, mergedId); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "UPDATE contact_relationships SET contact_id = ? WHERE relationship_id IN", snapshot.MovedRelationships, mergedId); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "UPDATE contact_relationships SET related_id = ? WHERE relationship_id IN", snapshot.RelatedRelationships, mergedId); err != nil {
+		return "", err
+	}
+	if err := undoMergeChannel(tx, emailChannel, mergedId, snapshot.Emails); err != nil {
+		return "", err
+	}
+	if err := undoMergeChannel(tx, phoneChannel, mergedId, snapshot.Phones); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "DELETE FROM contact_tags WHERE contact_id = ? AND tag_id IN", snapshot.AddedTags, survivorId); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "DELETE FROM contact_group_members WHERE contact_id = ? AND group_id IN", snapshot.AddedGroups, survivorId); err != nil {
+		return "", err
+	}
+	if err := execIn(tx, "DELETE FROM contact_custom_values WHERE contact_id = ? AND field_id IN", snapshot.AddedCustomFields, survivorId); err != nil {
+		return "", err
+	}
+
+	s := snapshot.Survivor
+	if _, err := tx.Exec("UPDATE contacts SET first_name = ?, last_name = ?, email = ?, phone = ?, phone_raw = ?, version = version + 1 WHERE contact_id = ?", s.FirstName, s.LastName, s.Email, s.Phone, s.PhoneRaw, survivorId); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE contacts SET deleted_at = NULL, version = version + 1 WHERE contact_id = ?", mergedId); err != nil {
+		return "", err
+	}
+	if err := recordContactHistory(tx, actor, "updated", survivorId); err != nil {
+		return "", err
+	}
+	if err := recordContactHistory(tx, actor, "restored", mergedId); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("UPDATE contact_merges SET undone_at = NOW() WHERE merge_id = ?", mergeId)
+	return "", err
+}
+
+// UndoContactMerge - POST /merge/:id/undo
+func UndoContactMerge(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	message, err := undoContactMerge(tx, ctxUser.UserId, ps.ByName("id"))
+	switch {
+	case err == sql.ErrNoRows:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Merge not found",
+		})
+		return
+	case err == errMergeConflict:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": message,
+		})
+		return
+	case err == nil:
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Merge undone successfully",
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestMergeAndUndo(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "merge@example.com")
+	survivorId := createTestContact(t, user, "Budi")
+	mergedId := createTestContact(t, user, "Budy")
+	if _, err := GetDB().Exec("UPDATE contacts SET email = 'budi.work@example.com', phone = '+6281234567899' WHERE contact_id = ?", mergedId); err != nil {
+		t.Fatal(err)
+	}
+	addressId := createTestAddress(t, mergedId)
+
+	router := httprouter.New()
+	router.POST("/contact/:id", AuthMiddleware(staticSegment("id", notFound, map[string]httprouter.Handle{
+		"merge": MergeContacts,
+	})))
+	router.POST("/contact/:id/notes", AuthMiddleware(CreateContactNote))
+	router.POST("/merge/:id/undo", AuthMiddleware(UndoContactMerge))
+
+	code, body := serveJSON(t, router, "POST", "/contact/"+mergedId+"/notes", user, `{"type": "note", "body": "Met at the expo"}`)
+	if code != http.StatusCreated {
+		t.Fatalf("create note: got %d %v", code, body)
+	}
+	noteId := body["data"].(map[string]any)["note_id"].(string)
+
+	type state struct {
+		survivorEmail, survivorPhone string
+		addressOwner, noteOwner      string
+		mergedTrashed                bool
+	}
+	load := func() state {
+		t.Helper()
+		var s state
+		var deletedAt *string
+		if err := GetDB().QueryRow("SELECT email, phone FROM contacts WHERE contact_id = ?", survivorId).Scan(&s.survivorEmail, &s.survivorPhone); err != nil {
+			t.Fatal(err)
+		}
+		if err := GetDB().QueryRow("SELECT deleted_at FROM contacts WHERE contact_id = ?", mergedId).Scan(&deletedAt); err != nil {
+			t.Fatal(err)
+		}
+		s.mergedTrashed = deletedAt != nil
+		GetDB().QueryRow("SELECT contact_id FROM addresses WHERE address_id = ?", addressId).Scan(&s.addressOwner)
+		GetDB().QueryRow("SELECT contact_id FROM contact_notes WHERE note_id = ?", noteId).Scan(&s.noteOwner)
+		return s
+	}
+	before := load()
+
+	code, body = serveJSON(t, router, "POST", "/contact/merge", user, `{"survivor_id": "`+survivorId+`", "merged_id": "`+mergedId+`", "fields": {"email": "merged"}}`)
+	if code != http.StatusOK {
+		t.Fatalf("merge: got %d %v", code, body)
+	}
+	mergeId := fmt.Sprint(body["merge_id"])
+
+	merged := load()
+	want := state{"budi.work@example.com", before.survivorPhone, survivorId, survivorId, true}
+	if merged != want {
+		t.Errorf("after the merge: got %+v, want %+v", merged, want)
+	}
+
+	code, body = serveJSON(t, router, "POST", "/merge/"+mergeId+"/undo", user, "")
+	if code != http.StatusOK {
+		t.Fatalf("undo: got %d %v", code, body)
+	}
+	if after := load(); after != before {
+		t.Errorf("after the undo: got %+v, want the state before the merge %+v", after, before)
+	}
+
+	if code, body := serveJSON(t, router, "POST", "/merge/"+mergeId+"/undo", user, ""); code != http.StatusConflict {
+		t.Errorf("second undo: got %d %v, want 409", code, body)
+	}
+}
@@ -24,7 +24,7 @@ func AuthMiddleware(next httprouter.Handle) httprouter.Handle {
 		db := GetDB()
 
 		var user Users
-		err := db.QueryRow("SELECT user_id, name, email, created_at FROM users WHERE token = ?", token).Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt)
+		err := db.QueryRow("SELECT user_id, name, email, created_at, default_region, timezone FROM users WHERE token = ?", token).Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt, &user.DefaultRegion, &user.Timezone)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(401)
@@ -38,3 +38,40 @@ func AuthMiddleware(next httprouter.Handle) httprouter.Handle {
 		next(w, r, p)
 	}
 }
+
+// BasicAuthMiddleware - HTTP Basic authentication for clients that can't send
+// the token header (CardDAV). The password is an app password or the API token.
+func BasicAuthMiddleware(next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		email, password, ok := r.BasicAuth()
+		if !ok || password == "" {
+			basicAuthChallenge(w)
+			return
+		}
+
+		db := GetDB()
+
+		hash := hashAppPassword(password)
+
+		var user Users
+		err := db.QueryRow("SELECT user_id, name, email, created_at, default_region, timezone FROM users u WHERE email = ? AND (token = ? OR EXISTS (SELECT 1 FROM app_passwords p WHERE p.user_id = u.user_id AND p.password_hash = ?))", email, password, hash).Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt, &user.DefaultRegion, &user.Timezone)
+		if err != nil {
+			basicAuthChallenge(w)
+			return
+		}
+		_, _ = db.Exec("UPDATE app_passwords SET last_used_at = NOW() WHERE user_id = ? AND password_hash = ?", user.UserId, hash)
+
+		ctx := context.WithValue(r.Context(), "user", user)
+		r = r.WithContext(ctx)
+		next(w, r, p)
+	}
+}
+
+func basicAuthChallenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Contact Management", charset="UTF-8"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(401)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Unauthorized",
+	})
+}
@@ -0,0 +1,4 @@
+-- Optimistic concurrency control: every UPDATE bumps version, and the
+-- ETag returned by the API is derived from it.
+ALTER TABLE contacts ADD COLUMN version INT NOT NULL DEFAULT 1 AFTER user_id;
+ALTER TABLE addresses ADD COLUMN version INT NOT NULL DEFAULT 1 AFTER contact_id;
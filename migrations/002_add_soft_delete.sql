@@ -0,0 +1,9 @@
+-- Soft delete: rows with deleted_at set are in the trash and are removed
+-- permanently by the purge job after TRASH_RETENTION_DAYS.
+ALTER TABLE contacts ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL AFTER updated_at;
+ALTER TABLE addresses ADD COLUMN deleted_at DATETIME NULL DEFAULT NULL AFTER updated_at;
+
+CREATE INDEX idx_contacts_user_deleted ON contacts (user_id, deleted_at);
+CREATE INDEX idx_addresses_contact_deleted ON addresses (contact_id, deleted_at);
+CREATE INDEX idx_contacts_deleted_at ON contacts (deleted_at);
+CREATE INDEX idx_addresses_deleted_at ON addresses (deleted_at);
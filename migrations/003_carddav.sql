@@ -0,0 +1,18 @@
+-- CardDAV: clients address a card by the resource name they chose when it
+-- was created; contacts created through the REST API have no vcard_uid and
+-- are served as contact-<contact_id>.vcf.
+ALTER TABLE contacts ADD COLUMN vcard_uid VARCHAR(255) NULL DEFAULT NULL AFTER user_id;
+CREATE UNIQUE INDEX idx_contacts_user_vcard_uid ON contacts (user_id, vcard_uid);
+
+-- App passwords for HTTP Basic authentication (CardDAV clients). Only the
+-- SHA-256 of the generated password is stored.
+CREATE TABLE app_passwords (
+  app_password_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  password_hash CHAR(64) NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at DATETIME NULL DEFAULT NULL,
+  INDEX idx_app_passwords_user (user_id),
+  UNIQUE INDEX idx_app_passwords_hash (password_hash)
+);
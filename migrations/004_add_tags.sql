@@ -0,0 +1,18 @@
+-- User-scoped tags and their many-to-many assignment to contacts.
+-- Assignments reference tag_id, so renaming a tag needs no further update.
+CREATE TABLE tags (
+  tag_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(50) NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE INDEX idx_tags_user_name (user_id, name)
+);
+
+CREATE TABLE contact_tags (
+  contact_id BIGINT NOT NULL,
+  tag_id BIGINT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (contact_id, tag_id),
+  INDEX idx_contact_tags_tag (tag_id)
+);
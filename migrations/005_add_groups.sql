@@ -0,0 +1,21 @@
+-- Named contact groups with an explicit member order. Trashed contacts keep
+-- their membership until the purge job removes them.
+CREATE TABLE contact_groups (
+  group_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  description VARCHAR(500) NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE INDEX idx_contact_groups_user_name (user_id, name)
+);
+
+CREATE TABLE contact_group_members (
+  group_id BIGINT NOT NULL,
+  contact_id BIGINT NOT NULL,
+  position INT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (group_id, contact_id),
+  INDEX idx_contact_group_members_position (group_id, position),
+  INDEX idx_contact_group_members_contact (contact_id)
+);
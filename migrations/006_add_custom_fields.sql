@@ -0,0 +1,29 @@
+-- User defined contact fields. Each value is stored in the column matching
+-- the field type so filters can compare numbers and dates natively.
+CREATE TABLE custom_fields (
+  field_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(50) NOT NULL,
+  label VARCHAR(100) NOT NULL DEFAULT '',
+  type VARCHAR(10) NOT NULL,
+  required TINYINT(1) NOT NULL DEFAULT 0,
+  options TEXT NULL,
+  min_value DOUBLE NULL,
+  max_value DOUBLE NULL,
+  max_length INT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE INDEX idx_custom_fields_user_name (user_id, name)
+);
+
+CREATE TABLE contact_custom_values (
+  contact_id BIGINT NOT NULL,
+  field_id BIGINT NOT NULL,
+  value_text VARCHAR(1000) NULL,
+  value_number DECIMAL(30,10) NULL,
+  value_date DATE NULL,
+  PRIMARY KEY (contact_id, field_id),
+  INDEX idx_contact_custom_values_text (field_id, value_text(191)),
+  INDEX idx_contact_custom_values_number (field_id, value_number),
+  INDEX idx_contact_custom_values_date (field_id, value_date)
+);
@@ -0,0 +1,32 @@
+-- Labelled email addresses and phone numbers per contact. The primary entry
+-- mirrors contacts.email / contacts.phone, which stay for compatibility.
+CREATE TABLE contact_emails (
+  email_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  contact_id BIGINT NOT NULL,
+  label VARCHAR(10) NOT NULL DEFAULT 'other',
+  email VARCHAR(255) NOT NULL,
+  is_primary TINYINT(1) NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  INDEX idx_contact_emails_contact (contact_id, is_primary),
+  INDEX idx_contact_emails_email (email)
+);
+
+CREATE TABLE contact_phones (
+  phone_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  contact_id BIGINT NOT NULL,
+  label VARCHAR(10) NOT NULL DEFAULT 'other',
+  phone VARCHAR(50) NOT NULL,
+  is_primary TINYINT(1) NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  INDEX idx_contact_phones_contact (contact_id, is_primary),
+  INDEX idx_contact_phones_phone (phone)
+);
+
+-- existing contacts get their current email / phone as the primary entry
+INSERT INTO contact_emails (contact_id, label, email, is_primary)
+SELECT contact_id, 'other', email, 1 FROM contacts;
+
+INSERT INTO contact_phones (contact_id, label, phone, is_primary)
+SELECT contact_id, 'other', phone, 1 FROM contacts;
@@ -0,0 +1,10 @@
+-- Phone numbers are stored as E.164 in contacts.phone; phone_raw keeps what
+-- the user typed. Parsing needs libphonenumber, so existing rows (phone_raw
+-- NULL) are normalized by the application at startup, see
+-- backfillContactPhones in phone.go.
+ALTER TABLE users
+  ADD COLUMN default_region CHAR(2) NOT NULL DEFAULT 'ID';
+
+ALTER TABLE contacts
+  ADD COLUMN phone_raw VARCHAR(50) NULL DEFAULT NULL AFTER phone,
+  ADD INDEX idx_contacts_phone (user_id, phone);
@@ -0,0 +1,17 @@
+-- One row per merge. snapshot holds both contacts as they were before the
+-- merge plus the ids of every row the merge moved or created, so it can be
+-- undone while the survivor is unchanged (survivor_version) and the merged
+-- contact is still in the trash.
+CREATE TABLE contact_merges (
+  merge_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  survivor_id BIGINT NOT NULL,
+  merged_id BIGINT NOT NULL,
+  fields TEXT NOT NULL,
+  snapshot MEDIUMTEXT NOT NULL,
+  survivor_version INT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  undone_at DATETIME NULL DEFAULT NULL,
+  INDEX idx_contact_merges_user (user_id, merge_id),
+  INDEX idx_contact_merges_merged (merged_id)
+);
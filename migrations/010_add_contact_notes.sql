@@ -0,0 +1,16 @@
+-- Timeline entries per contact (notes, calls, meetings, emails). user_id is
+-- denormalized so the cross-contact activity feed is a single index range.
+CREATE TABLE contact_notes (
+  note_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  contact_id BIGINT NOT NULL,
+  type VARCHAR(10) NOT NULL,
+  title VARCHAR(200) NOT NULL DEFAULT '',
+  body TEXT NOT NULL,
+  occurred_at DATETIME NOT NULL,
+  due_at DATETIME NULL DEFAULT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  INDEX idx_contact_notes_contact (contact_id, occurred_at, note_id),
+  INDEX idx_contact_notes_user (user_id, occurred_at, note_id)
+);
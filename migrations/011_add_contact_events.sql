@@ -0,0 +1,21 @@
+-- Yearly dates per contact (birthday, anniversary, custom). year is NULL when
+-- unknown. users.timezone decides "today" for the upcoming endpoint and
+-- calendar_token_hash authenticates the iCalendar feed.
+CREATE TABLE contact_events (
+  event_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  contact_id BIGINT NOT NULL,
+  type VARCHAR(20) NOT NULL,
+  label VARCHAR(100) NOT NULL DEFAULT '',
+  year SMALLINT NULL DEFAULT NULL,
+  month TINYINT NOT NULL,
+  day TINYINT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  INDEX idx_contact_events_contact (contact_id),
+  INDEX idx_contact_events_date (month, day)
+);
+
+ALTER TABLE users
+  ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Jakarta',
+  ADD COLUMN calendar_token_hash CHAR(64) NULL DEFAULT NULL,
+  ADD UNIQUE INDEX idx_users_calendar_token (calendar_token_hash);
@@ -0,0 +1,5 @@
+-- Contact photos live in the blob store (BLOB_STORE) under
+-- photos/<photo_key>/<size>.<ext>; a new upload gets a new key.
+ALTER TABLE contacts
+  ADD COLUMN photo_key CHAR(32) NULL DEFAULT NULL AFTER phone_raw,
+  ADD COLUMN photo_type VARCHAR(20) NULL DEFAULT NULL AFTER photo_key;
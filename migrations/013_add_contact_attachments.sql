@@ -0,0 +1,15 @@
+-- Files attached to contacts. The content lives in the blob store (BLOB_STORE)
+-- under attachments/<blob_key>; blob_key also signs the download URLs.
+-- user_id is denormalized for the per-user quota (ATTACHMENT_QUOTA_BYTES).
+CREATE TABLE contact_attachments (
+  attachment_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  contact_id BIGINT NOT NULL,
+  blob_key CHAR(32) NOT NULL,
+  filename VARCHAR(255) NOT NULL,
+  content_type VARCHAR(100) NOT NULL,
+  size BIGINT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_contact_attachments_contact (contact_id, attachment_id),
+  INDEX idx_contact_attachments_user (user_id)
+);
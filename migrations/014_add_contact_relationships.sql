@@ -0,0 +1,16 @@
+-- Typed links between two contacts of the same user. type says what
+-- related_id is to contact_id ("assistant": related_id is contact_id's
+-- assistant); a bidirectional link also shows on related_id, inverted.
+CREATE TABLE contact_relationships (
+  relationship_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  contact_id BIGINT NOT NULL,
+  related_id BIGINT NOT NULL,
+  type VARCHAR(20) NOT NULL,
+  label VARCHAR(100) NOT NULL DEFAULT '',
+  bidirectional TINYINT(1) NOT NULL DEFAULT 1,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_contact_relationships (contact_id, related_id, type, label),
+  INDEX idx_contact_relationships_related (related_id)
+);
@@ -0,0 +1,28 @@
+-- Snapshot of a contact or one of its addresses after every change, at the
+-- row's version. actor_id is the user who made the change and source the
+-- part of the API it came through (api, bulk, import, carddav, merge, revert).
+CREATE TABLE contact_history (
+  history_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  contact_id BIGINT NOT NULL,
+  entity VARCHAR(10) NOT NULL,
+  entity_id BIGINT NOT NULL,
+  version BIGINT NOT NULL,
+  action VARCHAR(10) NOT NULL,
+  actor_id BIGINT NOT NULL,
+  source VARCHAR(10) NOT NULL,
+  snapshot JSON NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  INDEX idx_contact_history_contact (contact_id, history_id),
+  INDEX idx_contact_history_entity (entity, entity_id, history_id)
+);
+
+-- the current state of existing data is the first version history knows about
+INSERT INTO contact_history (contact_id, entity, entity_id, version, action, actor_id, source, snapshot)
+SELECT contact_id, 'contact', contact_id, version, 'created', user_id, 'migration',
+  JSON_OBJECT('first_name', first_name, 'last_name', last_name, 'email', email, 'phone', phone, 'phone_raw', phone_raw, 'deleted_at', deleted_at)
+FROM contacts;
+
+INSERT INTO contact_history (contact_id, entity, entity_id, version, action, actor_id, source, snapshot)
+SELECT a.contact_id, 'address', a.address_id, a.version, 'created', c.user_id, 'migration',
+  JSON_OBJECT('street', a.street, 'city', a.city, 'province', a.province, 'country', a.country, 'postal_code', a.postal_code, 'deleted_at', a.deleted_at)
+FROM addresses a JOIN contacts c ON c.contact_id = a.contact_id;
@@ -0,0 +1,23 @@
+-- Companies of a user. A contact works at no or one company; deleting a
+-- company unlinks its contacts. Company addresses live in the addresses
+-- table with company_id set instead of contact_id.
+CREATE TABLE companies (
+  company_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id BIGINT NOT NULL,
+  name VARCHAR(150) NOT NULL,
+  website VARCHAR(255) NOT NULL DEFAULT '',
+  industry VARCHAR(100) NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NULL DEFAULT NULL ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE INDEX idx_companies_user_name (user_id, name)
+);
+
+ALTER TABLE contacts
+  ADD COLUMN company_id BIGINT NULL DEFAULT NULL AFTER photo_type,
+  ADD COLUMN job_title VARCHAR(100) NOT NULL DEFAULT '' AFTER company_id,
+  ADD INDEX idx_contacts_user_company (user_id, company_id);
+
+ALTER TABLE addresses
+  MODIFY contact_id BIGINT NULL,
+  ADD COLUMN company_id BIGINT NULL DEFAULT NULL AFTER contact_id,
+  ADD INDEX idx_addresses_company (company_id);
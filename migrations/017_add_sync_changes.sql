@@ -0,0 +1,29 @@
+-- Change sequence behind GET /sync. sync_sequences holds the last number
+-- handed out per user; sync_changes the number of the latest change of every
+-- contact and contact address. Rows stay after a purge and act as
+-- tombstones.
+CREATE TABLE sync_sequences (
+  user_id BIGINT NOT NULL PRIMARY KEY,
+  seq BIGINT NOT NULL
+);
+
+CREATE TABLE sync_changes (
+  user_id BIGINT NOT NULL,
+  entity VARCHAR(10) NOT NULL,
+  entity_id BIGINT NOT NULL,
+  seq BIGINT NOT NULL,
+  PRIMARY KEY (user_id, entity, entity_id),
+  UNIQUE INDEX idx_sync_changes_seq (user_id, seq)
+);
+
+-- every existing contact and address counts as changed once, contacts first
+INSERT INTO sync_changes (user_id, entity, entity_id, seq)
+SELECT user_id, entity, entity_id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY entity DESC, entity_id)
+FROM (
+  SELECT user_id, 'contact' AS entity, contact_id AS entity_id FROM contacts
+  UNION ALL
+  SELECT c.user_id, 'address', a.address_id FROM addresses a JOIN contacts c ON c.contact_id = a.contact_id
+) existing;
+
+INSERT INTO sync_sequences (user_id, seq)
+SELECT user_id, MAX(seq) FROM sync_changes GROUP BY user_id;
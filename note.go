@@ -0,0 +1,455 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+// latest entries per contact embedded with include=notes
+const contactNoteIncludeLimit = 5
+
+// ContactNotes - One entry of a contact's timeline: a free note or a logged
+// call, meeting or email
+type ContactNotes struct {
+	NoteId     string  `json:"note_id"`
+	ContactId  string  `json:"contact_id"`
+	Type       string  `json:"type"`
+	Title      string  `json:"title"`
+	Body       string  `json:"body"`
+	OccurredAt *string `json:"occurred_at"`
+	DueAt      *string `json:"due_at"`
+	CreatedAt  *string `json:"created_at,omitempty"`
+	UpdatedAt  *string `json:"updated_at,omitempty"`
+}
+
+// ActivityEntry - A timeline entry with the contact it belongs to
+type ActivityEntry struct {
+	ContactNotes
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type contactNoteRequest struct {
+	Type  string `json:"type" validate:"required,oneof=note call meeting email"`
+	Title string `json:"title" validate:"max=200"`
+	Body  string `json:"body" validate:"required,max=10000"`
+	// RFC 3339; occurred_at defaults to now on create and to the stored
+	// value on update
+	OccurredAt *string `json:"occurred_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	DueAt      *string `json:"due_at" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+const contactNoteColumns = "n.note_id, n.contact_id, n.type, n.title, n.body, n.occurred_at, n.due_at, n.created_at, n.updated_at"
+
+func scanContactNote(row rowScanner, note *ContactNotes) error {
+	return row.Scan(&note.NoteId, &note.ContactId, &note.Type, &note.Title, &note.Body, &note.OccurredAt, &note.DueAt, &note.CreatedAt, &note.UpdatedAt)
+}
+
+// noteTime - Parse an RFC 3339 request timestamp for storage in UTC
+func noteTime(v *string) *time.Time {
+	if v == nil {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, *v)
+	if err != nil {
+		return nil
+	}
+	t = t.UTC().Truncate(time.Second)
+	return &t
+}
+
+// noteListQuery - Shared paging of GET /contact/:id/notes and GET /activity,
+// newest first by occurred_at then note_id
+type noteListQuery struct {
+	Type  string
+	Limit int
+	After []string
+}
+
+const noteCursorSort = "-occurred_at"
+
+func parseNoteListQuery(r *http.Request) (*noteListQuery, []string) {
+	values := r.URL.Query()
+	q := &noteListQuery{Limit: contactListDefaultLimit}
+	errMsgs := []string{}
+
+	if v := values.Get("type"); v != "" {
+		switch v {
+		case "note", "call", "meeting", "email":
+			q.Type = v
+		default:
+			errMsgs = append(errMsgs, "type must be one of note, call, meeting, email")
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > contactListMaxLimit {
+			errMsgs = append(errMsgs, fmt.Sprintf("limit must be between 1 and %d", contactListMaxLimit))
+		}
+		q.Limit = n
+	}
+	if v := values.Get("cursor"); v != "" {
+		cursor, err := decodeContactCursor(v)
+		if err != nil || cursor.Sort != noteCursorSort || len(cursor.Values) != 2 {
+			errMsgs = append(errMsgs, "cursor is invalid")
+		} else {
+			q.After = cursor.Values
+		}
+	}
+	if len(errMsgs) > 0 {
+		return nil, errMsgs
+	}
+	return q, nil
+}
+
+// where - Conditions for the type filter and the keyset position
+func (q *noteListQuery) where() (string, []any) {
+	conds := []string{}
+	args := []any{}
+	if q.Type != "" {
+		conds = append(conds, "n.type = ?")
+		args = append(args, q.Type)
+	}
+	if q.After != nil {
+		conds = append(conds, "(n.occurred_at < ? OR (n.occurred_at = ? AND n.note_id < ?))")
+		args = append(args, q.After[0], q.After[0], q.After[1])
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conds, " AND "), args
+}
+
+func (q *noteListQuery) nextCursor(last ContactNotes) *string {
+	b, _ := json.Marshal(contactCursor{Sort: noteCursorSort, Values: []string{mysqlTime(last.OccurredAt), last.NoteId}})
+	cursor := base64.RawURLEncoding.EncodeToString(b)
+	return &cursor
+}
+
+// loadNotesByContact - The latest entries of each contact, for include=notes
+func loadNotesByContact(contactIds []string) (map[string][]ContactNotes, error) {
+	result := map[string][]ContactNotes{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	clause, args := inClause(contactIds)
+	rows, err := GetDB().Query("SELECT "+contactNoteColumns+" FROM (SELECT *, ROW_NUMBER() OVER (PARTITION BY contact_id ORDER BY occurred_at DESC, note_id DESC) AS rn FROM contact_notes WHERE contact_id IN "+clause+") n WHERE n.rn <= ? ORDER BY n.occurred_at DESC, n.note_id DESC", append(args, contactNoteIncludeLimit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var note ContactNotes
+		if err := scanContactNote(rows, &note); err != nil {
+			return nil, err
+		}
+		result[note.ContactId] = append(result[note.ContactId], note)
+	}
+	return result, rows.Err()
+}
+
+func readContactNoteRequest(w http.ResponseWriter, r *http.Request) (contactNoteRequest, bool) {
+	var req contactNoteRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return req, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return req, false
+	}
+	return req, true
+}
+
+func findContactNote(exec sqlExecutor, noteId string, contactId string, userId int64) (ContactNotes, error) {
+	var note ContactNotes
+	err := scanContactNote(exec.QueryRow("SELECT "+contactNoteColumns+" FROM contact_notes n JOIN contacts c ON c.contact_id = n.contact_id WHERE n.note_id = ? AND n.contact_id = ? AND c.user_id = ? AND c.deleted_at IS NULL", noteId, contactId, userId), &note)
+	return note, err
+}
+
+// GetContactNotes - A contact's timeline, newest first
+func GetContactNotes(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	q, errMsgs := parseNoteListQuery(r)
+	if errMsgs != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	where, args := q.where()
+	rows, err := db.Query("SELECT "+contactNoteColumns+" FROM contact_notes n WHERE n.contact_id = ?"+where+" ORDER BY n.occurred_at DESC, n.note_id DESC LIMIT "+strconv.Itoa(q.Limit+1), append([]any{ps.ByName("id")}, args...)...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	notes := []ContactNotes{}
+	for rows.Next() {
+		var note ContactNotes
+		if err := scanContactNote(rows, &note); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		notes = append(notes, note)
+	}
+
+	var nextCursor *string
+	if len(notes) > q.Limit {
+		notes = notes[:q.Limit]
+		nextCursor = q.nextCursor(notes[len(notes)-1])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "Success",
+		"data":        notes,
+		"next_cursor": nextCursor,
+	})
+}
+
+func CreateContactNote(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readContactNoteRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	occurredAt := noteTime(req.OccurredAt)
+	if occurredAt == nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		occurredAt = &now
+	}
+
+	result, err := db.Exec("INSERT INTO contact_notes (user_id, contact_id, type, title, body, occurred_at, due_at) VALUES (?, ?, ?, ?, ?, ?, ?)", ctxUser.UserId, ps.ByName("id"), req.Type, req.Title, req.Body, *occurredAt, noteTime(req.DueAt))
+	var note ContactNotes
+	if err == nil {
+		id, _ := result.LastInsertId()
+		note, err = findContactNote(db, strconv.FormatInt(id, 10), ps.ByName("id"), ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Note created successfully",
+		"data":    note,
+	})
+}
+
+func GetContactNoteId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	note, err := findContactNote(GetDB(), ps.ByName("noteId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Note not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    note,
+	})
+}
+
+// UpdateContactNote - Replace a note. Omitting due_at clears it.
+func UpdateContactNote(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readContactNoteRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := findContactNote(db, ps.ByName("noteId"), ps.ByName("id"), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Note not found",
+		})
+		return
+	}
+
+	_, err := db.Exec("UPDATE contact_notes SET type = ?, title = ?, body = ?, occurred_at = COALESCE(?, occurred_at), due_at = ? WHERE note_id = ?", req.Type, req.Title, req.Body, noteTime(req.OccurredAt), noteTime(req.DueAt), ps.ByName("noteId"))
+	var note ContactNotes
+	if err == nil {
+		note, err = findContactNote(db, ps.ByName("noteId"), ps.ByName("id"), ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Note updated successfully",
+		"data":    note,
+	})
+}
+
+func DeleteContactNote(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	if _, err := findContactNote(db, ps.ByName("noteId"), ps.ByName("id"), ctxUser.UserId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Note not found",
+		})
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM contact_notes WHERE note_id = ?", ps.ByName("noteId")); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Note deleted successfully",
+	})
+}
+
+// GetActivity - The user's timeline across all live contacts, newest first
+func GetActivity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	q, errMsgs := parseNoteListQuery(r)
+	if errMsgs != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	where, args := q.where()
+	rows, err := GetDB().Query("SELECT "+contactNoteColumns+", c.first_name, c.last_name FROM contact_notes n JOIN contacts c ON c.contact_id = n.contact_id WHERE n.user_id = ? AND c.deleted_at IS NULL"+where+" ORDER BY n.occurred_at DESC, n.note_id DESC LIMIT "+strconv.Itoa(q.Limit+1), append([]any{ctxUser.UserId}, args...)...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	entries := []ActivityEntry{}
+	for rows.Next() {
+		var entry ActivityEntry
+		n := &entry.ContactNotes
+		if err := rows.Scan(&n.NoteId, &n.ContactId, &n.Type, &n.Title, &n.Body, &n.OccurredAt, &n.DueAt, &n.CreatedAt, &n.UpdatedAt, &entry.FirstName, &entry.LastName); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		entries = append(entries, entry)
+	}
+
+	var nextCursor *string
+	if len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+		nextCursor = q.nextCursor(entries[len(entries)-1].ContactNotes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message":     "Success",
+		"data":        entries,
+		"next_cursor": nextCursor,
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestContactETagCoversNotes(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "notes@example.com")
+	contactId := createTestContact(t, user, "Budi")
+
+	router := httprouter.New()
+	router.GET("/contact/:id", AuthMiddleware(GetContactId))
+	router.POST("/contact/:id/notes", AuthMiddleware(CreateContactNote))
+	router.PUT("/contact/:id/notes/:noteId", AuthMiddleware(UpdateContactNote))
+	router.DELETE("/contact/:id/notes/:noteId", AuthMiddleware(DeleteContactNote))
+	path := "/contact/" + contactId + "?include=notes"
+
+	var noteId string
+	checkETagChanges(t, router, user, path, func() {
+		code, body := serveJSON(t, router, "POST", "/contact/"+contactId+"/notes", user, `{"type": "call", "body": "Asked about the invoice"}`)
+		if code != http.StatusCreated {
+			t.Fatalf("create note: got %d %v", code, body)
+		}
+		noteId = body["data"].(map[string]any)["note_id"].(string)
+	})
+	checkETagChanges(t, router, user, path, func() {
+		if code, body := serveJSON(t, router, "PUT", "/contact/"+contactId+"/notes/"+noteId, user, `{"type": "call", "body": "Invoice paid"}`); code != http.StatusOK {
+			t.Fatalf("update note: got %d %v", code, body)
+		}
+	})
+	checkETagChanges(t, router, user, path, func() {
+		if code, body := serveJSON(t, router, "DELETE", "/contact/"+contactId+"/notes/"+noteId, user, ""); code != http.StatusOK {
+			t.Fatalf("delete note: got %d %v", code, body)
+		}
+	})
+}
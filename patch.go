@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	errPatchUnsupportedType = errors.New("unsupported patch content type")
+	errPatchTestFailed      = errors.New("patch test operation failed")
+)
+
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyRequestPatch - Apply the request body to doc as JSON Merge Patch
+// (RFC 7396) or JSON Patch (RFC 6902), chosen by Content-Type. Plain
+// application/json is accepted too: an array body is treated as JSON Patch,
+// anything else as a merge patch.
+func applyRequestPatch(r *http.Request, doc any) (any, error) {
+	if r.Body == nil {
+		return nil, errors.New("request body is empty")
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err = mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, errPatchUnsupportedType
+		}
+	}
+
+	switch mediaType {
+	case "application/merge-patch+json":
+		return applyMergePatchBytes(doc, body)
+	case "application/json-patch+json":
+		return applyJSONPatchBytes(doc, body)
+	case "application/json":
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+			return applyJSONPatchBytes(doc, body)
+		}
+		return applyMergePatchBytes(doc, body)
+	}
+	return nil, errPatchUnsupportedType
+}
+
+// patchErrorStatus - HTTP status for an error returned by applyRequestPatch
+func patchErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errPatchUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errPatchTestFailed):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+// toJSONDocument - Round-trip v through encoding/json so it can be patched generically
+func toJSONDocument(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	err = json.Unmarshal(b, &doc)
+	return doc, err
+}
+
+// fromJSONDocument - Decode a patched document back into a struct
+func fromJSONDocument(doc any, v any) error {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func applyMergePatchBytes(doc any, body []byte) (any, error) {
+	var patch any
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return nil, err
+	}
+	return mergePatch(doc, patch), nil
+}
+
+func mergePatch(target any, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+	return targetObj
+}
+
+func applyJSONPatchBytes(doc any, body []byte) (any, error) {
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		path, err := parseJSONPointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+
+		var value any
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: value is required", i)
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = jsonPointerAdd(doc, path, value)
+		case "remove":
+			doc, _, err = jsonPointerRemove(doc, path)
+		case "replace":
+			if _, err = jsonPointerGet(doc, path); err == nil {
+				if len(path) == 0 {
+					doc = value
+				} else if doc, _, err = jsonPointerRemove(doc, path); err == nil {
+					doc, err = jsonPointerAdd(doc, path, value)
+				}
+			}
+		case "move", "copy":
+			var from []string
+			from, err = parseJSONPointer(op.From)
+			if err != nil {
+				break
+			}
+			if op.Op == "move" && strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				err = errors.New("cannot move a value into one of its children")
+				break
+			}
+			var moved any
+			if moved, err = jsonPointerGet(doc, from); err != nil {
+				break
+			}
+			if op.Op == "move" {
+				doc, _, err = jsonPointerRemove(doc, from)
+			} else {
+				moved, err = toJSONDocument(moved)
+			}
+			if err == nil {
+				doc, err = jsonPointerAdd(doc, path, moved)
+			}
+		case "test":
+			var current any
+			if current, err = jsonPointerGet(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+				err = errPatchTestFailed
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func jsonArrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if idx > max {
+		return 0, fmt.Errorf("array index %d out of range", idx)
+	}
+	return idx, nil
+}
+
+func jsonPointerGet(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch d := doc.(type) {
+		case map[string]any:
+			v, ok := d[token]
+			if !ok {
+				return nil, fmt.Errorf("path member %q not found", token)
+			}
+			doc = v
+		case []any:
+			idx, err := jsonArrayIndex(token, len(d), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[idx]
+		default:
+			return nil, fmt.Errorf("path member %q not found", token)
+		}
+	}
+	return doc, nil
+}
+
+func jsonPointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token := path[0]
+	switch d := doc.(type) {
+	case map[string]any:
+		if len(path) == 1 {
+			d[token] = value
+			return d, nil
+		}
+		child, ok := d[token]
+		if !ok {
+			return nil, fmt.Errorf("path member %q not found", token)
+		}
+		child, err := jsonPointerAdd(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		d[token] = child
+		return d, nil
+	case []any:
+		idx, err := jsonArrayIndex(token, len(d), len(path) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			d = append(d, nil)
+			copy(d[idx+1:], d[idx:])
+			d[idx] = value
+			return d, nil
+		}
+		child, err := jsonPointerAdd(d[idx], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		d[idx] = child
+		return d, nil
+	}
+	return nil, fmt.Errorf("path member %q not found", token)
+}
+
+func jsonPointerRemove(doc any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+	token := path[0]
+	switch d := doc.(type) {
+	case map[string]any:
+		child, ok := d[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path member %q not found", token)
+		}
+		if len(path) == 1 {
+			delete(d, token)
+			return d, child, nil
+		}
+		child, removed, err := jsonPointerRemove(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		d[token] = child
+		return d, removed, nil
+	case []any:
+		idx, err := jsonArrayIndex(token, len(d), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := d[idx]
+			return append(d[:idx], d[idx+1:]...), removed, nil
+		}
+		child, removed, err := jsonPointerRemove(d[idx], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		d[idx] = child
+		return d, removed, nil
+	}
+	return nil, nil, fmt.Errorf("path member %q not found", token)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+)
+
+// Phone numbers are parsed, validated and formatted with libphonenumber's
+// metadata (the nyaruka/phonenumbers port), which is compiled into the
+// binary, so nothing is fetched at runtime.
+
+const defaultPhoneRegion = "ID"
+
+// validPhoneRegion - True for the regions libphonenumber has metadata for
+// (ISO 3166-1 alpha-2 codes such as ID, SG, US)
+func validPhoneRegion(region string) bool {
+	return phonenumbers.GetSupportedRegions()[region]
+}
+
+type phoneNumber struct {
+	E164 string
+
+	number *phonenumbers.PhoneNumber
+}
+
+var phoneAllowedChars = regexp.MustCompile(`^\+?[0-9 ()./\-]+$`)
+
+// parsePhone - Parse a number as typed by a user. Numbers without a leading
+// "+" or "00" are read as national numbers of defaultRegion, including the
+// "62812..." form where the calling code was typed without the plus.
+func parsePhone(raw string, defaultRegion string) (phoneNumber, error) {
+	raw = strings.TrimSpace(raw)
+	if !phoneAllowedChars.MatchString(raw) {
+		return phoneNumber{}, fmt.Errorf("phone contains invalid characters")
+	}
+
+	// "+62 (0)812..." is common; the (0) is the optional national prefix
+	raw = strings.ReplaceAll(raw, "(0)", "")
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, raw)
+
+	// 00 is the international prefix almost everywhere, also where
+	// libphonenumber only knows a carrier specific one (ID uses 001, 007, ...)
+	if !strings.HasPrefix(raw, "+") && strings.HasPrefix(digits, "00") {
+		raw = "+" + digits[2:]
+	}
+
+	if strings.HasPrefix(raw, "+") {
+		number, err := phonenumbers.Parse(raw, "")
+		if err != nil || !phonenumbers.IsValidNumber(number) {
+			return phoneNumber{}, fmt.Errorf("phone is not a valid international number")
+		}
+		return newPhoneNumber(number), nil
+	}
+
+	if !validPhoneRegion(defaultRegion) {
+		return phoneNumber{}, fmt.Errorf("region %q is not supported", defaultRegion)
+	}
+	number, err := phonenumbers.Parse(raw, defaultRegion)
+	if err != nil || !phonenumbers.IsValidNumber(number) {
+		return phoneNumber{}, fmt.Errorf("phone is not a valid number for region %s", defaultRegion)
+	}
+	return newPhoneNumber(number), nil
+}
+
+func newPhoneNumber(number *phonenumbers.PhoneNumber) phoneNumber {
+	return phoneNumber{
+		E164:   phonenumbers.Format(number, phonenumbers.E164),
+		number: number,
+	}
+}
+
+// formats - National and international display forms
+func (p phoneNumber) formats() (national string, international string) {
+	return phonenumbers.Format(p.number, phonenumbers.NATIONAL), phonenumbers.Format(p.number, phonenumbers.INTERNATIONAL)
+}
+
+// phoneDisplay - Display forms of a stored value; ok is false for values
+// saved before normalization that don't parse as E.164
+func phoneDisplay(stored string) (national string, international string, ok bool) {
+	if !strings.HasPrefix(stored, "+") {
+		return "", "", false
+	}
+	number, err := parsePhone(stored, defaultPhoneRegion)
+	if err != nil {
+		return "", "", false
+	}
+	national, international = number.formats()
+	return national, international, true
+}
+
+// normalizeContactPhone - Replace contact.Phone with its E.164 form and keep
+// what was typed in PhoneRaw. When the phone is unchanged from current (a
+// client writing back what it read) the stored original is kept.
+func normalizeContactPhone(contact *Contacts, region string, current *Contacts) error {
+	if current != nil && contact.Phone == current.Phone {
+		contact.PhoneRaw = current.PhoneRaw
+		return nil
+	}
+	number, err := parsePhone(contact.Phone, region)
+	if err != nil {
+		return err
+	}
+	raw := strings.TrimSpace(contact.Phone)
+	contact.Phone = number.E164
+	contact.PhoneRaw = &raw
+	return nil
+}
+
+// fillPhoneDisplay - Set the read-only display fields from contact.Phone
+func (c *Contacts) fillPhoneDisplay() {
+	c.PhoneNational, c.PhoneInternational, _ = phoneDisplay(c.Phone)
+}
+
+// userPhoneRegion - The user's default region for numbers typed without a
+// country code
+func userPhoneRegion(user Users) string {
+	if user.DefaultRegion == "" {
+		return defaultPhoneRegion
+	}
+	return user.DefaultRegion
+}
+
+type phoneBackfillRow struct {
+	ContactId string
+	UserId    int64
+	Phone     string
+	Region    string
+}
+
+// backfillContactPhones - Normalize the phones stored before migration 008
+// (phone_raw NULL) with the owner's default_region, batchSize contacts per
+// transaction. The old value becomes phone_raw; numbers that don't parse
+// keep their value, so every contact is only looked at once. Returns how
+// many phones changed.
+func backfillContactPhones(batchSize int) (int, error) {
+	db := GetDB()
+
+	changed := 0
+	last := int64(0)
+	for {
+		rows, err := db.Query("SELECT c.contact_id, c.user_id, c.phone, u.default_region FROM contacts c JOIN users u ON u.user_id = c.user_id WHERE c.phone_raw IS NULL AND c.contact_id > ? ORDER BY c.contact_id LIMIT "+strconv.Itoa(batchSize), last)
+		if err != nil {
+			return changed, err
+		}
+		batch := []phoneBackfillRow{}
+		for rows.Next() {
+			var row phoneBackfillRow
+			if err := rows.Scan(&row.ContactId, &row.UserId, &row.Phone, &row.Region); err != nil {
+				rows.Close()
+				return changed, err
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return changed, err
+		}
+		if len(batch) == 0 {
+			return changed, nil
+		}
+		last, _ = strconv.ParseInt(batch[len(batch)-1].ContactId, 10, 64)
+
+		n, err := backfillContactPhoneBatch(batch)
+		if err != nil {
+			return changed, err
+		}
+		changed += n
+	}
+}
+
+func backfillContactPhoneBatch(batch []phoneBackfillRow) (int, error) {
+	tx, err := GetDB().Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	users := map[int64]bool{}
+	for _, row := range batch {
+		value := row.Phone
+		if number, err := parsePhone(row.Phone, row.Region); err == nil {
+			value = number.E164
+		}
+		if value == row.Phone {
+			// already E.164 or not a number; only remember it was looked at
+			if _, err := tx.Exec("UPDATE contacts SET phone_raw = phone WHERE contact_id = ? AND phone = ? AND phone_raw IS NULL", row.ContactId, row.Phone); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// the phone may have been edited since it was read
+		result, err := tx.Exec("UPDATE contacts SET phone = ?, phone_raw = ?, version = version + 1 WHERE contact_id = ? AND phone = ? AND phone_raw IS NULL", value, row.Phone, row.ContactId, row.Phone)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE contact_phones SET phone = ? WHERE contact_id = ? AND is_primary = 1", value, row.ContactId); err != nil {
+			return 0, err
+		}
+		if err := recordContactHistory(tx, historyActor{UserId: row.UserId, Source: "migration"}, "updated", row.ContactId); err != nil {
+			return 0, err
+		}
+		changed++
+		users[row.UserId] = true
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for userId := range users {
+		invalidateContactSuggest(userId)
+	}
+	return changed, nil
+}
+
+// StartPhoneBackfill - Run backfillContactPhones once in the background
+func StartPhoneBackfill() {
+	go func() {
+		changed, err := backfillContactPhones(importEnvInt("PHONE_BACKFILL_BATCH_SIZE", 500))
+		if err != nil {
+			log.Printf("Failed to normalize stored phone numbers: %v", err)
+		}
+		if changed > 0 {
+			log.Printf("Normalized %d stored phone numbers", changed)
+		}
+	}()
+}
@@ -0,0 +1,125 @@
+package main
+
+import "testing"
+
+func TestParsePhone(t *testing.T) {
+	for _, tc := range []struct {
+		raw, region                   string
+		e164, national, international string
+	}{
+		{"0812-3456-7890", "ID", "+6281234567890", "0812-3456-7890", "+62 812-3456-7890"},
+		{"+62 812-3456-7890", "US", "+6281234567890", "0812-3456-7890", "+62 812-3456-7890"},
+		{"62812 3456 7890", "ID", "+6281234567890", "0812-3456-7890", "+62 812-3456-7890"},
+		{"+62 (0)812 3456 7890", "ID", "+6281234567890", "0812-3456-7890", "+62 812-3456-7890"},
+		{"(021) 5551234", "ID", "+62215551234", "(021) 5551234", "+62 21 5551234"},
+		{"0065 6123 4567", "ID", "+6561234567", "6123 4567", "+65 6123 4567"},
+		{"(201) 555-0123", "US", "+12015550123", "(201) 555-0123", "+1 201-555-0123"},
+		{"+49 30 123456", "ID", "+4930123456", "030 123456", "+49 30 123456"},
+	} {
+		number, err := parsePhone(tc.raw, tc.region)
+		if err != nil {
+			t.Errorf("parsePhone(%q, %s): %v", tc.raw, tc.region, err)
+			continue
+		}
+		national, international := number.formats()
+		if number.E164 != tc.e164 || national != tc.national || international != tc.international {
+			t.Errorf("parsePhone(%q, %s) = %s, %q, %q; want %s, %q, %q", tc.raw, tc.region, number.E164, national, international, tc.e164, tc.national, tc.international)
+		}
+	}
+
+	for _, tc := range []struct{ raw, region string }{
+		{"0812", "ID"},
+		{"+999 1234 5678", "ID"},
+		{"+62 12345", "ID"},
+		{"0812-3456-7890", "XX"},
+		{"call me", "ID"},
+		{"+1 (000) 555-0123", "ID"},
+	} {
+		if number, err := parsePhone(tc.raw, tc.region); err == nil {
+			t.Errorf("parsePhone(%q, %s) = %s, want an error", tc.raw, tc.region, number.E164)
+		}
+	}
+}
+
+func TestPhoneDisplay(t *testing.T) {
+	national, international, ok := phoneDisplay("+6281234567890")
+	if !ok || national != "0812-3456-7890" || international != "+62 812-3456-7890" {
+		t.Errorf("got %q, %q, %v", national, international, ok)
+	}
+	// values saved before normalization have no display forms
+	if _, _, ok := phoneDisplay("0812-3456-7890"); ok {
+		t.Error("a national number got display forms")
+	}
+}
+
+func TestBackfillContactPhones(t *testing.T) {
+	openTestDB(t)
+	id := createTestUser(t, "id@example.com")
+	us := createTestUser(t, "us@example.com")
+	if _, err := GetDB().Exec("UPDATE users SET default_region = 'US' WHERE user_id = ?", us.UserId); err != nil {
+		t.Fatal(err)
+	}
+
+	// rows as they were before migration 008, plus one saved afterwards
+	for _, row := range []struct {
+		userId int64
+		phone  string
+		raw    any
+	}{
+		{id.UserId, "0812-3456-7890", nil},
+		{id.UserId, "+6281234567891", nil},
+		{id.UserId, "12345", nil},
+		{us.UserId, "(201) 555-0123", nil},
+		{id.UserId, "+6281234567892", "0812 3456 7892"},
+	} {
+		result, err := GetDB().Exec("INSERT INTO contacts (first_name, last_name, email, phone, phone_raw, user_id) VALUES ('A', 'B', 'a@example.com', ?, ?, ?)", row.phone, row.raw, row.userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contactId, _ := result.LastInsertId()
+		if _, err := GetDB().Exec("INSERT INTO contact_phones (contact_id, label, phone, is_primary) VALUES (?, 'other', ?, 1)", contactId, row.phone); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := backfillContactPhones(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 2 {
+		t.Errorf("changed %d phones, want 2", changed)
+	}
+
+	want := [][4]string{
+		{"+6281234567890", "0812-3456-7890", "+6281234567890", "2"},
+		{"+6281234567891", "+6281234567891", "+6281234567891", "1"},
+		{"12345", "12345", "12345", "1"},
+		{"+12015550123", "(201) 555-0123", "+12015550123", "2"},
+		{"+6281234567892", "0812 3456 7892", "+6281234567892", "1"},
+	}
+	rows, err := GetDB().Query("SELECT c.phone, c.phone_raw, p.phone, c.version FROM contacts c JOIN contact_phones p ON p.contact_id = c.contact_id AND p.is_primary = 1 ORDER BY c.contact_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for i := 0; rows.Next(); i++ {
+		var got [4]string
+		if err := rows.Scan(&got[0], &got[1], &got[2], &got[3]); err != nil {
+			t.Fatal(err)
+		}
+		if got != want[i] {
+			t.Errorf("contact %d: got phone, phone_raw, primary phone, version %q, want %q", i+1, got, want[i])
+		}
+	}
+
+	var history int
+	GetDB().QueryRow("SELECT COUNT(*) FROM contact_history WHERE source = 'migration' AND action = 'updated'").Scan(&history)
+	if history != 2 {
+		t.Errorf("got %d history entries, want 2", history)
+	}
+
+	// a second run has nothing left to do
+	if changed, err := backfillContactPhones(2); err != nil || changed != 0 {
+		t.Errorf("second run: changed %d, %v", changed, err)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// refuse images whose decoded size would use too much memory
+	photoMaxPixels   = 40_000_000
+	photoJPEGQuality = 85
+)
+
+// photoSizes - Square thumbnails generated on upload, by edge length.
+// "original" is the uploaded file as is.
+var photoSizes = map[string]int{
+	"large": 512,
+	"small": 128,
+}
+
+var photoExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+}
+
+// ContactPhoto - URLs of a contact's photo in each size. The v parameter
+// changes with every upload so the URLs can be cached forever.
+type ContactPhoto struct {
+	Original string `json:"original"`
+	Large    string `json:"large"`
+	Small    string `json:"small"`
+}
+
+// fillPhoto - Set contact.Photo from the stored photo key
+func (c *Contacts) fillPhoto() {
+	if c.PhotoKey == nil {
+		c.Photo = nil
+		return
+	}
+	url := func(size string) string {
+		return "/contact/" + c.ContactId + "/photo?size=" + size + "&v=" + *c.PhotoKey
+	}
+	c.Photo = &ContactPhoto{Original: url("original"), Large: url("large"), Small: url("small")}
+}
+
+// photoBlobKey - Where one size of a photo is stored
+func photoBlobKey(photoKey, contentType, size string) string {
+	return "photos/" + photoKey + "/" + size + "." + photoExtensions[contentType]
+}
+
+// deletePhotoBlobs - Remove every size of a photo. Failures are only logged:
+// the database no longer points at the blobs.
+func deletePhotoBlobs(photoKey, contentType string) {
+	store := GetBlobStore()
+	for _, size := range []string{"original", "large", "small"} {
+		if err := store.Delete(context.Background(), photoBlobKey(photoKey, contentType, size)); err != nil {
+			log.Printf("Failed to delete photo blob: %v", err)
+		}
+	}
+}
+
+// jpegOrientation - The EXIF orientation (1-8) of a JPEG, 1 when absent
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1 // start of scan: no more metadata
+		}
+		segment := data[i+4 : i+2+length]
+		i += 2 + length
+		if marker != 0xE1 || len(segment) < 14 || string(segment[:6]) != "Exif\x00\x00" {
+			continue
+		}
+
+		tiff := segment[6:]
+		var order binary.ByteOrder
+		switch string(tiff[:2]) {
+		case "II":
+			order = binary.LittleEndian
+		case "MM":
+			order = binary.BigEndian
+		default:
+			return 1
+		}
+		ifd := int(order.Uint32(tiff[4:]))
+		if ifd+2 > len(tiff) {
+			return 1
+		}
+		entries := int(order.Uint16(tiff[ifd:]))
+		for e := 0; e < entries; e++ {
+			at := ifd + 2 + e*12
+			if at+12 > len(tiff) {
+				return 1
+			}
+			if order.Uint16(tiff[at:]) == 0x0112 {
+				if v := int(order.Uint16(tiff[at+8:])); v >= 1 && v <= 8 {
+					return v
+				}
+				return 1
+			}
+		}
+		return 1
+	}
+	return 1
+}
+
+// orientedPixel - Source coordinates for a pixel of the upright image,
+// given the stored image size and its EXIF orientation
+func orientedPixel(orientation, x, y, w, h int) (int, int) {
+	switch orientation {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, h - 1 - x
+	case 7:
+		return w - 1 - y, h - 1 - x
+	case 8:
+		return w - 1 - y, x
+	}
+	return x, y
+}
+
+// squareThumbnail - Center crop to a square and downscale to edge pixels by
+// averaging each source area, applying the EXIF orientation on the way.
+// Images smaller than edge are cropped but not enlarged.
+func squareThumbnail(src image.Image, orientation int, edge int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	uw, uh := w, h // upright size
+	if orientation >= 5 {
+		uw, uh = h, w
+	}
+	side := min(uw, uh)
+	ox, oy := (uw-side)/2, (uh-side)/2
+	edge = min(edge, side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, edge, edge))
+	for dy := 0; dy < edge; dy++ {
+		y0, y1 := dy*side/edge, max((dy+1)*side/edge, dy*side/edge+1)
+		for dx := 0; dx < edge; dx++ {
+			x0, x1 := dx*side/edge, max((dx+1)*side/edge, dx*side/edge+1)
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sx, sy := orientedPixel(orientation, ox+x, oy+y, w, h)
+					pr, pg, pb, pa := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+func encodePhoto(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: photoJPEGQuality})
+	}
+	return buf.Bytes(), err
+}
+
+// processPhoto - Sniff and decode an upload and build every stored size,
+// keyed like photoSizes plus "original". Errors are meant for the client.
+func processPhoto(data []byte) (string, map[string][]byte, error) {
+	mt := mimetype.Detect(data)
+	contentType := ""
+	for candidate := range photoExtensions {
+		if mt.Is(candidate) {
+			contentType = candidate
+		}
+	}
+	if contentType == "" {
+		return "", nil, fmt.Errorf("photo must be a JPEG or PNG image, got %s", mt.String())
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("photo could not be decoded")
+	}
+	if config.Width*config.Height > photoMaxPixels {
+		return "", nil, fmt.Errorf("photo must be at most %d megapixels", photoMaxPixels/1_000_000)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("photo could not be decoded")
+	}
+
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	blobs := map[string][]byte{"original": data}
+	for size, edge := range photoSizes {
+		encoded, err := encodePhoto(squareThumbnail(img, orientation, edge), contentType)
+		if err != nil {
+			return "", nil, err
+		}
+		blobs[size] = encoded
+	}
+	return contentType, blobs, nil
+}
+
+// replaceContactPhoto - Point the contact at another photo (nil to remove
+// it) and bump its version. Returns the contact as it was just before, whose
+// photo blobs the caller should delete.
+func replaceContactPhoto(db *sql.DB, r *http.Request, current Contacts, photoKey, contentType *string) (Contacts, bool, error) {
+	var old Contacts
+	tx, err := db.Begin()
+	if err != nil {
+		return old, false, err
+	}
+	defer tx.Rollback()
+
+	err = scanContact(tx.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ? AND deleted_at IS NULL FOR UPDATE", current.ContactId), &old)
+	if err == sql.ErrNoRows {
+		return old, false, nil
+	}
+	if err != nil {
+		return old, false, err
+	}
+
+	query, args := versionedUpdate("UPDATE contacts SET photo_key = ?, photo_type = ?, version = version + 1 WHERE contact_id = ?", r, current.Version, photoKey, contentType, current.ContactId)
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return old, false, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return old, false, nil
+	}
+	userId, _ := strconv.ParseInt(old.UserId, 10, 64)
+	if err := recordSyncChanges(tx, userId, "contact", []string{current.ContactId}); err != nil {
+		return old, false, err
+	}
+	return old, true, tx.Commit()
+}
+
+// UploadContactPhoto - POST /contact/:id/photo, multipart field "photo".
+// Replaces the current photo and bumps the contact version.
+func UploadContactPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	maxBytes := int64(importEnvInt("PHOTO_MAX_BYTES", 5<<20))
+	// room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+	if err := r.ParseMultipartForm(maxBytes); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("Photo must be a multipart upload of at most %d bytes", maxBytes),
+		})
+		return
+	}
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"photo is required"},
+		})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil || int64(len(data)) > maxBytes {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": fmt.Sprintf("Photo must be at most %d bytes", maxBytes),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+
+	contentType, blobs, err := processPhoto(data)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{err.Error()},
+		})
+		return
+	}
+
+	secret := make([]byte, 16)
+	_, err = rand.Read(secret)
+	photoKey := hex.EncodeToString(secret)
+	for size, blob := range blobs {
+		if err != nil {
+			break
+		}
+		err = GetBlobStore().Put(r.Context(), photoBlobKey(photoKey, contentType, size), bytes.NewReader(blob), int64(len(blob)), contentType)
+	}
+	if err != nil {
+		log.Printf("Failed to store photo: %v", err)
+		deletePhotoBlobs(photoKey, contentType)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	old, updated, err := replaceContactPhoto(db, r, current, &photoKey, &contentType)
+	if err != nil || !updated {
+		deletePhotoBlobs(photoKey, contentType)
+		if err == nil {
+			writeStaleOrMissing(w, r, "Contact not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if old.PhotoKey != nil && old.PhotoType != nil {
+		deletePhotoBlobs(*old.PhotoKey, *old.PhotoType)
+	}
+
+	current = old
+	current.Version++
+	current.PhotoKey = &photoKey
+	current.fillPhoto()
+
+	w.Header().Set("ETag", contactETag(current))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Photo uploaded successfully",
+		"data":    current.Photo,
+	})
+}
+
+// GetContactPhoto - GET /contact/:id/photo?size=large|small|original
+func GetContactPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "large"
+	}
+	if _, ok := photoSizes[size]; !ok && size != "original" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"size must be one of large, small, original"},
+		})
+		return
+	}
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var photoKey, contentType *string
+	err := GetDB().QueryRow("SELECT photo_key, photo_type FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&photoKey, &contentType)
+	if err != nil || photoKey == nil || contentType == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Photo not found",
+		})
+		return
+	}
+
+	// a URL carrying the current key never changes content
+	if r.URL.Query().Get("v") == *photoKey {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	if notModified(w, r, "\""+*photoKey+"-"+size+"\"") {
+		return
+	}
+
+	body, length, err := GetBlobStore().Get(r.Context(), photoBlobKey(*photoKey, *contentType, size))
+	if err != nil {
+		log.Printf("Failed to read photo: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", *contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(200)
+	io.Copy(w, body)
+}
+
+// DeleteContactPhoto - Remove the photo and bump the contact version
+func DeleteContactPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	current, ok := loadContactForWrite(w, r, ps.ByName("id"), ctxUser.UserId)
+	if !ok {
+		return
+	}
+	if current.PhotoKey == nil || current.PhotoType == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Photo not found",
+		})
+		return
+	}
+
+	old, updated, err := replaceContactPhoto(GetDB(), r, current, nil, nil)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if !updated {
+		writeStaleOrMissing(w, r, "Contact not found")
+		return
+	}
+	if old.PhotoKey != nil && old.PhotoType != nil {
+		deletePhotoBlobs(*old.PhotoKey, *old.PhotoType)
+	}
+
+	current = old
+	current.Version++
+	w.Header().Set("ETag", contactETag(current))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Photo deleted successfully",
+	})
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+// relationshipInverse - How a link reads from the other contact. A type
+// says what the related contact is to the contact: (A, B, assistant) means
+// B is A's assistant, so A is B's manager.
+var relationshipInverse = map[string]string{
+	"spouse":     "spouse",
+	"partner":    "partner",
+	"sibling":    "sibling",
+	"friend":     "friend",
+	"works_with": "works_with",
+	"assistant":  "manager",
+	"manager":    "assistant",
+	"parent":     "child",
+	"child":      "parent",
+	"custom":     "custom",
+}
+
+// ContactRelationships - A typed link from a contact to another one of the
+// same user, seen from ContactId. A bidirectional link also shows up on the
+// related contact with the inverse type and Inverse set.
+type ContactRelationships struct {
+	RelationshipId   string  `json:"relationship_id"`
+	ContactId        string  `json:"contact_id"`
+	RelatedContactId string  `json:"related_contact_id"`
+	RelatedFirstName string  `json:"related_first_name"`
+	RelatedLastName  string  `json:"related_last_name"`
+	Type             string  `json:"type"`
+	Label            string  `json:"label"`
+	Bidirectional    bool    `json:"bidirectional"`
+	Inverse          bool    `json:"inverse"`
+	CreatedAt        *string `json:"created_at,omitempty"`
+	UpdatedAt        *string `json:"updated_at,omitempty"`
+}
+
+type contactRelationshipRequest struct {
+	// ignored on update; delete and recreate the link to point it elsewhere
+	RelatedContactId string `json:"related_contact_id" validate:"omitempty,numeric"`
+	Type             string `json:"type" validate:"required,oneof=spouse partner sibling friend works_with assistant manager parent child custom"`
+	Label            string `json:"label" validate:"required_if=Type custom,max=100"`
+	// defaults to true
+	Bidirectional *bool `json:"bidirectional"`
+}
+
+// storedRelationship - A row as stored, with the names of both contacts
+type storedRelationship struct {
+	RelationshipId string
+	ContactId      string
+	RelatedId      string
+	Type           string
+	Label          string
+	Bidirectional  bool
+	CreatedAt      *string
+	UpdatedAt      *string
+	FirstName      string
+	LastName       string
+	RelFirstName   string
+	RelLastName    string
+}
+
+// only links between two live contacts are visible; a trashed side hides
+// them until it is restored or purged
+const contactRelationshipQuery = "SELECT r.relationship_id, r.contact_id, r.related_id, r.type, r.label, r.bidirectional, r.created_at, r.updated_at, a.first_name, a.last_name, b.first_name, b.last_name FROM contact_relationships r JOIN contacts a ON a.contact_id = r.contact_id JOIN contacts b ON b.contact_id = r.related_id WHERE a.deleted_at IS NULL AND b.deleted_at IS NULL AND r.user_id = ?"
+
+func scanStoredRelationship(row rowScanner, rel *storedRelationship) error {
+	return row.Scan(&rel.RelationshipId, &rel.ContactId, &rel.RelatedId, &rel.Type, &rel.Label, &rel.Bidirectional, &rel.CreatedAt, &rel.UpdatedAt, &rel.FirstName, &rel.LastName, &rel.RelFirstName, &rel.RelLastName)
+}
+
+// viewFrom - The link as seen from one of its contacts
+func (rel storedRelationship) viewFrom(contactId string) ContactRelationships {
+	view := ContactRelationships{
+		RelationshipId:   rel.RelationshipId,
+		ContactId:        rel.ContactId,
+		RelatedContactId: rel.RelatedId,
+		RelatedFirstName: rel.RelFirstName,
+		RelatedLastName:  rel.RelLastName,
+		Type:             rel.Type,
+		Label:            rel.Label,
+		Bidirectional:    rel.Bidirectional,
+		CreatedAt:        rel.CreatedAt,
+		UpdatedAt:        rel.UpdatedAt,
+	}
+	if contactId == rel.RelatedId && contactId != rel.ContactId {
+		view.ContactId, view.RelatedContactId = rel.RelatedId, rel.ContactId
+		view.RelatedFirstName, view.RelatedLastName = rel.FirstName, rel.LastName
+		view.Type = relationshipInverse[rel.Type]
+		view.Inverse = true
+	}
+	return view
+}
+
+// loadRelationshipsByContact - Every link visible from each contact: its
+// own and the bidirectional ones pointing at it
+func loadRelationshipsByContact(userId int64, contactIds []string) (map[string][]ContactRelationships, error) {
+	result := map[string][]ContactRelationships{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	clause, args := inClause(contactIds)
+	query := contactRelationshipQuery + " AND (r.contact_id IN " + clause + " OR (r.bidirectional = 1 AND r.related_id IN " + clause + ")) ORDER BY r.relationship_id"
+	rows, err := GetDB().Query(query, append(append([]any{userId}, args...), args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wanted := map[string]bool{}
+	for _, id := range contactIds {
+		wanted[id] = true
+	}
+	for rows.Next() {
+		var rel storedRelationship
+		if err := scanStoredRelationship(rows, &rel); err != nil {
+			return nil, err
+		}
+		// a link between two contacts of the page shows up on both
+		if wanted[rel.ContactId] {
+			result[rel.ContactId] = append(result[rel.ContactId], rel.viewFrom(rel.ContactId))
+		}
+		if rel.Bidirectional && wanted[rel.RelatedId] {
+			result[rel.RelatedId] = append(result[rel.RelatedId], rel.viewFrom(rel.RelatedId))
+		}
+	}
+	return result, rows.Err()
+}
+
+// findContactRelationship - A link visible from contactId
+func findContactRelationship(exec sqlExecutor, relationshipId string, contactId string, userId int64) (storedRelationship, error) {
+	var rel storedRelationship
+	err := scanStoredRelationship(exec.QueryRow(contactRelationshipQuery+" AND r.relationship_id = ? AND (r.contact_id = ? OR (r.bidirectional = 1 AND r.related_id = ?))", userId, relationshipId, contactId, contactId), &rel)
+	return rel, err
+}
+
+// relationshipTaken - Whether the same link already exists, either stored
+// the same way or as a bidirectional link from the other side. exceptId is
+// the link being updated.
+func relationshipTaken(exec sqlExecutor, contactId, relatedId, relType, label string, bidirectional bool, exceptId string) bool {
+	var count int
+	_ = exec.QueryRow("SELECT COUNT(*) FROM contact_relationships WHERE relationship_id <> ? AND ((contact_id = ? AND related_id = ? AND type = ? AND label = ?) OR (contact_id = ? AND related_id = ? AND type = ? AND label = ? AND (bidirectional = 1 OR ?)))",
+		exceptId, contactId, relatedId, relType, label, relatedId, contactId, relationshipInverse[relType], label, bidirectional).Scan(&count)
+	return count > 0
+}
+
+func readContactRelationshipRequest(w http.ResponseWriter, r *http.Request) (contactRelationshipRequest, bool) {
+	var req contactRelationshipRequest
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return req, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return req, false
+	}
+	// only custom links are named, so a link and its inverse always match
+	if req.Type != "custom" {
+		req.Label = ""
+	}
+	if req.Bidirectional == nil {
+		bidirectional := true
+		req.Bidirectional = &bidirectional
+	}
+	return req, true
+}
+
+// GetContactRelationships - Links of a contact, oldest first
+func GetContactRelationships(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	byContact, err := loadRelationshipsByContact(ctxUser.UserId, []string{ps.ByName("id")})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	relationships := byContact[ps.ByName("id")]
+	if relationships == nil {
+		relationships = []ContactRelationships{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    relationships,
+	})
+}
+
+func CreateContactRelationship(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readContactRelationshipRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.RelatedContactId == "" || req.RelatedContactId == ps.ByName("id") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"related_contact_id must be another contact"},
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", req.RelatedContactId, ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"related_contact_id must be one of your contacts"},
+		})
+		return
+	}
+
+	if relationshipTaken(db, ps.ByName("id"), req.RelatedContactId, req.Type, req.Label, *req.Bidirectional, "0") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Relationship already exists",
+		})
+		return
+	}
+
+	result, err := db.Exec("INSERT INTO contact_relationships (user_id, contact_id, related_id, type, label, bidirectional) VALUES (?, ?, ?, ?, ?, ?)", ctxUser.UserId, ps.ByName("id"), req.RelatedContactId, req.Type, req.Label, *req.Bidirectional)
+	var rel storedRelationship
+	if err == nil {
+		id, _ := result.LastInsertId()
+		rel, err = findContactRelationship(db, strconv.FormatInt(id, 10), ps.ByName("id"), ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Relationship created successfully",
+		"data":    rel.viewFrom(ps.ByName("id")),
+	})
+}
+
+func GetContactRelationshipId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	ctxUser := r.Context().Value("user").(Users)
+
+	rel, err := findContactRelationship(GetDB(), ps.ByName("relationshipId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Relationship not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    rel.viewFrom(ps.ByName("id")),
+	})
+}
+
+// UpdateContactRelationship - Change the type, label or direction of a
+// link, as seen from the contact in the URL
+func UpdateContactRelationship(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, ok := readContactRelationshipRequest(w, r)
+	if !ok {
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rel, err := findContactRelationship(db, ps.ByName("relationshipId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Relationship not found",
+		})
+		return
+	}
+
+	// a link edited from the related contact is stored the other way round
+	relType := req.Type
+	if rel.viewFrom(ps.ByName("id")).Inverse {
+		relType = relationshipInverse[req.Type]
+	}
+	if relationshipTaken(db, rel.ContactId, rel.RelatedId, relType, req.Label, *req.Bidirectional, rel.RelationshipId) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Relationship already exists",
+		})
+		return
+	}
+
+	_, err = db.Exec("UPDATE contact_relationships SET type = ?, label = ?, bidirectional = ? WHERE relationship_id = ?", relType, req.Label, *req.Bidirectional, rel.RelationshipId)
+	if err == nil {
+		rel, err = findContactRelationship(db, rel.RelationshipId, rel.ContactId, ctxUser.UserId)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Relationship updated successfully",
+		"data":    rel.viewFrom(ps.ByName("id")),
+	})
+}
+
+// DeleteContactRelationship - Remove a link; either side may delete it
+func DeleteContactRelationship(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rel, err := findContactRelationship(db, ps.ByName("relationshipId"), ps.ByName("id"), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Relationship not found",
+		})
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM contact_relationships WHERE relationship_id = ?", rel.RelationshipId); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Relationship deleted successfully",
+	})
+}
+
+// mergeRelationships - Repoint the merged contact's links to the survivor,
+// except links between the two and ones the survivor already has. Returns
+// the ids moved on each side for undo.
+func mergeRelationships(tx *sql.Tx, survivorId, mergedId string) ([]string, []string, error) {
+	owned, err := queryIds(tx, "SELECT relationship_id FROM contact_relationships r WHERE contact_id = ? AND related_id <> ? AND NOT EXISTS (SELECT 1 FROM contact_relationships s WHERE s.contact_id = ? AND s.related_id = r.related_id AND s.type = r.type AND s.label = r.label)", mergedId, survivorId, survivorId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := execIn(tx, "UPDATE contact_relationships SET contact_id = ? WHERE relationship_id IN", owned, survivorId); err != nil {
+		return nil, nil, err
+	}
+	related, err := queryIds(tx, "SELECT relationship_id FROM contact_relationships r WHERE related_id = ? AND contact_id <> ? AND NOT EXISTS (SELECT 1 FROM contact_relationships s WHERE s.related_id = ? AND s.contact_id = r.contact_id AND s.type = r.type AND s.label = r.label)", mergedId, survivorId, survivorId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := execIn(tx, "UPDATE contact_relationships SET related_id = ? WHERE relationship_id IN", related, survivorId); err != nil {
+		return nil, nil, err
+	}
+	return owned, related, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestContactETagCoversRelationships(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "relationships@example.com")
+	contactId := createTestContact(t, user, "Budi")
+	relatedId := createTestContact(t, user, "Siti")
+
+	router := httprouter.New()
+	router.GET("/contact/:id", AuthMiddleware(GetContactId))
+	router.POST("/contact/:id/relationships", AuthMiddleware(CreateContactRelationship))
+	router.PUT("/contact/:id/relationships/:relationshipId", AuthMiddleware(UpdateContactRelationship))
+	router.DELETE("/contact/:id/relationships/:relationshipId", AuthMiddleware(DeleteContactRelationship))
+
+	// the link shows on both sides, both ETags have to move
+	for _, id := range []string{contactId, relatedId} {
+		path := "/contact/" + id + "?include=relationships"
+
+		var relationshipId string
+		checkETagChanges(t, router, user, path, func() {
+			code, body := serveJSON(t, router, "POST", "/contact/"+contactId+"/relationships", user, `{"related_contact_id": "`+relatedId+`", "type": "sibling"}`)
+			if code != http.StatusCreated {
+				t.Fatalf("create relationship: got %d %v", code, body)
+			}
+			relationshipId = body["data"].(map[string]any)["relationship_id"].(string)
+		})
+		checkETagChanges(t, router, user, path, func() {
+			if code, body := serveJSON(t, router, "PUT", "/contact/"+contactId+"/relationships/"+relationshipId, user, `{"type": "friend"}`); code != http.StatusOK {
+				t.Fatalf("update relationship: got %d %v", code, body)
+			}
+		})
+		checkETagChanges(t, router, user, path, func() {
+			if code, body := serveJSON(t, router, "DELETE", "/contact/"+contactId+"/relationships/"+relationshipId, user, ""); code != http.StatusOK {
+				t.Fatalf("delete relationship: got %d %v", code, body)
+			}
+		})
+	}
+}
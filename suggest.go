@@ -0,0 +1,330 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	suggestDefaultLimit = 10
+	suggestMaxLimit     = 20
+	// suggestMaxKeyDepth bounds how deep a single key is indexed; prefixes
+	// longer than this are looked up at this depth and the entries found
+	// there are checked against the whole prefix.
+	suggestMaxKeyDepth = 32
+)
+
+type suggestEntry struct {
+	ContactId string `json:"contact_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+}
+
+// suggestNode keeps at most suggestMaxLimit entries, already in display
+// order, so a lookup never has to walk the subtree.
+type suggestNode struct {
+	children map[rune]*suggestNode
+	entries  []int32
+}
+
+type suggestIndex struct {
+	root     *suggestNode
+	contacts []suggestEntry
+	nodes    int
+}
+
+type suggestCacheItem struct {
+	userId int64
+	index  *suggestIndex
+}
+
+// suggestCache - LRU of per-user prefix indexes, bounded by total node count
+type suggestCache struct {
+	mu       sync.Mutex
+	items    map[int64]*list.Element
+	order    *list.List
+	nodes    int
+	maxNodes int
+	epoch    uint64
+}
+
+var contactSuggest = newSuggestCache()
+
+func newSuggestCache() *suggestCache {
+	maxNodes, err := strconv.Atoi(getEnv("SUGGEST_CACHE_MAX_NODES", "2000000"))
+	if err != nil || maxNodes <= 0 {
+		maxNodes = 2000000
+	}
+	return &suggestCache{
+		items:    map[int64]*list.Element{},
+		order:    list.New(),
+		maxNodes: maxNodes,
+	}
+}
+
+func (c *suggestCache) get(userId int64) (*suggestIndex, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[userId]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*suggestCacheItem).index, c.epoch
+	}
+	return nil, c.epoch
+}
+
+// put stores an index built while the cache was at the given epoch. If any
+// invalidation happened in the meantime the index may be stale and is dropped.
+func (c *suggestCache) put(userId int64, index *suggestIndex, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch != c.epoch || index.nodes > c.maxNodes {
+		return
+	}
+	if el, ok := c.items[userId]; ok {
+		c.removeElement(el)
+	}
+	c.items[userId] = c.order.PushFront(&suggestCacheItem{userId: userId, index: index})
+	c.nodes += index.nodes
+	for c.nodes > c.maxNodes {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *suggestCache) invalidate(userId int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	if el, ok := c.items[userId]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *suggestCache) removeElement(el *list.Element) {
+	item := el.Value.(*suggestCacheItem)
+	c.order.Remove(el)
+	delete(c.items, item.userId)
+	c.nodes -= item.index.nodes
+}
+
+// invalidateContactSuggest - Drop the cached prefix index after a contact write
+func invalidateContactSuggest(userId int64) {
+	contactSuggest.invalidate(userId)
+}
+
+func buildSuggestIndex(contacts []suggestEntry) *suggestIndex {
+	sort.SliceStable(contacts, func(i, j int) bool {
+		a := strings.ToLower(contacts[i].FirstName + " " + contacts[i].LastName)
+		b := strings.ToLower(contacts[j].FirstName + " " + contacts[j].LastName)
+		return a < b
+	})
+
+	index := &suggestIndex{root: &suggestNode{}, contacts: contacts, nodes: 1}
+	for i, contact := range contacts {
+		for _, key := range contact.keys() {
+			index.insert(key, int32(i))
+		}
+	}
+	return index
+}
+
+// keys - Everything a contact can be found by
+func (contact suggestEntry) keys() []string {
+	keys := []string{
+		strings.ToLower(contact.FirstName),
+		strings.ToLower(contact.LastName),
+		strings.ToLower(contact.FirstName + " " + contact.LastName),
+		strings.ToLower(contact.Email),
+		phoneDigits(contact.Phone),
+	}
+	// E.164 numbers are also found by their national form ("0812...")
+	if national, _, ok := phoneDisplay(contact.Phone); ok {
+		keys = append(keys, phoneDigits(national))
+	}
+	return keys
+}
+
+func (contact suggestEntry) hasPrefix(prefix string) bool {
+	for _, key := range contact.keys() {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (idx *suggestIndex) insert(key string, id int32) {
+	node := idx.root
+	depth := 0
+	for _, r := range key {
+		if depth == suggestMaxKeyDepth {
+			break
+		}
+		child, ok := node.children[r]
+		if !ok {
+			if node.children == nil {
+				node.children = map[rune]*suggestNode{}
+			}
+			child = &suggestNode{}
+			node.children[r] = child
+			idx.nodes++
+		}
+		node = child
+		depth++
+		if len(node.entries) < suggestMaxLimit && !containsEntry(node.entries, id) {
+			node.entries = append(node.entries, id)
+		}
+	}
+}
+
+func (idx *suggestIndex) lookup(prefix string, limit int) []suggestEntry {
+	node := idx.root
+	depth := 0
+	truncated := false
+	for _, r := range prefix {
+		if depth == suggestMaxKeyDepth {
+			truncated = true
+			break
+		}
+		child, ok := node.children[r]
+		if !ok {
+			return []suggestEntry{}
+		}
+		node = child
+		depth++
+	}
+
+	result := []suggestEntry{}
+	for _, id := range node.entries {
+		if len(result) == limit {
+			break
+		}
+		// the node only knows the first suggestMaxKeyDepth characters
+		if truncated && !idx.contacts[id].hasPrefix(prefix) {
+			continue
+		}
+		result = append(result, idx.contacts[id])
+	}
+	return result
+}
+
+func containsEntry(entries []int32, id int32) bool {
+	for _, e := range entries {
+		if e == id {
+			return true
+		}
+	}
+	return false
+}
+
+func phoneDigits(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// suggestKey normalizes the query the same way keys are indexed; anything
+// that only looks like a phone number is reduced to its digits.
+func suggestKey(prefix string) string {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	phoneLike := prefix != ""
+	for _, r := range prefix {
+		if !unicode.IsDigit(r) && !strings.ContainsRune("+-() .", r) {
+			phoneLike = false
+			break
+		}
+	}
+	if phoneLike {
+		return phoneDigits(prefix)
+	}
+	return prefix
+}
+
+func loadSuggestIndex(userId int64) (*suggestIndex, error) {
+	index, epoch := contactSuggest.get(userId)
+	if index != nil {
+		return index, nil
+	}
+
+	db := GetDB()
+
+	rows, err := db.Query("SELECT contact_id, first_name, last_name, email, phone FROM contacts WHERE user_id = ? AND deleted_at IS NULL", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []suggestEntry{}
+	for rows.Next() {
+		var contact suggestEntry
+		if err := rows.Scan(&contact.ContactId, &contact.FirstName, &contact.LastName, &contact.Email, &contact.Phone); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, contact)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	index = buildSuggestIndex(contacts)
+	contactSuggest.put(userId, index, epoch)
+	return index, nil
+}
+
+func SuggestContacts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	prefix := suggestKey(r.URL.Query().Get("prefix"))
+	if prefix == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"prefix is required"},
+		})
+		return
+	}
+
+	limit := suggestDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > suggestMaxLimit {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]any{
+				"errors": []string{"limit must be between 1 and " + strconv.Itoa(suggestMaxLimit)},
+			})
+			return
+		}
+		limit = n
+	}
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	index, err := loadSuggestIndex(ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    index.lookup(prefix, limit),
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// keys are only indexed up to suggestMaxKeyDepth characters, a longer prefix
+// must still match on all of it
+func TestSuggestLongPrefix(t *testing.T) {
+	shared := strings.Repeat("a", suggestMaxKeyDepth)
+	index := buildSuggestIndex([]suggestEntry{
+		{ContactId: "1", FirstName: "Budi", LastName: "Santoso", Email: shared + "budi@example.com", Phone: "+6281234567890"},
+		{ContactId: "2", FirstName: "Siti", LastName: "Rahayu", Email: shared + "siti@example.com", Phone: "+6281298765432"},
+	})
+
+	for _, tc := range []struct {
+		prefix string
+		want   []string
+	}{
+		{shared, []string{"1", "2"}},
+		{shared + "b", []string{"1"}},
+		{shared + "siti@", []string{"2"}},
+		{shared + "x", []string{}},
+		{"budi s", []string{"1"}},
+		{"0812987", []string{"2"}},
+	} {
+		got := []string{}
+		for _, contact := range index.lookup(tc.prefix, suggestMaxLimit) {
+			got = append(got, contact.ContactId)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("lookup(%q) = %v, want %v", tc.prefix, got, tc.want)
+		}
+	}
+}
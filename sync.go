@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	syncTokenSort = "sync"
+
+	syncDefaultLimit = 500
+	syncMaxLimit     = 1000
+)
+
+// recordSyncChanges - Give the entities the next numbers of the user's
+// change sequence. The user's sync_sequences row stays locked until the
+// transaction commits, so a number is never visible before the ones below
+// it. Call it in the transaction that made the change.
+func recordSyncChanges(exec sqlExecutor, userId int64, entity string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	result, err := exec.Exec("INSERT INTO sync_sequences (user_id, seq) VALUES (?, LAST_INSERT_ID(?)) ON DUPLICATE KEY UPDATE seq = LAST_INSERT_ID(seq + ?)", userId, len(ids), len(ids))
+	if err != nil {
+		return err
+	}
+	last, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	values := make([]string, len(ids))
+	args := []any{}
+	for i, id := range ids {
+		values[i] = "(?, ?, ?, ?)"
+		args = append(args, userId, entity, id, last-int64(len(ids)-1-i))
+	}
+	_, err = exec.Exec("INSERT INTO sync_changes (user_id, entity, entity_id, seq) VALUES "+strings.Join(values, ", ")+" ON DUPLICATE KEY UPDATE seq = VALUES(seq)", args...)
+	return err
+}
+
+func encodeSyncToken(seq int64) string {
+	b, _ := json.Marshal(contactCursor{Sort: syncTokenSort, Values: []string{strconv.FormatInt(seq, 10)}})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSyncToken(v string) (int64, error) {
+	cursor, err := decodeContactCursor(v)
+	if err != nil {
+		return 0, err
+	}
+	if cursor.Sort != syncTokenSort || len(cursor.Values) != 1 {
+		return 0, fmt.Errorf("not a sync token")
+	}
+	seq, err := strconv.ParseInt(cursor.Values[0], 10, 64)
+	if err == nil && seq < 0 {
+		err = fmt.Errorf("negative sequence")
+	}
+	return seq, err
+}
+
+type syncChange struct {
+	Entity   string
+	EntityId string
+	Seq      int64
+}
+
+// loadSyncContacts - The current state of the contacts, keyed by contact_id.
+// Purged contacts are missing.
+func loadSyncContacts(tx *sql.Tx, userId int64, ids []string) (map[string]Contacts, error) {
+	result := map[string]Contacts{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	clause, args := inClause(ids)
+	rows, err := tx.Query("SELECT "+contactColumns+" FROM contacts WHERE user_id = ? AND contact_id IN "+clause, append([]any{userId}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contact Contacts
+		if err := scanContact(rows, &contact); err != nil {
+			return nil, err
+		}
+		result[contact.ContactId] = contact
+	}
+	return result, rows.Err()
+}
+
+// loadSyncAddresses - The current state of the user's contact addresses,
+// keyed by address_id. Purged addresses are missing.
+func loadSyncAddresses(tx *sql.Tx, userId int64, ids []string) (map[string]Addresses, error) {
+	result := map[string]Addresses{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	clause, args := inClause(ids)
+	rows, err := tx.Query("SELECT "+addressColumns+" FROM addresses WHERE address_id IN "+clause+" AND contact_id IN (SELECT contact_id FROM contacts WHERE user_id = ?)", append(args, userId)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var address Addresses
+		if err := scanAddress(rows, &address); err != nil {
+			return nil, err
+		}
+		result[address.AddressId] = address
+	}
+	return result, rows.Err()
+}
+
+// GetSync - GET /sync?since=<token>. Everything about the user's contacts
+// and their addresses that changed after the token, oldest change first:
+// live ones in full, trashed and purged ones as tombstones (ids only).
+// Without since every live contact and address is returned. Keep calling
+// with next_token while has_more is true.
+func GetSync(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	values := r.URL.Query()
+	limit := syncDefaultLimit
+	var since int64
+	errMsgs := []string{}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > syncMaxLimit {
+			errMsgs = append(errMsgs, fmt.Sprintf("limit must be between 1 and %d", syncMaxLimit))
+		}
+		limit = n
+	}
+	if v := values.Get("since"); v != "" {
+		seq, err := decodeSyncToken(v)
+		if err != nil {
+			errMsgs = append(errMsgs, "since is not a valid sync token")
+		}
+		since = seq
+	}
+	if len(errMsgs) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	// one snapshot for the change list and the rows it points at
+	tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	changes := []syncChange{}
+	rows, err := tx.Query("SELECT entity, entity_id, seq FROM sync_changes WHERE user_id = ? AND seq > ? ORDER BY seq LIMIT "+strconv.Itoa(limit+1), ctxUser.UserId, since)
+	if err == nil {
+		for rows.Next() {
+			var change syncChange
+			if err = rows.Scan(&change.Entity, &change.EntityId, &change.Seq); err != nil {
+				break
+			}
+			changes = append(changes, change)
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+	}
+
+	hasMore := len(changes) > limit
+	if hasMore {
+		changes = changes[:limit]
+	}
+
+	contactIds, addressIds := []string{}, []string{}
+	for _, change := range changes {
+		if change.Entity == "contact" {
+			contactIds = append(contactIds, change.EntityId)
+		} else {
+			addressIds = append(addressIds, change.EntityId)
+		}
+	}
+
+	var contacts map[string]Contacts
+	var addresses map[string]Addresses
+	if err == nil {
+		contacts, err = loadSyncContacts(tx, ctxUser.UserId, contactIds)
+	}
+	if err == nil {
+		addresses, err = loadSyncAddresses(tx, ctxUser.UserId, addressIds)
+	}
+
+	live := []Contacts{}
+	liveAddresses := []Addresses{}
+	deletedContacts, deletedAddresses := []string{}, []string{}
+	for _, change := range changes {
+		if change.Entity == "contact" {
+			if contact, ok := contacts[change.EntityId]; ok && contact.DeletedAt == nil {
+				live = append(live, contact)
+			} else if since > 0 {
+				deletedContacts = append(deletedContacts, change.EntityId)
+			}
+			continue
+		}
+		if address, ok := addresses[change.EntityId]; ok && address.DeletedAt == nil {
+			liveAddresses = append(liveAddresses, address)
+		} else if since > 0 {
+			deletedAddresses = append(deletedAddresses, change.EntityId)
+		}
+	}
+	if err == nil {
+		err = attachCustomFields(live)
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	next := since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data": map[string]any{
+			"contacts":          live,
+			"addresses":         liveAddresses,
+			"deleted_contacts":  deletedContacts,
+			"deleted_addresses": deletedAddresses,
+		},
+		"next_token": encodeSyncToken(next),
+		"has_more":   hasMore,
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestGetSync(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "sync@example.com")
+	other := createTestUser(t, "other@example.com")
+
+	router := httprouter.New()
+	router.POST("/contact", AuthMiddleware(CreateContact))
+	router.PUT("/contact/:id", AuthMiddleware(UpdateContact))
+	router.DELETE("/contact/:id", AuthMiddleware(DeleteContact))
+	router.GET("/sync", AuthMiddleware(GetSync))
+
+	create := func(as Users, name string) string {
+		t.Helper()
+		code, body := serveJSON(t, router, "POST", "/contact", as, `{"first_name": "`+name+`", "last_name": "Test", "email": "`+name+`@example.com", "phone": "081234567890"}`)
+		if code != http.StatusCreated {
+			t.Fatalf("create %s: got %d %v", name, code, body)
+		}
+		return body["data"].(map[string]any)["contact_id"].(string)
+	}
+	sync := func(query url.Values) (contacts []string, deleted []string, next string, more bool) {
+		t.Helper()
+		code, body := serveJSON(t, router, "GET", "/sync?"+query.Encode(), user, "")
+		if code != http.StatusOK {
+			t.Fatalf("GET /sync?%s: got %d %v", query.Encode(), code, body)
+		}
+		data := body["data"].(map[string]any)
+		for _, c := range data["contacts"].([]any) {
+			contacts = append(contacts, c.(map[string]any)["contact_id"].(string))
+		}
+		for _, id := range data["deleted_contacts"].([]any) {
+			deleted = append(deleted, id.(string))
+		}
+		return contacts, deleted, body["next_token"].(string), body["has_more"].(bool)
+	}
+
+	ani, budi, citra := create(user, "Ani"), create(user, "Budi"), create(user, "Citra")
+	create(other, "Dewi")
+
+	contacts, deleted, token, more := sync(url.Values{})
+	if !slices.Equal(contacts, []string{ani, budi, citra}) || len(deleted) != 0 || more {
+		t.Fatalf("full sync: got %v, deleted %v, has_more %v", contacts, deleted, more)
+	}
+
+	// budi changes twice and shows up once, at its latest change after citra
+	for _, lastName := range []string{"Santoso", "Wijaya"} {
+		if code, body := serveJSON(t, router, "PUT", "/contact/"+budi, user, `{"first_name": "Budi", "last_name": "`+lastName+`", "email": "budi@example.com", "phone": "081234567890"}`); code != http.StatusOK {
+			t.Fatalf("update: got %d %v", code, body)
+		}
+	}
+	if code, body := serveJSON(t, router, "DELETE", "/contact/"+ani, user, ""); code != http.StatusOK {
+		t.Fatalf("delete: got %d %v", code, body)
+	}
+	eka := create(user, "Eka")
+
+	contacts, deleted, next, more := sync(url.Values{"since": {token}})
+	if !slices.Equal(contacts, []string{budi, eka}) || !slices.Equal(deleted, []string{ani}) || more {
+		t.Errorf("changes since the full sync: got %v, deleted %v, has_more %v; want [%s %s], deleted [%s]", contacts, deleted, more, budi, eka, ani)
+	}
+
+	// page by page, in the order of the changes
+	contacts, deleted, page, more := sync(url.Values{"since": {token}, "limit": {"2"}})
+	if !slices.Equal(contacts, []string{budi}) || !slices.Equal(deleted, []string{ani}) || !more {
+		t.Errorf("first page: got %v, deleted %v, has_more %v", contacts, deleted, more)
+	}
+	contacts, deleted, page, more = sync(url.Values{"since": {page}, "limit": {"2"}})
+	if !slices.Equal(contacts, []string{eka}) || len(deleted) != 0 || more || page != next {
+		t.Errorf("second page: got %v, deleted %v, has_more %v", contacts, deleted, more)
+	}
+
+	if contacts, deleted, again, _ := sync(url.Values{"since": {next}}); len(contacts) != 0 || len(deleted) != 0 || again != next {
+		t.Errorf("sync without changes: got %v, deleted %v, token moved %v", contacts, deleted, again != next)
+	}
+
+	for _, query := range []string{"since=garbage", "since=" + encodeSyncToken(-1), "limit=0"} {
+		if code, body := serveJSON(t, router, "GET", "/sync?"+query, user, ""); code != http.StatusBadRequest {
+			t.Errorf("GET /sync?%s: got %d %v, want 400", query, code, body)
+		}
+	}
+}
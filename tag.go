@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/go-sql-driver/mysql"
+	"github.com/julienschmidt/httprouter"
+)
+
+type Tags struct {
+	TagId        int64   `json:"tag_id"`
+	Name         string  `json:"name" validate:"required,max=50,excludesall=0x2C"`
+	ContactCount *int64  `json:"contact_count,omitempty"`
+	CreatedAt    *string `json:"created_at,omitempty"`
+	UpdatedAt    *string `json:"updated_at,omitempty"`
+}
+
+type contactTagsRequest struct {
+	Tags []string `json:"tags" validate:"dive,required,max=50,excludesall=0x2C"`
+}
+
+const tagColumns = "tag_id, name, created_at, updated_at"
+
+// normalizeTagNames - Trim and drop duplicates, keeping the first spelling
+func normalizeTagNames(names []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		key := strings.ToLower(name)
+		if name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, name)
+	}
+	return result
+}
+
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+// loadTagsByContact - Tags assigned to many contacts in one query, keyed by
+// contact_id. Callers must have already scoped contactIds to the user.
+func loadTagsByContact(contactIds []string) (map[string][]Tags, error) {
+	result := map[string][]Tags{}
+	if len(contactIds) == 0 {
+		return result, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(contactIds)-1) + "?"
+	args := make([]any, len(contactIds))
+	for i, id := range contactIds {
+		args[i] = id
+	}
+
+	rows, err := GetDB().Query("SELECT ct.contact_id, t.tag_id, t.name FROM contact_tags ct JOIN tags t ON t.tag_id = ct.tag_id WHERE ct.contact_id IN ("+placeholders+") ORDER BY t.name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var contactId string
+		var tag Tags
+		if err := rows.Scan(&contactId, &tag.TagId, &tag.Name); err != nil {
+			return nil, err
+		}
+		result[contactId] = append(result[contactId], tag)
+	}
+	return result, rows.Err()
+}
+
+// assignContactTags - Attach tags by name, creating the ones the user doesn't
+// have yet. With replace the contact ends up with exactly these tags.
+func assignContactTags(exec sqlExecutor, userId int64, contactId string, names []string, replace bool) error {
+	if replace {
+		if _, err := exec.Exec("DELETE FROM contact_tags WHERE contact_id = ?", contactId); err != nil {
+			return err
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	values := strings.Repeat("(?, ?), ", len(names)-1) + "(?, ?)"
+	args := []any{}
+	for _, name := range names {
+		args = append(args, userId, name)
+	}
+	if _, err := exec.Exec("INSERT IGNORE INTO tags (user_id, name) VALUES "+values, args...); err != nil {
+		return err
+	}
+
+	placeholders := strings.Repeat("?, ", len(names)-1) + "?"
+	args = []any{contactId, userId}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	_, err := exec.Exec("INSERT IGNORE INTO contact_tags (contact_id, tag_id) SELECT ?, tag_id FROM tags WHERE user_id = ? AND name IN ("+placeholders+")", args...)
+	return err
+}
+
+func CreateTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var tag Tags
+	if err := json.NewDecoder(r.Body).Decode(&tag); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	tag.Name = strings.TrimSpace(tag.Name)
+
+	validate := validator.New()
+	if err := validate.Struct(tag); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	result, err := db.Exec("INSERT INTO tags (user_id, name) VALUES (?, ?)", ctxUser.UserId, tag.Name)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Tag already exists",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	tag.TagId, _ = result.LastInsertId()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Tag created successfully",
+		"data":    tag,
+	})
+}
+
+func GetTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	rows, err := db.Query(`SELECT t.tag_id, t.name, t.created_at, t.updated_at,
+		(SELECT COUNT(*) FROM contact_tags ct JOIN contacts c ON c.contact_id = ct.contact_id WHERE ct.tag_id = t.tag_id AND c.deleted_at IS NULL)
+		FROM tags t WHERE t.user_id = ? ORDER BY t.name`, ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	tags := []Tags{}
+	for rows.Next() {
+		var tag Tags
+		if err := rows.Scan(&tag.TagId, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt, &tag.ContactCount); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    tags,
+	})
+}
+
+func GetTagId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var tag Tags
+	err := db.QueryRow(`SELECT t.tag_id, t.name, t.created_at, t.updated_at,
+		(SELECT COUNT(*) FROM contact_tags ct JOIN contacts c ON c.contact_id = ct.contact_id WHERE ct.tag_id = t.tag_id AND c.deleted_at IS NULL)
+		FROM tags t WHERE t.tag_id = ? AND t.user_id = ?`, ps.ByName("id"), ctxUser.UserId).Scan(&tag.TagId, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt, &tag.ContactCount)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Tag not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Success",
+		"data":    tag,
+	})
+}
+
+// UpdateTag - Rename a tag. Assignments reference the tag_id, so every
+// contact carrying it shows the new name.
+func UpdateTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var tag Tags
+	if err := json.NewDecoder(r.Body).Decode(&tag); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	tag.Name = strings.TrimSpace(tag.Name)
+
+	validate := validator.New()
+	if err := validate.Struct(tag); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM tags WHERE tag_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Tag not found",
+		})
+		return
+	}
+
+	_, err := db.Exec("UPDATE tags SET name = ? WHERE tag_id = ? AND user_id = ?", tag.Name, ps.ByName("id"), ctxUser.UserId)
+	if isDuplicateEntry(err) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(409)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Tag already exists",
+		})
+		return
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	_ = db.QueryRow("SELECT "+tagColumns+" FROM tags WHERE tag_id = ?", ps.ByName("id")).Scan(&tag.TagId, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Tag updated successfully",
+		"data":    tag,
+	})
+}
+
+// DeleteTag - Remove a tag together with all of its assignments
+func DeleteTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM tags WHERE tag_id = ? AND user_id = ?", ps.ByName("id"), ctxUser.UserId)
+	var deleted int64
+	if err == nil {
+		deleted, _ = result.RowsAffected()
+		if deleted > 0 {
+			_, err = tx.Exec("DELETE FROM contact_tags WHERE tag_id = ?", ps.ByName("id"))
+		}
+	}
+	if err == nil && deleted > 0 {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if deleted == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Tag not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Tag deleted successfully",
+	})
+}
+
+// AddContactTags - POST adds the given tags, PUT replaces the contact's tags
+func AddContactTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeContactTags(w, r, ps, false)
+}
+
+func SetContactTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	writeContactTags(w, r, ps, true)
+}
+
+func writeContactTags(w http.ResponseWriter, r *http.Request, ps httprouter.Params, replace bool) {
+	if r.Body == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+
+	var req contactTagsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Input tidak valid.",
+		})
+		return
+	}
+	req.Tags = normalizeTagNames(req.Tags)
+
+	validate := validator.New()
+	if err := validate.Struct(req); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": validationMessages(err),
+		})
+		return
+	}
+	if !replace && len(req.Tags) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"tags is required"},
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err == nil {
+		defer tx.Rollback()
+		err = assignContactTags(tx, ctxUser.UserId, ps.ByName("id"), req.Tags, replace)
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	var tags map[string][]Tags
+	if err == nil {
+		tags, err = loadTagsByContact([]string{ps.ByName("id")})
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	data := tags[ps.ByName("id")]
+	if data == nil {
+		data = []Tags{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Contact tags updated successfully",
+		"data":    data,
+	})
+}
+
+func RemoveContactTag(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM contact_tags WHERE contact_id = ? AND tag_id = ?", ps.ByName("id"), ps.ByName("tagId"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Tag is not assigned to this contact",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Tag removed from contact",
+	})
+}
+
+// addTagFilter - tag=a,b on the contact listing. any matches contacts with
+// at least one of the tags, all only those carrying every one of them.
+func (q *contactListQuery) addTagFilter(names []string, mode string) error {
+	names = normalizeTagNames(names)
+	if len(names) == 0 {
+		return fmt.Errorf("tag must not be empty")
+	}
+
+	placeholders := strings.Repeat("?, ", len(names)-1) + "?"
+	args := []any{q.UserId}
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	subquery := "SELECT ct.contact_id FROM contact_tags ct JOIN tags t ON t.tag_id = ct.tag_id WHERE t.user_id = ? AND t.name IN (" + placeholders + ")"
+	switch mode {
+	case "", "any":
+	case "all":
+		subquery += " GROUP BY ct.contact_id HAVING COUNT(DISTINCT t.tag_id) = ?"
+		args = append(args, len(names))
+	default:
+		return fmt.Errorf("tag_mode must be any or all")
+	}
+	q.addFilter("contact_id IN ("+subquery+")", args...)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestCreateTag(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "tag@example.com")
+
+	router := httprouter.New()
+	router.POST("/tag", AuthMiddleware(CreateTag))
+	router.PUT("/tag/:id", AuthMiddleware(UpdateTag))
+
+	code, response := serveJSON(t, router, "POST", "/tag", user, `{"name": " Family "}`)
+	if code != 201 {
+		t.Fatalf("create: got %d %v", code, response)
+	}
+	data := response["data"].(map[string]any)
+	if data["name"] != "Family" {
+		t.Errorf("create: got name %v, want Family", data["name"])
+	}
+
+	code, response = serveJSON(t, router, "POST", "/tag", user, `{"name": "family, friends"}`)
+	if code != 400 {
+		t.Fatalf("create with comma: got %d %v", code, response)
+	}
+	if errs := fmt.Sprint(response["errors"]); errs != "[Name is excludesall]" {
+		t.Errorf("create with comma: got errors %s", errs)
+	}
+
+	path := fmt.Sprintf("/tag/%v", data["tag_id"])
+	if code, response = serveJSON(t, router, "PUT", path, user, `{"name": "Friends"}`); code != 200 {
+		t.Errorf("update: got %d %v", code, response)
+	}
+	if code, response = serveJSON(t, router, "PUT", path, user, `{"name": "a,b"}`); code != 400 {
+		t.Errorf("update with comma: got %d %v", code, response)
+	}
+}
+
+func TestWriteContactTags(t *testing.T) {
+	openTestDB(t)
+	user := createTestUser(t, "contact-tag@example.com")
+
+	result, err := GetDB().Exec("INSERT INTO contacts (first_name, last_name, email, phone, user_id) VALUES ('Budi', 'Santoso', 'budi@example.com', '+6281234567890', ?)", user.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contactId, _ := result.LastInsertId()
+	path := fmt.Sprintf("/contact/%d/tags", contactId)
+
+	router := httprouter.New()
+	router.POST("/contact/:id/tags", AuthMiddleware(AddContactTags))
+	router.PUT("/contact/:id/tags", AuthMiddleware(SetContactTags))
+
+	code, response := serveJSON(t, router, "POST", path, user, `{"tags": ["work", "Work", "vip"]}`)
+	if code != 200 {
+		t.Fatalf("add: got %d %v", code, response)
+	}
+	if n := len(response["data"].([]any)); n != 2 {
+		t.Errorf("add: got %d tags, want 2", n)
+	}
+
+	if code, response = serveJSON(t, router, "PUT", path, user, `{"tags": ["work,vip"]}`); code != 400 {
+		t.Errorf("set with comma: got %d %v", code, response)
+	}
+	if code, response = serveJSON(t, router, "POST", path, user, `{"tags": ["ok", "x,y"]}`); code != 400 {
+		t.Errorf("add with comma: got %d %v", code, response)
+	}
+
+	code, response = serveJSON(t, router, "PUT", path, user, `{"tags": []}`)
+	if code != 200 || len(response["data"].([]any)) != 0 {
+		t.Errorf("clear: got %d %v", code, response)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gmssql "github.com/dolthub/go-mysql-server/sql"
+)
+
+// openTestDB - Start an in-memory MySQL server, load testdata/schema.sql and
+// migrations/*.sql into it and point GetDB at it for the rest of the test.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	database := memory.NewDatabase("contact_management")
+	database.BaseDatabase.EnablePrimaryKeyIndexes()
+	provider := memory.NewDBProvider(database)
+	engine := sqle.NewDefault(provider)
+
+	srv, err := server.NewServer(server.Config{Protocol: "tcp", Address: "127.0.0.1:0"}, engine, gmssql.NewContext, memory.NewSessionBuilder(provider), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+
+	conn, err := sql.Open("mysql", "root@tcp("+srv.Listener.Addr().String()+")/contact_management?parseTime=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob("migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range append([]string{"testdata/schema.sql"}, files...) {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, stmt := range strings.Split(string(b), ";\n") {
+			if strings.TrimSpace(stmt) == "" {
+				continue
+			}
+			if _, err := conn.Exec(stmt); err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+		}
+	}
+
+	previous := db
+	db = conn
+	t.Cleanup(func() {
+		db = previous
+		conn.Close()
+		srv.Close()
+	})
+	return conn
+}
+
+// setTestTimeZone - Run the test server's sessions in another time zone.
+// The setting is global to the process, so it is reset afterwards.
+func setTestTimeZone(t *testing.T, tz string) {
+	t.Helper()
+
+	if _, err := GetDB().Exec("SET GLOBAL time_zone = ?", tz); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { GetDB().Exec("SET GLOBAL time_zone = 'SYSTEM'") })
+	// sessions opened before the change keep their time zone
+	GetDB().SetMaxIdleConns(0)
+}
+
+// createTestUser - A user whose API token is its email address
+func createTestUser(t *testing.T, email string) Users {
+	t.Helper()
+
+	result, err := GetDB().Exec("INSERT INTO users (name, email, password, token) VALUES (?, ?, '', ?)", "Test", email, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Users{UserId: id, Name: "Test", Email: email}
+}
+
+// createTestContact - A contact with a valid E.164 phone number
+func createTestContact(t *testing.T, user Users, firstName string) string {
+	t.Helper()
+
+	result, err := GetDB().Exec("INSERT INTO contacts (first_name, last_name, email, phone, user_id) VALUES (?, 'Test', ?, '+6281234567890', ?)", firstName, strings.ToLower(firstName)+"@example.com", user.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// createTestAddress - An address in Bandung for the contact
+func createTestAddress(t *testing.T, contactId string) string {
+	t.Helper()
+
+	result, err := GetDB().Exec("INSERT INTO addresses (street, city, province, country, postal_code, contact_id) VALUES ('Jl. Merdeka 1', 'Bandung', 'Jawa Barat', 'Indonesia', '40111', ?)", contactId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// serve - Send body through the router with the user's token and the
+// given headers
+func serve(t *testing.T, router http.Handler, method, path string, user Users, body string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	r.Header.Set("Authorization", user.Email)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// checkETagChanges - change has to give path a new ETag: the old one no
+// longer answers 304, the new one does
+func checkETagChanges(t *testing.T, router http.Handler, user Users, path string, change func()) {
+	t.Helper()
+
+	before := serve(t, router, "GET", path, user, "", nil)
+	if before.Code != http.StatusOK || before.Header().Get("ETag") == "" {
+		t.Fatalf("GET %s: got %d with ETag %q", path, before.Code, before.Header().Get("ETag"))
+	}
+	etag := before.Header().Get("ETag")
+	if w := serve(t, router, "GET", path, user, "", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Fatalf("GET %s with a current If-None-Match: got %d, want 304", path, w.Code)
+	}
+
+	change()
+
+	after := serve(t, router, "GET", path, user, "", http.Header{"If-None-Match": {etag}})
+	if after.Code != http.StatusOK {
+		t.Fatalf("GET %s after the change: got %d, want 200", path, after.Code)
+	}
+	if w := serve(t, router, "GET", path, user, "", http.Header{"If-None-Match": {after.Header().Get("ETag")}}); w.Code != http.StatusNotModified {
+		t.Errorf("GET %s with the new ETag: got %d, want 304", path, w.Code)
+	}
+}
+
+// serveJSON - Send body through the router with the user's token and decode
+// the JSON response
+func serveJSON(t *testing.T, router http.Handler, method, path string, user Users, body string) (int, map[string]any) {
+	t.Helper()
+
+	w := serve(t, router, method, path, user, body, nil)
+	var response map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("%s %s: %v: %s", method, path, err, w.Body.String())
+	}
+	return w.Code, response
+}
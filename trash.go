@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func GetContactTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	listQuery, errMsgs := parseContactListQuery(r, ctxUser.UserId)
+	if errMsgs != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": errMsgs,
+		})
+		return
+	}
+	listQuery.Trashed = true
+
+	query, args := listQuery.build()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	contacts := []Contacts{}
+	for rows.Next() {
+		var contact Contacts
+		if err := scanContact(rows, &contact); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		contacts = append(contacts, contact)
+	}
+
+	response := map[string]any{
+		"message": "Success",
+	}
+	if listQuery.Limit > 0 {
+		var nextCursor *string
+		if len(contacts) > listQuery.Limit {
+			contacts = contacts[:listQuery.Limit]
+			cursor := listQuery.nextCursor(contacts[len(contacts)-1])
+			nextCursor = &cursor
+		}
+		response["next_cursor"] = nextCursor
+	}
+	response["data"] = contacts
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response)
+}
+
+func RestoreContact(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var contact Contacts
+	err := scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NOT NULL", ps.ByName("id"), ctxUser.UserId), &contact)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found in trash",
+		})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+	defer tx.Rollback()
+
+	// only the addresses trashed together with the contact come back;
+	// ones deleted on their own earlier stay in the trash
+	actor := historyActor{UserId: ctxUser.UserId, Source: "api"}
+	addressIds, err := queryIds(tx, "SELECT address_id FROM addresses WHERE contact_id = ? AND deleted_at = ?", contact.ContactId, mysqlTime(contact.DeletedAt))
+	if err == nil {
+		err = execIn(tx, "UPDATE addresses SET deleted_at = NULL, version = version + 1 WHERE address_id IN", addressIds)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE contacts SET deleted_at = NULL, version = version + 1 WHERE contact_id = ? AND user_id = ?", contact.ContactId, ctxUser.UserId)
+	}
+	if err == nil {
+		err = recordContactHistory(tx, actor, "restored", contact.ContactId)
+	}
+	if err == nil {
+		err = recordAddressHistoryIn(tx, actor, "restored", addressIds)
+	}
+	if err == nil {
+		err = scanContact(tx.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ?", contact.ContactId), &contact)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	invalidateContactSuggest(ctxUser.UserId)
+
+	w.Header().Set("ETag", contactETag(contact))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Contact restored successfully",
+		"data":    contact,
+	})
+}
+
+func GetAddressTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("contactId"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	rows, err := db.Query("SELECT "+addressColumns+" FROM addresses WHERE contact_id = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC", ps.ByName("contactId"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Internal server error",
+		})
+		return
+	}
+	defer rows.Close()
+
+	addresses := []Addresses{}
+	for rows.Next() {
+		var address Addresses
+		if err := scanAddress(rows, &address); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{
+				"message": "Internal server error",
+			})
+			return
+		}
+		addresses = append(addresses, address)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Addresses retrieved successfully",
+		"data":    addresses,
+	})
+}
+
+func RestoreAddress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var count int
+	_ = db.QueryRow("SELECT COUNT(*) FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("contactId"), ctxUser.UserId).Scan(&count)
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	countRow, err := execAddressUpdate(db, historyActor{UserId: ctxUser.UserId, Source: "api"}, "restored", ps.ByName("addressId"), "UPDATE addresses SET deleted_at = NULL, version = version + 1 WHERE address_id = ? AND contact_id = ? AND deleted_at IS NOT NULL", ps.ByName("addressId"), ps.ByName("contactId"))
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Internal server error",
+		})
+		return
+	}
+
+	if countRow == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "Address not found in trash",
+		})
+		return
+	}
+
+	var address Addresses
+	if err := scanAddress(db.QueryRow("SELECT "+addressColumns+" FROM addresses WHERE address_id = ?", ps.ByName("addressId")), &address); err == nil {
+		w.Header().Set("ETag", addressETag(address))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": "Address restored successfully",
+		"data":    address,
+	})
+}
+
+// StartTrashPurger - Periodically remove trashed contacts and addresses
+// older than TRASH_RETENTION_DAYS
+func StartTrashPurger() {
+	retention := trashRetention()
+	interval, err := time.ParseDuration(getEnv("TRASH_PURGE_INTERVAL", "1h"))
+	if err != nil || interval <= 0 {
+		log.Printf("Invalid TRASH_PURGE_INTERVAL, using 1h")
+		interval = time.Hour
+	}
+
+	go func() {
+		for {
+			if err := purgeTrash(time.Now().UTC().Add(-retention)); err != nil {
+				log.Printf("Failed to purge trash: %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// softDeleteTime - deleted_at for a soft delete. It is taken from Go in UTC
+// like the purge cutoff, never from NOW(), so the retention is the same
+// whatever the MySQL session timezone is.
+func softDeleteTime() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// trashRetention - How long a deleted row stays restorable (TRASH_RETENTION_DAYS)
+func trashRetention() time.Duration {
+	retentionDays, err := strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", "30"))
+	if err != nil || retentionDays < 0 {
+		log.Printf("Invalid TRASH_RETENTION_DAYS, using 30")
+		retentionDays = 30
+	}
+	return time.Duration(retentionDays) * 24 * time.Hour
+}
+
+func purgeTrash(cutoff time.Time) error {
+	db := GetDB()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	addresses, err := tx.Exec("DELETE FROM addresses WHERE deleted_at < ? OR contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff, cutoff)
+	if err != nil {
+		return fmt.Errorf("purge addresses: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_tags WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact tags: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_group_members WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge group members: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_custom_values WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge custom field values: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_emails WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact emails: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_phones WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact phones: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_notes WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact notes: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_events WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact events: %w", err)
+	}
+	// a merge can't be undone once either side is gone, and its snapshot
+	// would keep the purged contact's data around
+	if _, err := tx.Exec("DELETE FROM contact_merges WHERE merged_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?) OR survivor_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff, cutoff); err != nil {
+		return fmt.Errorf("purge contact merges: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_history WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact history: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_relationships WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?) OR related_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff, cutoff); err != nil {
+		return fmt.Errorf("purge contact relationships: %w", err)
+	}
+	attachmentKeys, err := queryIds(tx, "SELECT blob_key FROM contact_attachments WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff)
+	if err != nil {
+		return fmt.Errorf("purge contact attachments: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM contact_attachments WHERE contact_id IN (SELECT contact_id FROM contacts WHERE deleted_at < ?)", cutoff); err != nil {
+		return fmt.Errorf("purge contact attachments: %w", err)
+	}
+	photos, err := tx.Query("SELECT photo_key, photo_type FROM contacts WHERE deleted_at < ? AND photo_key IS NOT NULL", cutoff)
+	if err != nil {
+		return fmt.Errorf("purge contact photos: %w", err)
+	}
+	photoTypes := map[string]string{}
+	for photos.Next() {
+		var key, contentType string
+		if err := photos.Scan(&key, &contentType); err != nil {
+			photos.Close()
+			return fmt.Errorf("purge contact photos: %w", err)
+		}
+		photoTypes[key] = contentType
+	}
+	photos.Close()
+	contacts, err := tx.Exec("DELETE FROM contacts WHERE deleted_at < ?", cutoff)
+	if err != nil {
+		return fmt.Errorf("purge contacts: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// blobs go only once the rows pointing at them are gone for good
+	for key, contentType := range photoTypes {
+		deletePhotoBlobs(key, contentType)
+	}
+	for _, key := range attachmentKeys {
+		deleteAttachmentBlob(key)
+	}
+
+	nAddresses, _ := addresses.RowsAffected()
+	nContacts, _ := contacts.RowsAffected()
+	if nAddresses > 0 || nContacts > 0 {
+		log.Printf("Purged %d contacts and %d addresses from trash", nContacts, nAddresses)
+	}
+	return nil
+}
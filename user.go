@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
@@ -18,6 +19,10 @@ type Users struct {
 	Password  string  `json:"password,omitempty" validate:"required"`
 	CreatedAt *string `json:"created_at,omitempty"`
 	UpdatedAt *string `json:"updated_at,omitempty"`
+	// region for phone numbers typed without a country code
+	DefaultRegion string `json:"default_region,omitempty"`
+	// IANA timezone used for "today" in upcoming events
+	Timezone string `json:"timezone,omitempty"`
 }
 
 func CreateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -174,7 +179,7 @@ func UserLogin(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 func GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	db := GetDB()
 
-	data, err := db.Query("SELECT user_id, name, email, created_at, updated_at FROM users")
+	data, err := db.Query("SELECT user_id, name, email, created_at, updated_at, default_region, timezone FROM users")
 	if err != nil {
 		fmt.Println("Error query:", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -190,7 +195,7 @@ func GetUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 	for data.Next() {
 		var user Users
-		err := data.Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+		err := data.Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.DefaultRegion, &user.Timezone)
 		if err != nil {
 			fmt.Println("Error scan:", err)
 			continue
@@ -214,7 +219,7 @@ func GetUserId(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	db := GetDB()
 
 	var user Users
-	err := db.QueryRow("SELECT user_id, name, email, created_at, updated_at FROM users WHERE user_id = ?", ps.ByName("id")).Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+	err := db.QueryRow("SELECT user_id, name, email, created_at, updated_at, default_region, timezone FROM users WHERE user_id = ?", ps.ByName("id")).Scan(&user.UserId, &user.Name, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.DefaultRegion, &user.Timezone)
 	if err != nil {
 		fmt.Println("Error query:", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -270,6 +275,24 @@ func UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
+	user.DefaultRegion = strings.ToUpper(user.DefaultRegion)
+	if user.DefaultRegion != "" && !validPhoneRegion(user.DefaultRegion) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"default_region must be a two letter region code such as ID"},
+		})
+		return
+	}
+	if user.Timezone != "" && !validTimezone(user.Timezone) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"timezone must be an IANA timezone such as Asia/Jakarta"},
+		})
+		return
+	}
+
 	db := GetDB()
 
 	var userId Users
@@ -284,7 +307,7 @@ func UpdateUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		return
 	}
 
-	_, err = db.Exec("UPDATE users SET name = ?, email = ? WHERE user_id = ?", user.Name, user.Email, ps.ByName("id"))
+	_, err = db.Exec("UPDATE users SET name = ?, email = ?, default_region = COALESCE(NULLIF(?, ''), default_region), timezone = COALESCE(NULLIF(?, ''), timezone) WHERE user_id = ?", user.Name, user.Email, user.DefaultRegion, user.Timezone, ps.ByName("id"))
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(500)
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/julienschmidt/httprouter"
+)
+
+const vcardMaxLineOctets = 75
+
+// vcardProperty - One unfolded content line: [group.]NAME;PARAM=..:VALUE
+type vcardProperty struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+type vcardCard struct {
+	Line       int
+	Properties []vcardProperty
+}
+
+type vcardImportResult struct {
+	Index     int      `json:"index"`
+	Line      int      `json:"line"`
+	Status    string   `json:"status"`
+	ContactId string   `json:"contact_id,omitempty"`
+	Unmapped  []string `json:"unmapped,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// vcardIgnored - Properties that carry no contact data and are never reported as unmapped
+var vcardIgnored = map[string]bool{
+	"BEGIN": true, "END": true, "VERSION": true, "PRODID": true, "UID": true, "REV": true, "KIND": true,
+}
+
+func vcardEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\r\n", `\n`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	s = strings.ReplaceAll(s, ",", `\,`)
+	s = strings.ReplaceAll(s, ";", `\;`)
+	return s
+}
+
+func vcardUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// vcardSplit - Split a structured value on unescaped separators
+func vcardSplit(s string, sep byte) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// vcardFoldLine - Write one content line folded at 75 octets without
+// splitting a UTF-8 sequence (RFC 6350 section 3.2)
+func vcardFoldLine(w io.Writer, line string) error {
+	limit := vcardMaxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if _, err := io.WriteString(w, line[:cut]+"\r\n "); err != nil {
+			return err
+		}
+		line = line[cut:]
+		// continuation lines start with a space, which counts towards the limit
+		limit = vcardMaxLineOctets - 1
+	}
+	_, err := io.WriteString(w, line+"\r\n")
+	return err
+}
+
+// writeVCard - Serialize a contact and its addresses as a vCard 3.0 or 4.0
+func writeVCard(w io.Writer, contact Contacts, addresses []Addresses, version string) error {
+	emailParam, telParam := ";TYPE=INTERNET", ";TYPE=VOICE"
+	if version == "4.0" {
+		emailParam, telParam = "", ";VALUE=text"
+	}
+
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:" + version,
+		"PRODID:-//Contact Management API//EN",
+		"UID:" + vcardUID(contact),
+		"FN:" + vcardEscape(strings.TrimSpace(contact.FirstName+" "+contact.LastName)),
+		"N:" + vcardEscape(contact.LastName) + ";" + vcardEscape(contact.FirstName) + ";;;",
+	}
+	if contact.Email != "" {
+		lines = append(lines, "EMAIL"+emailParam+":"+vcardEscape(contact.Email))
+	}
+	if contact.Phone != "" {
+		lines = append(lines, "TEL"+telParam+":"+vcardEscape(contact.Phone))
+	}
+	for _, a := range addresses {
+		// ADR: PO box; extended; street; locality; region; postal code; country
+		lines = append(lines, "ADR:;;"+vcardEscape(a.Street)+";"+vcardEscape(a.City)+";"+vcardEscape(a.Province)+";"+vcardEscape(a.PostalCode)+";"+vcardEscape(a.Country))
+	}
+	if rev := vcardRev(contact); rev != "" {
+		lines = append(lines, "REV:"+rev)
+	}
+	lines = append(lines, "END:VCARD")
+
+	for _, line := range lines {
+		if err := vcardFoldLine(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func vcardUID(contact Contacts) string {
+	if contact.VCardUID != nil {
+		return *contact.VCardUID
+	}
+	return "contact-" + contact.ContactId
+}
+
+func vcardRev(contact Contacts) string {
+	v := contact.UpdatedAt
+	if v == nil {
+		v = contact.CreatedAt
+	}
+	if v == nil {
+		return ""
+	}
+	t, err := time.Parse(time.RFC3339Nano, *v)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// parseVCards - Unfold and split a (multi-card) vCard stream. Line numbers
+// refer to the first physical line of each card.
+func parseVCards(r io.Reader) ([]vcardCard, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	// unfold: a line starting with space or tab continues the previous one
+	type logicalLine struct {
+		line int
+		text string
+	}
+	logical := []logicalLine{}
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(logical) > 0 {
+			logical[len(logical)-1].text += text[1:]
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		logical = append(logical, logicalLine{line: lineNo, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	cards := []vcardCard{}
+	var current *vcardCard
+	for _, l := range logical {
+		prop, err := parseVCardLine(l.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.line, err)
+		}
+		switch {
+		case prop.Name == "BEGIN" && strings.EqualFold(prop.Value, "VCARD"):
+			if current != nil {
+				return nil, fmt.Errorf("line %d: nested BEGIN:VCARD", l.line)
+			}
+			current = &vcardCard{Line: l.line}
+		case prop.Name == "END" && strings.EqualFold(prop.Value, "VCARD"):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: END:VCARD without BEGIN", l.line)
+			}
+			cards = append(cards, *current)
+			current = nil
+		case current == nil:
+			return nil, fmt.Errorf("line %d: property outside of a vCard", l.line)
+		default:
+			current.Properties = append(current.Properties, prop)
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("line %d: missing END:VCARD", current.Line)
+	}
+	return cards, nil
+}
+
+func parseVCardLine(text string) (vcardProperty, error) {
+	prop := vcardProperty{Params: map[string][]string{}}
+
+	// the value starts at the first colon that is not inside a quoted parameter
+	colon := -1
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		if text[i] == '"' {
+			quoted = !quoted
+		} else if text[i] == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return prop, fmt.Errorf("invalid content line %q", text)
+	}
+	prop.Value = text[colon+1:]
+
+	head := strings.Split(text[:colon], ";")
+	name := head[0]
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	prop.Name = strings.ToUpper(name)
+
+	for _, param := range head[1:] {
+		key, value, found := strings.Cut(param, "=")
+		key = strings.ToUpper(key)
+		if !found {
+			// vCard 2.1/3.0 bare TYPE values such as TEL;CELL
+			prop.Params["TYPE"] = append(prop.Params["TYPE"], strings.ToLower(key))
+			continue
+		}
+		for _, v := range strings.Split(value, ",") {
+			prop.Params[key] = append(prop.Params[key], strings.ToLower(strings.Trim(v, `"`)))
+		}
+	}
+	return prop, nil
+}
+
+// mapVCard - Convert a parsed card to a contact plus addresses, listing the
+// properties that have no place in the data model
+func mapVCard(card vcardCard) (Contacts, []Addresses, []string) {
+	var contact Contacts
+	addresses := []Addresses{}
+	unmapped := []string{}
+	fn := ""
+
+	for _, prop := range card.Properties {
+		switch prop.Name {
+		case "FN":
+			fn = vcardUnescape(prop.Value)
+		case "N":
+			parts := vcardSplit(prop.Value, ';')
+			contact.LastName = vcardUnescape(parts[0])
+			if len(parts) > 1 {
+				contact.FirstName = vcardUnescape(parts[1])
+			}
+			if len(parts) > 2 && strings.TrimSpace(parts[2]) != "" {
+				unmapped = append(unmapped, "N (additional names)")
+			}
+		case "EMAIL":
+			if contact.Email == "" {
+				contact.Email = strings.TrimSpace(vcardUnescape(prop.Value))
+			} else {
+				unmapped = append(unmapped, "EMAIL (additional)")
+			}
+		case "TEL":
+			value := strings.TrimPrefix(strings.TrimSpace(vcardUnescape(prop.Value)), "tel:")
+			if contact.Phone == "" {
+				contact.Phone = value
+			} else {
+				unmapped = append(unmapped, "TEL (additional)")
+			}
+		case "ADR":
+			parts := vcardSplit(prop.Value, ';')
+			for len(parts) < 7 {
+				parts = append(parts, "")
+			}
+			address := Addresses{
+				Street:     strings.TrimSpace(strings.Join(nonEmpty(vcardUnescape(parts[0]), vcardUnescape(parts[1]), vcardUnescape(parts[2])), ", ")),
+				City:       vcardUnescape(parts[3]),
+				Province:   vcardUnescape(parts[4]),
+				PostalCode: vcardUnescape(parts[5]),
+				Country:    vcardUnescape(parts[6]),
+			}
+			if address.Country == "" {
+				unmapped = append(unmapped, "ADR (missing country)")
+				continue
+			}
+			addresses = append(addresses, address)
+		default:
+			if !vcardIgnored[prop.Name] {
+				unmapped = append(unmapped, prop.Name)
+			}
+		}
+	}
+
+	if contact.FirstName == "" && contact.LastName == "" && fn != "" {
+		first, last, _ := strings.Cut(fn, " ")
+		contact.FirstName, contact.LastName = first, strings.TrimSpace(last)
+	}
+	return contact, addresses, unmapped
+}
+
+func nonEmpty(values ...string) []string {
+	result := []string{}
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func vcardVersion(r *http.Request) (string, bool) {
+	switch v := r.URL.Query().Get("version"); v {
+	case "", "3.0", "3":
+		return "3.0", true
+	case "4.0", "4":
+		return "4.0", true
+	}
+	return "", false
+}
+
+func GetContactVCard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	version, ok := vcardVersion(r)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{"version must be 3.0 or 4.0"},
+		})
+		return
+	}
+
+	db := GetDB()
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	var contact Contacts
+	err := scanContact(db.QueryRow("SELECT "+contactColumns+" FROM contacts WHERE contact_id = ? AND user_id = ? AND deleted_at IS NULL", ps.ByName("id"), ctxUser.UserId), &contact)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Contact not found",
+		})
+		return
+	}
+
+	addresses, err := loadAddressesByContact([]string{contact.ContactId})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="contact-`+contact.ContactId+`.vcf"`)
+	w.WriteHeader(200)
+	writeVCard(w, contact, addresses[contact.ContactId], version)
+}
+
+type vcfContactExport struct {
+	out     io.Writer
+	version string
+}
+
+func (e *vcfContactExport) begin() error {
+	return nil
+}
+
+func (e *vcfContactExport) write(contacts []Contacts, addresses map[string][]Addresses) error {
+	for _, c := range contacts {
+		if err := writeVCard(e.out, c, addresses[c.ContactId], e.version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *vcfContactExport) end() error {
+	return nil
+}
+
+// importVCards - POST /contact/import with a text/vcard body
+func importVCards(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(importEnvInt("IMPORT_MAX_BYTES", 20<<20)))
+	cards, err := parseVCards(r.Body)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]any{
+			"errors": []string{err.Error()},
+		})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	skipInvalid, _ := strconv.ParseBool(r.URL.Query().Get("skip_invalid"))
+
+	ctxUser := r.Context().Value("user").(Users)
+
+	// a vCard can't carry custom fields, required ones fail every card
+	fields, err := loadCustomFields(GetDB(), ctxUser.UserId)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(map[string]any{
+			"message": "Internal Server Error",
+		})
+		return
+	}
+
+	validate := validator.New()
+
+	type mappedCard struct {
+		contact   Contacts
+		addresses []Addresses
+	}
+	mapped := make([]*mappedCard, len(cards))
+	results := make([]vcardImportResult, len(cards))
+	invalid := 0
+	for i, card := range cards {
+		contact, addresses, unmapped := mapVCard(card)
+		results[i] = vcardImportResult{Index: i, Line: card.Line, Status: "valid", Unmapped: unmapped}
+		if err := validate.Struct(contact); err != nil {
+			results[i].Status = "invalid"
+			results[i].Errors = validationMessages(err)
+			invalid++
+			continue
+		}
+		if err := normalizeContactPhone(&contact, userPhoneRegion(ctxUser), nil); err != nil {
+			results[i].Status = "invalid"
+			results[i].Errors = []string{err.Error()}
+			invalid++
+			continue
+		}
+		if errMsgs := validateCustomFieldValues(fields, contact.CustomFields); len(errMsgs) > 0 {
+			results[i].Status = "invalid"
+			results[i].Errors = errMsgs
+			invalid++
+			continue
+		}
+		mapped[i] = &mappedCard{contact: contact, addresses: addresses}
+	}
+
+	message := "Import completed"
+	status := 200
+	switch {
+	case dryRun:
+		message = "Dry run completed, nothing was imported"
+	case invalid > 0 && !skipInvalid:
+		message = "Some cards are invalid, nothing was imported"
+		status = 400
+	default:
+		tx, err := GetDB().Begin()
+		if err == nil {
+			defer tx.Rollback()
+			for i, m := range mapped {
+				if m == nil {
+					continue
+				}
+				if results[i].ContactId, err = insertContact(tx, m.contact, ctxUser.UserId, historyActor{UserId: ctxUser.UserId, Source: "import"}); err != nil {
+					break
+				}
+				for _, address := range m.addresses {
+					address.ContactId = results[i].ContactId
+					if err = insertAddressRow(tx, address, historyActor{UserId: ctxUser.UserId, Source: "import"}); err != nil {
+						break
+					}
+				}
+				if err != nil {
+					break
+				}
+				results[i].Status = "imported"
+			}
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("vCard import failed: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message": "Internal Server Error",
+			})
+			return
+		}
+		invalidateContactSuggest(ctxUser.UserId)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"message": message,
+		"data": map[string]any{
+			"dry_run":     dryRun,
+			"total_cards": len(cards),
+			"invalid":     invalid,
+			"results":     results,
+		},
+	})
+}